@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
+	"github.com/twells46/gomangatool/internal/export"
+	"github.com/twells46/gomangatool/internal/opds"
+	"github.com/twells46/gomangatool/internal/web"
+)
+
+// Run a subcommand and exit.
+func runCommand(ctx context.Context, cfg config.Config, opts backend.Options, name string, args []string) {
+	switch name {
+	case "export-json":
+		exportJSON(ctx, openStore(ctx, cfg, opts), args)
+	case "import-json":
+		importJSON(ctx, openStore(ctx, cfg, opts), opts, args)
+	case "cleanup":
+		cleanup(ctx, openStore(ctx, cfg, opts), opts, args)
+	case "retention":
+		setRetention(ctx, openStore(ctx, cfg, opts), opts, args)
+	case "processing":
+		setProcessing(ctx, openStore(ctx, cfg, opts), opts, args)
+	case "check":
+		checkLibrary(ctx, openStore(ctx, cfg, opts), opts, args)
+	case "opds":
+		serveOPDS(ctx, cfg, opts, args)
+	case "web":
+		serveWeb(ctx, cfg, opts, args)
+	default:
+		if format, ok := strings.CutPrefix(name, "export-"); ok && export.Formats[format].Name != "" {
+			exportBooks(ctx, cfg, opts, export.Formats[format], args)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage:
+  gomangatool [FLAGS]                           Start the TUI
+  gomangatool [FLAGS] export-json FILE          Write the library to FILE as JSON, or stdout if FILE is -
+  gomangatool [FLAGS] import-json [-n] FILE     Merge a library written by export-json into this one
+  gomangatool [FLAGS] cleanup [-n]              Delete the pages of read chapters as the cleanup policies say,
+                                                or with -n only report what would be deleted
+  gomangatool [FLAGS] retention SERIES [POLICY] Show or set the cleanup policy of SERIES:
+                                                all, days:N, last:N, or default to use the retention setting
+  gomangatool [FLAGS] processing SERIES [SPEC]  Show or set how the downloaded pages of SERIES are processed:
+                                                none, a list like gray,height:1600,quality:80,
+                                                or default to use the processing setting
+  gomangatool [FLAGS] check [-repair] [-redownload]
+                                                Check downloaded chapters against the library folder,
+                                                and with -repair fix which are marked downloaded.
+                                                With -redownload broken chapters are downloaded again
+  gomangatool [FLAGS] opds                      Serve the library as an OPDS catalog at http://SERVE-ADDR/opds
+  gomangatool [FLAGS] web                       Serve a web reader at http://SERVE-ADDR/, along with the OPDS catalog
+  gomangatool [FLAGS] export-FORMAT [-o DIR] [-volumes | -range] SERIES NUMBER...
+                                                Pack chapters of SERIES, given by abbreviated title or ID,
+                                                into cbz, epub or pdf FORMAT files, downloading them first if needed.
+                                                With -volumes the NUMBERs are whole volumes, every one if none are given.
+                                                With -range the two NUMBERs are the first and last chapters of one book
+
+Run gomangatool -h to list the FLAGS, which override the config file.
+`)
+}
+
+// Write the library to a JSON file
+func exportJSON(ctx context.Context, store backend.Store, args []string) {
+	fs := flag.NewFlagSet("export-json", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	out := os.Stdout
+	if fs.Arg(0) != "-" {
+		f, err := os.Create(fs.Arg(0))
+		if err != nil {
+			log.Fatalf("%s: Failed to create %s", err, fs.Arg(0))
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := backend.ExportLibrary(ctx, store, out); err != nil {
+		log.Fatalf("%s: Failed to export library", err)
+	}
+}
+
+// Merge a JSON library into this one
+func importJSON(ctx context.Context, store backend.Store, opts backend.Options, args []string) {
+	fs := flag.NewFlagSet("import-json", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "Dry run: report what would change without changing anything")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	in := os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatalf("%s: Failed to open %s", err, fs.Arg(0))
+		}
+		defer f.Close()
+		in = f
+	}
+
+	rep, err := backend.ImportLibrary(ctx, store, opts.Layout, in, *dryRun)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Print(rep)
+}
+
+// Delete the pages of read chapters according to the cleanup policies
+func cleanup(ctx context.Context, store backend.Store, opts backend.Options, args []string) {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "Dry run: report what would be deleted without deleting anything")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	fmt.Print(backend.Cleanup(ctx, store, opts, opts.Retention, time.Now(), *dryRun))
+}
+
+// Show or set the cleanup policy of a series
+func setRetention(ctx context.Context, store backend.Store, opts backend.Options, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	m := findSeries(ctx, store, args[0])
+	if len(args) == 2 {
+		policy := args[1]
+		if policy == "default" {
+			policy = ""
+		} else if _, err := backend.ParseRetention(policy); err != nil {
+			log.Fatalln(err)
+		}
+		m = store.UpdateRetention(ctx, m, policy)
+	}
+
+	if m.Retention == "" {
+		fmt.Printf("%s: %s (default)\n", m.SerTitle, opts.Retention)
+	} else {
+		fmt.Printf("%s: %s\n", m.SerTitle, m.RetentionPolicy(opts.Retention))
+	}
+}
+
+// Show or set how the downloaded pages of a series are processed
+func setProcessing(ctx context.Context, store backend.Store, opts backend.Options, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	m := findSeries(ctx, store, args[0])
+	if len(args) == 2 {
+		processing := args[1]
+		if processing == "default" {
+			processing = ""
+		} else if _, err := backend.ParseProcessing(processing); err != nil {
+			log.Fatalln(err)
+		}
+		m = store.UpdateProcessing(ctx, m, processing)
+	}
+
+	if m.Processing == "" {
+		fmt.Printf("%s: %s (default)\n", m.SerTitle, opts.Processing)
+	} else {
+		fmt.Printf("%s: %s\n", m.SerTitle, m.ProcessingPolicy(opts.Processing))
+	}
+}
+
+// Serve the OPDS catalog until interrupted
+func serveOPDS(ctx context.Context, cfg config.Config, opts backend.Options, args []string) {
+	if len(args) != 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	log.Printf("Serving the OPDS catalog at http://%s/opds", cfg.ServeAddr)
+	serve(ctx, cfg.ServeAddr, opds.NewServer(ctx, openStore(ctx, cfg, opts), opts).Handler())
+}
+
+// Serve the web reader, along with the OPDS catalog, until interrupted
+func serveWeb(ctx context.Context, cfg config.Config, opts backend.Options, args []string) {
+	if len(args) != 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	store := openStore(ctx, cfg, opts)
+	mux := http.NewServeMux()
+	mux.Handle("/", web.NewServer(ctx, store, opts).Handler())
+	catalog := opds.NewServer(ctx, store, opts).Handler()
+	mux.Handle("/opds", catalog)
+	mux.Handle("/opds/", catalog)
+
+	log.Printf("Serving the web reader at http://%s/ and the OPDS catalog at http://%s/opds", cfg.ServeAddr, cfg.ServeAddr)
+	serve(ctx, cfg.ServeAddr, mux)
+}
+
+// Serve HTTP on addr until ctx is cancelled
+func serve(ctx context.Context, addr string, h http.Handler) {
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     h,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalln(err)
+	}
+}
+
+// Check the library for chapters whose pages don't match the DB
+func checkLibrary(ctx context.Context, store backend.Store, opts backend.Options, args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "Fix which chapters are marked downloaded")
+	redownload := fs.Bool("redownload", false, "Download broken chapters again, rather than only marking them not downloaded")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	problems := backend.CheckLibrary(ctx, store, opts.Layout)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d problems found\n", len(problems))
+
+	if *repair || *redownload {
+		for _, c := range backend.RepairLibrary(ctx, store, opts, problems, *redownload) {
+			fmt.Println(c)
+		}
+	} else if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Export chapters or volumes of a series as books in the given format
+func exportBooks(ctx context.Context, cfg config.Config, opts backend.Options, f export.Format, args []string) {
+	fs := flag.NewFlagSet("export-"+f.Name, flag.ExitOnError)
+	dir := fs.String("o", cfg.ExportDir, "Folder to write the books to")
+	volumes := fs.Bool("volumes", false, "Export whole volumes rather than chapters")
+	chapterRange := fs.Bool("range", false, "Export every chapter from the first NUMBER to the second as one book")
+	fs.Parse(args)
+	switch {
+	case fs.NArg() < 1,
+		fs.NArg() < 2 && !*volumes,
+		*chapterRange && (*volumes || fs.NArg() != 3):
+		usage()
+		os.Exit(2)
+	}
+
+	store := openStore(ctx, cfg, opts)
+	m := findSeries(ctx, store, fs.Arg(0))
+	books := make([]export.Book, 0)
+	if *chapterRange {
+		first, err1 := strconv.ParseFloat(fs.Arg(1), 64)
+		last, err2 := strconv.ParseFloat(fs.Arg(2), 64)
+		if err1 != nil || err2 != nil {
+			log.Fatalf("A range needs two chapter numbers, not %s and %s", fs.Arg(1), fs.Arg(2))
+		}
+		b, err := export.RangeBook(m, first, last)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		books = append(books, b)
+	} else if *volumes {
+		for _, v := range volumeNumbers(m, fs.Args()[1:]) {
+			b, err := export.VolumeBook(m, v)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			books = append(books, b)
+		}
+	} else {
+		for _, n := range fs.Args()[1:] {
+			books = append(books, export.ChapterBook(m, findChapter(m, n)))
+		}
+	}
+
+	for _, b := range books {
+		path, err := export.Save(ctx, store, opts, f, b, *dir)
+		if ctx.Err() != nil {
+			log.Print("Interrupted")
+			os.Exit(130)
+		} else if err != nil {
+			log.Fatalf("%s: Failed to export %s", err, b.Name())
+		}
+		fmt.Println(path)
+	}
+}
+
+// Find a series by its abbreviated title or MangaDex ID, with its chapters
+func findSeries(ctx context.Context, store backend.Store, name string) backend.Manga {
+	m, ok := backend.FindSeries(ctx, store, name)
+	if !ok {
+		log.Fatalf("No series %s in the library", name)
+	}
+	return m
+}
+
+// Find a chapter by its number as displayed or as given by MangaDex.
+// Where there's more than one version, a downloaded one is preferred.
+func findChapter(m backend.Manga, number string) backend.Chapter {
+	found := make([]backend.Chapter, 0)
+	for _, c := range m.Chapters {
+		if strings.EqualFold(c.Number().String(), number) || strings.EqualFold(strings.TrimSpace(c.ChapterRaw), number) {
+			found = append(found, c)
+		}
+	}
+	if len(found) == 0 {
+		log.Fatalf("%s has no chapter %s", m.FullTitle, number)
+	}
+	if i := slices.IndexFunc(found, func(c backend.Chapter) bool { return c.Downloaded }); i >= 0 {
+		return found[i]
+	}
+	return found[0]
+}
+
+// Parse the given volume numbers, or list every volume of m if there are none
+func volumeNumbers(m backend.Manga, args []string) []int {
+	vols := make([]int, 0)
+	for _, a := range args {
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			log.Fatalf("%s: Bad volume number", err)
+		}
+		vols = append(vols, v)
+	}
+	if len(args) > 0 {
+		return vols
+	}
+
+	for _, c := range m.Chapters {
+		if !slices.Contains(vols, c.VolumeNum) {
+			vols = append(vols, c.VolumeNum)
+		}
+	}
+	slices.Sort(vols)
+	return vols
+}
@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The longest abbreviated title the Manga table holds
+const maxAbbrevLen = 32
+
+// How long a suggested abbreviation can get before words are left off
+const slugLen = 20
+
+var (
+	abbrevRe  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	mangaIDRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// Suggest an abbreviated title from a full title: its first few words,
+// lowercased and joined by underscores.
+// Anything but ASCII letters and digits separates words, so a title without
+// any gets a generic suggestion.
+func SlugTitle(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return r >= utf8.RuneSelf || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+	})
+	if len(words) == 0 {
+		return "series"
+	}
+
+	slug := words[0][:min(len(words[0]), slugLen)]
+	for _, w := range words[1:] {
+		if len(slug)+1+len(w) > slugLen {
+			break
+		}
+		slug += "_" + w
+	}
+	return slug
+}
+
+// Check that an abbreviated title is safe to use as a folder name.
+func ValidateAbbrev(abbrev string) error {
+	switch {
+	case abbrev == "":
+		return errors.New("the abbreviated title can't be empty")
+	case strings.ContainsAny(abbrev, `/\`) || strings.Contains(abbrev, ".."):
+		return errors.New(`the abbreviated title can't contain /, \ or ..`)
+	case len(abbrev) > maxAbbrevLen:
+		return fmt.Errorf("the abbreviated title can't be longer than %d characters", maxAbbrevLen)
+	case !abbrevRe.MatchString(abbrev):
+		return errors.New("the abbreviated title can only use letters, numbers, _, - and ., starting with a letter or number")
+	}
+	return nil
+}
+
+// Check that a MangaDex ID looks right and isn't in the library yet.
+func CheckNewID(ctx context.Context, store Store, mangaID string) error {
+	if !mangaIDRe.MatchString(mangaID) {
+		return fmt.Errorf("%s isn't a MangaDex ID, which looks like aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", mangaID)
+	}
+	if store.mangaExists(ctx, mangaID) {
+		return errors.New("that series is already in the library")
+	}
+	return nil
+}
+
+// Check that an abbreviated title is valid and not used by another series.
+func CheckNewAbbrev(ctx context.Context, store Store, abbrev string) error {
+	if err := ValidateAbbrev(abbrev); err != nil {
+		return err
+	}
+	if _, taken := store.serTitleID(ctx, abbrev); taken {
+		return fmt.Errorf("%s is already used by another series, choose a different abbreviation", abbrev)
+	}
+	return nil
+}
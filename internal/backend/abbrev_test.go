@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Frieren: Beyond Journey's End", "frieren_beyond"},
+		{"Chainsaw Man", "chainsaw_man"},
+		{"The Apothecary Diaries", "the_apothecary"},
+		{"Yotsuba&!", "yotsuba"},
+		{"20th Century Boys", "20th_century_boys"},
+		{"Pneumonoultramicroscopicsilicovolcanoconiosis", "pneumonoultramicrosc"},
+		{"ワンパンマン", "series"},
+		{"Kimi ni Todoke – 君に届け", "kimi_ni_todoke"},
+		{"", "series"},
+	}
+	for _, tt := range tests {
+		got := SlugTitle(tt.title)
+		if got != tt.want {
+			t.Errorf("SlugTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+		if err := ValidateAbbrev(got); err != nil {
+			t.Errorf("SlugTitle(%q) = %q, which isn't valid: %s", tt.title, got, err)
+		}
+	}
+}
+
+func TestValidateAbbrev(t *testing.T) {
+	tests := []struct {
+		abbrev string
+		ok     bool
+	}{
+		{"frieren", true},
+		{"Vol2.5-extra_x", true},
+		{"9nine", true},
+		{strings.Repeat("a", maxAbbrevLen), true},
+		{strings.Repeat("a", maxAbbrevLen+1), false},
+		{"", false},
+		{"..", false},
+		{"a..b", false},
+		{"../escape", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{".hidden", false},
+		{"-flag", false},
+		{"has space", false},
+		{"ünïcode", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateAbbrev(tt.abbrev); (err == nil) != tt.ok {
+			t.Errorf("ValidateAbbrev(%q) = %v, want ok %t", tt.abbrev, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// What kind of chapter a ChapterNumber refers to
+type ChapterKind int
+
+const (
+	KindNumbered ChapterKind = iota
+	KindOneshot
+	KindExtra
+)
+
+// A chapter number as MangaDex gives it, which isn't always a number:
+// besides 12 and 12.5 there's 12a, 10-11, Extra 2, and no number at all for oneshots.
+type ChapterNumber struct {
+	Raw    string
+	Kind   ChapterKind
+	Num    float64 // 0 if there's no number
+	Suffix string  // A letter after the number, like the a of 12a
+	End    float64 // The end of a range like 10-11, otherwise 0
+	zeros  int     // Zeros written at the end of a decimal, which tell 1.10 from 1.1
+}
+
+var (
+	numberedRe = regexp.MustCompile(`^(?:ch(?:apter|\.)?\s*)?(\d+(?:\.\d+)?)\s*(?:([a-z]{1,3})|[-~–]\s*(\d+(?:\.\d+)?))?$`)
+	extraRe    = regexp.MustCompile(`^(?:extra|special|omake|bonus|side story|sp)\.?\s*(\d+(?:\.\d+)?)?$`)
+	oneshotRe  = regexp.MustCompile(`^(?:one[- ]?shot)?$`)
+)
+
+// Parse a chapter number. Anything that isn't recognized is kept as an extra,
+// so parsing never fails.
+func ParseChapterNumber(raw string) ChapterNumber {
+	n := ChapterNumber{Raw: raw}
+	s := strings.ToLower(strings.TrimSpace(raw))
+
+	if m := numberedRe.FindStringSubmatch(s); m != nil {
+		n.Kind = KindNumbered
+		n.Num, _ = strconv.ParseFloat(m[1], 64)
+		if _, frac, ok := strings.Cut(m[1], "."); ok && strings.TrimRight(frac, "0") != "" {
+			n.zeros = len(frac) - len(strings.TrimRight(frac, "0"))
+		}
+		n.Suffix = m[2]
+		n.End, _ = strconv.ParseFloat(m[3], 64)
+	} else if oneshotRe.MatchString(s) {
+		n.Kind = KindOneshot
+	} else {
+		n.Kind = KindExtra
+		if m := extraRe.FindStringSubmatch(s); m != nil {
+			n.Num, _ = strconv.ParseFloat(m[1], 64)
+		}
+	}
+
+	return n
+}
+
+// A key that sorts chapter numbers into reading order when compared as strings:
+// oneshots first, then numbered chapters, then extras.
+// Chapters with the same number sort plain, then ranges, then by suffix.
+// 1.10 is the same number as 1.1, so it sorts right after it.
+func (n ChapterNumber) Key() string {
+	num := min(max(n.Num, 0), 1e10)
+	end := min(max(n.End, 0), 1e10)
+	key := fmt.Sprintf("%d%016.4f%d%-3s%016.4f", n.Kind.rank(), num, min(n.zeros, 9), n.Suffix, end)
+	if n.Kind == KindExtra {
+		// Keep different extras with the same number apart
+		key += strings.ToLower(strings.TrimSpace(n.Raw))
+	}
+	return key
+}
+
+// Where each kind sorts relative to the others
+func (k ChapterKind) rank() int {
+	switch k {
+	case KindOneshot:
+		return 0
+	case KindNumbered:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (n ChapterNumber) String() string {
+	switch n.Kind {
+	case KindOneshot:
+		return "Oneshot"
+	case KindNumbered:
+		if n.End > 0 {
+			return fmt.Sprintf("%g-%g", n.Num, n.End)
+		}
+		return fmt.Sprintf("%g%s%s", n.Num, strings.Repeat("0", n.zeros), n.Suffix)
+	default:
+		return strings.TrimSpace(n.Raw)
+	}
+}
+
+// Set the chapter number of a Chapter from the raw string,
+// filling in the parsed number and sort key along with it.
+// Only numbered chapters have a ChapterNum, everything else is 0.
+func setChapterNumber(c *Chapter, raw string) {
+	n := ParseChapterNumber(raw)
+	c.ChapterRaw = raw
+	c.SortKey = n.Key()
+	c.ChapterNum = 0
+	if n.Kind == KindNumbered {
+		c.ChapterNum = n.Num
+	}
+}
@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseChapterNumber(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want ChapterNumber
+		str  string
+	}{
+		{"12", ChapterNumber{Kind: KindNumbered, Num: 12}, "12"},
+		{"12.5", ChapterNumber{Kind: KindNumbered, Num: 12.5}, "12.5"},
+		{"012", ChapterNumber{Kind: KindNumbered, Num: 12}, "12"},
+		{"12.0", ChapterNumber{Kind: KindNumbered, Num: 12}, "12"},
+		{"1.1", ChapterNumber{Kind: KindNumbered, Num: 1.1}, "1.1"},
+		{"1.10", ChapterNumber{Kind: KindNumbered, Num: 1.1, zeros: 1}, "1.10"},
+		{"12a", ChapterNumber{Kind: KindNumbered, Num: 12, Suffix: "a"}, "12a"},
+		{"12 B", ChapterNumber{Kind: KindNumbered, Num: 12, Suffix: "b"}, "12b"},
+		{"10-11", ChapterNumber{Kind: KindNumbered, Num: 10, End: 11}, "10-11"},
+		{"10 ~ 11.5", ChapterNumber{Kind: KindNumbered, Num: 10, End: 11.5}, "10-11.5"},
+		{"ch. 5", ChapterNumber{Kind: KindNumbered, Num: 5}, "5"},
+		{"Chapter 5", ChapterNumber{Kind: KindNumbered, Num: 5}, "5"},
+		{"ch5", ChapterNumber{Kind: KindNumbered, Num: 5}, "5"},
+		{"", ChapterNumber{Kind: KindOneshot}, "Oneshot"},
+		{"  ", ChapterNumber{Kind: KindOneshot}, "Oneshot"},
+		{"Oneshot", ChapterNumber{Kind: KindOneshot}, "Oneshot"},
+		{"one-shot", ChapterNumber{Kind: KindOneshot}, "Oneshot"},
+		{"Extra", ChapterNumber{Kind: KindExtra}, "Extra"},
+		{"Extra 2", ChapterNumber{Kind: KindExtra, Num: 2}, "Extra 2"},
+		{"Special.3", ChapterNumber{Kind: KindExtra, Num: 3}, "Special.3"},
+		{"Prologue", ChapterNumber{Kind: KindExtra}, "Prologue"},
+	}
+	for _, tt := range tests {
+		tt.want.Raw = tt.raw
+		got := ParseChapterNumber(tt.raw)
+		if got != tt.want {
+			t.Errorf("ParseChapterNumber(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+		if got.String() != tt.str {
+			t.Errorf("ParseChapterNumber(%q).String() = %q, want %q", tt.raw, got, tt.str)
+		}
+	}
+}
+
+func TestChapterKeyOrder(t *testing.T) {
+	// In reading order, with numbers that have to sort the same on their own lines
+	order := [][]string{
+		{"", "Oneshot"},
+		{"0"},
+		{"1", "ch. 1", "01", "1.0"},
+		{"1.1"},
+		{"1.10"},
+		{"1.2"},
+		{"1.9"},
+		{"2"},
+		{"2-3"},
+		{"2a"},
+		{"2b"},
+		{"10"},
+		{"10.5"},
+		{"100"},
+		{"Extra"},
+		{"Special 1"},
+		{"Extra 2"},
+	}
+	prev := ""
+	for i, same := range order {
+		key := ParseChapterNumber(same[0]).Key()
+		for _, raw := range same[1:] {
+			if k := ParseChapterNumber(raw).Key(); k != key {
+				t.Errorf("%q has key %q, want the same as %q, %q", raw, k, same[0], key)
+			}
+		}
+		if i > 0 && key <= prev {
+			t.Errorf("%q has key %q, want after %q", same[0], key, prev)
+		}
+		prev = key
+	}
+}
+
+// Chapter numbers are shown as MangaDex gave them, not as the float ChapterNum
+func TestChapterNumberTitles(t *testing.T) {
+	e := ReadEvent{FullTitle: "Series", ChapterRaw: "12a", ChapterName: "Part one"}
+	if got := e.Title(); got != "Series - 12a: Part one" {
+		t.Errorf("ReadEvent title %q", got)
+	}
+	e.ChapterRaw = ""
+	if got := e.Title(); got != "Series - Oneshot: Part one" {
+		t.Errorf("Oneshot ReadEvent title %q", got)
+	}
+
+	var chapters []Chapter
+	for i, raw := range []string{"1", "1.10", "4", "Extra"} {
+		c := Chapter{ChapterHash: raw, VolumeNum: 1 + i/2}
+		setChapterNumber(&c, raw)
+		chapters = append(chapters, c)
+	}
+	agg := Aggregate{2: {ParseChapterNumber("4"), ParseChapterNumber("5"), ParseChapterNumber("5.10")}}
+	var got []string
+	for _, g := range FindGaps(chapters, agg) {
+		got = append(got, g.Title())
+	}
+	if want := []string{"2-3: Missing", "5-5.10: Missing"}; !slices.Equal(got, want) {
+		t.Errorf("Gap titles %q, want %q", got, want)
+	}
+}
+
+// Databases from before 1.10 had its own key are re-keyed once
+func TestRekeyChapters(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "manga.sqlite3")
+	r := Opendb(ctx, path)
+	m := testManga(1, 0)
+	for _, raw := range []string{"1.1", "1.10", "1.2"} {
+		c := Chapter{ChapterHash: "hash-" + raw, MangaID: m.MangaID, TimePublished: time.Now()}
+		setChapterNumber(&c, raw)
+		m.Chapters = append(m.Chapters, c)
+	}
+	r.insertManga(ctx, m)
+	// As an older version would have stored it
+	old := ParseChapterNumber("1.1").Key()
+	if _, err := r.db.ExecContext(ctx, "UPDATE Chapter SET ChapterKey = ? WHERE ChapterHash = 'hash-1.10'; PRAGMA user_version = 0", old); err != nil {
+		t.Fatal(err)
+	}
+	r.db.Close()
+
+	r = Opendb(ctx, path)
+	t.Cleanup(func() { r.db.Close() })
+	var got []string
+	for _, c := range r.GetChapters(ctx, m.MangaID) {
+		got = append(got, c.ChapterRaw)
+	}
+	if want := []string{"1.1", "1.10", "1.2"}; !slices.Equal(got, want) {
+		t.Errorf("Chapters in order %q, want %q", got, want)
+	}
+	if v := r.userVersion(ctx); v&migratedKeys == 0 {
+		t.Errorf("user_version %d doesn't record the re-keying", v)
+	}
+}
@@ -0,0 +1,282 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What is wrong with a chapter or folder found by CheckLibrary
+type ProblemKind int
+
+const (
+	ProblemMissing   ProblemKind = iota // Marked downloaded, but the folder is missing
+	ProblemEmpty                        // Marked downloaded, but the folder has no pages
+	ProblemBadPages                     // Some pages are empty, cut short or not images
+	ProblemUnflagged                    // Pages are on disk, but it isn't marked downloaded
+	ProblemOrphan                       // A folder of files under the root that no chapter uses
+)
+
+// A problem found by CheckLibrary.
+// Chapter is empty for an orphan folder.
+type Problem struct {
+	Kind    ProblemKind
+	Chapter Chapter
+	Series  string // The abbreviated title of the chapter's series
+	Path    string // The absolute path of the folder
+	Pages   []string
+}
+
+func (p Problem) String() string {
+	where := fmt.Sprintf("%s ch. %s (%s)", p.Series, p.Chapter.Number(), p.Path)
+	switch p.Kind {
+	case ProblemMissing:
+		return where + ": marked downloaded, but the folder is missing"
+	case ProblemEmpty:
+		return where + ": marked downloaded, but there are no pages"
+	case ProblemBadPages:
+		if !p.Chapter.Downloaded {
+			where += ": left from a failed download"
+		}
+		return fmt.Sprintf("%s: bad pages %s", where, strings.Join(p.Pages, ", "))
+	case ProblemUnflagged:
+		return where + ": pages on disk, but not marked downloaded"
+	default:
+		return p.Path + ": not used by any chapter"
+	}
+}
+
+// Check that the chapters marked downloaded have all their pages on disk,
+// that chapters on disk are marked downloaded, and that every folder of
+// files under the root belongs to a chapter.
+// Returns the problems found, in the order of the library.
+func CheckLibrary(ctx context.Context, store Store, layout Layout) []Problem {
+	problems := make([]Problem, 0)
+	used := make(map[string]bool)
+
+	for _, m := range store.GetAll(ctx) {
+		for _, c := range store.GetChapters(ctx, m.MangaID) {
+			dir := layout.Abs(c)
+			used[dir] = true
+			if p, ok := checkChapter(c, dir); ok {
+				p.Series = m.SerTitle
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	return append(problems, findOrphans(layout.Root, used)...)
+}
+
+// Check the folder of a single chapter, returning the problem with it if there is one.
+func checkChapter(c Chapter, dir string) (Problem, bool) {
+	p := Problem{Chapter: c, Path: dir, Pages: make([]string, 0)}
+	entries, err := os.ReadDir(dir)
+	missing := errors.Is(err, os.ErrNotExist)
+	if err != nil && !missing {
+		log.Fatalf("%s: Failed to read %s", err, dir)
+	}
+
+	pages := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		pages++
+		if err := checkPage(filepath.Join(dir, e.Name())); err != nil {
+			p.Pages = append(p.Pages, fmt.Sprintf("%s (%s)", e.Name(), err))
+		}
+	}
+
+	switch {
+	case c.Downloaded && missing:
+		p.Kind = ProblemMissing
+	case c.Downloaded && pages == 0:
+		p.Kind = ProblemEmpty
+	case pages > 0 && len(p.Pages) > 0:
+		p.Kind = ProblemBadPages
+	case !c.Downloaded && pages > 0:
+		p.Kind = ProblemUnflagged
+	default:
+		return p, false
+	}
+	return p, true
+}
+
+// How much of the end of a page checkPage looks at, since some encoders
+// leave padding after the end marker
+const pageTailLen = 64
+
+// Check that a page is a whole image.
+// Only the header and the end of the file are read, which is enough to
+// catch error pages saved in place of images and downloads that were cut short.
+func checkPage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errors.New("empty")
+	}
+
+	tail := make([]byte, min(info.Size(), pageTailLen))
+	if _, err := f.ReadAt(tail, info.Size()-int64(len(tail))); err != nil {
+		return err
+	}
+
+	// The image package can't read webp, so check the size in its header instead
+	header := make([]byte, 12)
+	if n, _ := f.ReadAt(header, 0); n == 12 && string(header[:4]) == "RIFF" && string(header[8:]) == "WEBP" {
+		if int64(binary.LittleEndian.Uint32(header[4:8]))+8 > info.Size() {
+			return errors.New("cut short")
+		}
+		return nil
+	}
+
+	_, format, err := image.DecodeConfig(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return errors.New("not an image")
+	}
+
+	// Each format has a marker at its end
+	var end []byte
+	switch format {
+	case "jpeg":
+		end = []byte{0xff, 0xd9}
+	case "png":
+		end = []byte("IEND\xae\x42\x60\x82")
+	case "gif":
+		end = []byte{0x3b}
+	}
+	if !bytes.Contains(tail, end) {
+		return errors.New("cut short")
+	}
+	return nil
+}
+
+// Find the folders under root that hold files but aren't in used.
+// Hidden files and folders are ignored.
+func findOrphans(root string, used map[string]bool) []Problem {
+	orphans := make([]Problem, 0)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return filepath.SkipAll
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case !d.IsDir() || path == root:
+		case strings.HasPrefix(d.Name(), "."), used[path]:
+			return filepath.SkipDir
+		case hasFiles(path):
+			orphans = append(orphans, Problem{Kind: ProblemOrphan, Path: path})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%s: Failed to walk the library under %s", err, root)
+	}
+
+	return orphans
+}
+
+// Check whether a folder directly holds any files that aren't hidden
+func hasFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s", err, dir)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// MangaDex limits at-home server requests to 40 a minute
+const atHomeInterval = 1500 * time.Millisecond
+
+// Fix the chapters with problems found by CheckLibrary.
+// Chapters marked downloaded without all their pages are marked not
+// downloaded, or with redownload, downloaded again from scratch.
+// Chapters on disk but not marked downloaded are only marked downloaded if
+// they have as many pages as MangaDex lists, since they may be left from
+// an interrupted download; with redownload, the others are downloaded again.
+// Orphan folders are only reported, never removed.
+// Returns a line describing each change.
+func RepairLibrary(ctx context.Context, store Store, opts Options, problems []Problem, redownload bool) []string {
+	changes := make([]string, 0)
+	var limiter <-chan time.Time
+	for _, p := range problems {
+		c := p.Chapter
+		where := fmt.Sprintf("%s ch. %s", p.Series, c.Number())
+
+		switch {
+		case p.Kind == ProblemOrphan:
+		case p.Kind == ProblemUnflagged:
+			if limiter == nil {
+				limiter = time.Tick(atHomeInterval)
+			}
+			<-limiter
+			meta, err := getChapMetadata(ctx, c.ChapterHash)
+			if err != nil {
+				fatalf(ctx, err, "Failed to get the pages of chapter %s", c.ChapterHash)
+			}
+			want := len(meta.Chapter.Data)
+			pages, size := measureChapter(p.Path)
+			switch {
+			case want > 0 && pages >= want:
+				c.PageCount, c.ByteCount = pages, size
+				store.UpdateChapterDownloaded(ctx, c)
+				changes = append(changes, where+": marked downloaded")
+			case redownload:
+				changes = append(changes, where+": "+redownloadChapter(ctx, store, opts, c, p.Path))
+			case want == 0:
+				changes = append(changes, where+": MangaDex lists no pages, so left not downloaded")
+			default:
+				changes = append(changes, fmt.Sprintf("%s: only %d of %d pages, so left not downloaded", where, pages, want))
+			}
+		case redownload:
+			changes = append(changes, where+": "+redownloadChapter(ctx, store, opts, c, p.Path))
+		case c.Downloaded:
+			c.Downloaded = false
+			store.updateChapterState(ctx, c)
+			changes = append(changes, where+": marked not downloaded")
+		}
+	}
+	return changes
+}
+
+// Download a chapter again from scratch, removing what's in its folder,
+// unless the folder is outside the root. Returns what was done.
+func redownloadChapter(ctx context.Context, store Store, opts Options, c Chapter, dir string) string {
+	if !opts.Layout.Inside(dir) {
+		return fmt.Sprintf("left alone, since %s isn't inside %s", dir, opts.Layout.Root)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Fatalf("%s: Failed to remove %s", err, dir)
+	}
+	c.Downloaded = false
+	DownloadChapters(ctx, store, opts, c)
+	return "downloaded again"
+}
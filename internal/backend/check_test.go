@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	encode := func(enc func(*bytes.Buffer)) []byte {
+		var buf bytes.Buffer
+		enc(&buf)
+		return buf.Bytes()
+	}
+	pngData := encode(func(b *bytes.Buffer) { png.Encode(b, img) })
+	jpegData := encode(func(b *bytes.Buffer) { jpeg.Encode(b, img, nil) })
+	gifData := encode(func(b *bytes.Buffer) { gif.Encode(b, img, nil) })
+	webp := func(size uint32, data []byte) []byte {
+		header := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+		binary.LittleEndian.PutUint32(header[4:8], size)
+		return append(header, data...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string // The error, empty for a whole page
+	}{
+		{"png", pngData, ""},
+		{"png cut short", pngData[:len(pngData)-10], "cut short"},
+		{"png with padding", append(bytes.Clone(pngData), make([]byte, 16)...), ""},
+		{"jpeg", jpegData, ""},
+		{"jpeg cut short", jpegData[:len(jpegData)-2], "cut short"},
+		{"gif", gifData, ""},
+		{"gif cut short", gifData[:len(gifData)-1], "cut short"},
+		{"webp", webp(16, make([]byte, 8)), ""},
+		{"webp cut short", webp(100, make([]byte, 8)), "cut short"},
+		{"empty", nil, "empty"},
+		{"error page", []byte("<html><body>429 Too Many Requests</body></html>"), "not an image"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if err := checkPage(path); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("checkPage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A parsed library filter expression, like
+//
+//	tag:Romance -tag:Horror demo:seinen status:completed unread>0 rating>=80
+//
+// Every term has to match. A leading '-' negates a term, values with spaces
+// can be quoted ("tag:Slice of Life" or tag:"Slice of Life"),
+// and a bare word matches the full or abbreviated title.
+type Filter struct {
+	Expr  string
+	terms []filterTerm
+}
+
+// A single condition of a Filter
+type filterTerm struct {
+	negate bool
+	key    string
+	op     string // One of the comparison operators, or ":" for text keys
+	value  string
+	num    float64 // value parsed as a number, for the numeric keys
+}
+
+// A Filter saved under a name to use again later.
+type SavedFilter struct {
+	FilterName string
+	Expr       string
+}
+
+// The keys that compare text, and those that compare a number
+var (
+	textKeys = []string{"title", "tag", "demo", "status", "rstatus", "collection"}
+	numKeys  = []string{"unread", "chapters", "downloaded", "rating"}
+)
+
+// Comparison operators, longest first so that ">=" isn't read as ">"
+var filterOps = []string{">=", "<=", "!=", ">", "<", "=", ":"}
+
+// Parse a filter expression.
+func ParseFilter(expr string) (Filter, error) {
+	f := Filter{Expr: expr}
+
+	words, err := splitFilter(expr)
+	if err != nil {
+		return f, err
+	}
+
+	for _, w := range words {
+		t := filterTerm{}
+		if strings.HasPrefix(w, "-") && len(w) > 1 {
+			t.negate = true
+			w = w[1:]
+		}
+
+		t.key, t.op, t.value = "title", ":", w
+		for _, op := range filterOps {
+			if k, v, ok := strings.Cut(w, op); ok && isFilterKey(k) {
+				t.key, t.op, t.value = strings.ToLower(k), op, v
+				break
+			}
+		}
+
+		if t.value == "" {
+			return f, fmt.Errorf("%s needs a value", t.key)
+		}
+		if isNumKey(t.key) {
+			if t.op == ":" {
+				t.op = "="
+			}
+			if t.num, err = strconv.ParseFloat(t.value, 64); err != nil {
+				return f, fmt.Errorf("%s needs a number, not %s", t.key, t.value)
+			}
+		} else if t.op != ":" {
+			return f, fmt.Errorf("%s can only be matched with ':'", t.key)
+		}
+		if t.key == "rstatus" {
+			// Allow plan-to-read, on_hold, etc. so statuses don't need quoting
+			t.value = strings.NewReplacer("-", " ", "_", " ").Replace(t.value)
+		}
+
+		f.terms = append(f.terms, t)
+	}
+
+	if len(f.terms) == 0 {
+		return f, errors.New("empty filter")
+	}
+	return f, nil
+}
+
+// A Filter matching the Manga with the named tag, whatever is in the name
+func TagFilter(name string) Filter {
+	return Filter{Expr: fmt.Sprintf("tag:%q", name), terms: []filterTerm{{key: "tag", op: ":", value: name}}}
+}
+
+// Split a filter expression into words, keeping quoted text together
+// and removing the quotes.
+func splitFilter(expr string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	quoted, inWord := false, false
+
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case unicode.IsSpace(r) && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unclosed quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+func isFilterKey(k string) bool {
+	k = strings.ToLower(k)
+	for _, v := range append(textKeys, numKeys...) {
+		if k == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumKey(k string) bool {
+	for _, v := range numKeys {
+		if k == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the SQL condition for a Filter over the Manga table.
+func (f Filter) where() (string, []any) {
+	conds := make([]string, 0)
+	args := make([]any, 0)
+
+	for _, t := range f.terms {
+		// Every condition has a single argument, written as ?v
+		var cond string
+		var arg any = t.value
+		switch t.key {
+		case "title":
+			cond = `(FullTitle LIKE ?v ESCAPE '\' OR SerTitle LIKE ?v ESCAPE '\')`
+			arg = "%" + likeEscaper.Replace(t.value) + "%"
+		case "tag":
+			cond = `EXISTS (SELECT 1 FROM ItemTag JOIN Tag USING (TagID)
+				WHERE ItemTag.MangaID = Manga.MangaID AND TagTitle = ?v COLLATE NOCASE)`
+		case "demo":
+			cond = "Demographic = ?v COLLATE NOCASE"
+		case "status":
+			cond = "PubStatus = ?v COLLATE NOCASE"
+		case "rstatus":
+			cond = "ReadStatus = ?v COLLATE NOCASE"
+		case "collection":
+			cond = `EXISTS (SELECT 1 FROM CollectionItem JOIN Collection USING (CollectionID)
+				WHERE CollectionItem.MangaID = Manga.MangaID AND CollectionName = ?v COLLATE NOCASE)`
+		case "unread":
+			cond = "(SELECT count(*) FROM Chapter c WHERE c.MangaID = Manga.MangaID AND NOT c.IsRead) " + t.sqlOp() + " ?v"
+			arg = t.num
+		case "chapters":
+			cond = "(SELECT count(*) FROM Chapter c WHERE c.MangaID = Manga.MangaID) " + t.sqlOp() + " ?v"
+			arg = t.num
+		case "downloaded":
+			cond = "(SELECT count(*) FROM Chapter c WHERE c.MangaID = Manga.MangaID AND c.Downloaded) " + t.sqlOp() + " ?v"
+			arg = t.num
+		case "rating":
+			cond = "(SELECT Rating FROM Review r WHERE r.MangaID = Manga.MangaID) " + t.sqlOp() + " ?v"
+			arg = t.num
+		}
+
+		// Unreviewed series compare as NULL, which shouldn't match either way
+		cond = "coalesce(" + cond + ", 0)"
+		if t.negate {
+			cond = "NOT " + cond
+			if t.key == "rating" {
+				cond = "(EXISTS (SELECT 1 FROM Review r WHERE r.MangaID = Manga.MangaID) AND " + cond + ")"
+			}
+		}
+
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?v", fmt.Sprintf("?%d", len(args))))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// Check whether a Manga matches a Filter without going through SQL,
+// with the same results as where.
+// The Manga needs its tags, review and Summary, and collections are
+// the names of the collections it's in.
+func (f Filter) match(m Manga, collections []string) bool {
+	for _, t := range f.terms {
+		var ok bool
+		switch t.key {
+		case "title":
+			v := strings.ToLower(t.value)
+			ok = strings.Contains(strings.ToLower(m.FullTitle), v) || strings.Contains(strings.ToLower(m.SerTitle), v)
+		case "tag":
+			ok = slices.ContainsFunc(m.Tags, func(tag Tag) bool { return strings.EqualFold(tag.TagTitle, t.value) })
+		case "demo":
+			ok = strings.EqualFold(m.Demographic, t.value)
+		case "status":
+			ok = strings.EqualFold(m.PubStatus, t.value)
+		case "rstatus":
+			ok = strings.EqualFold(m.ReadStatus, t.value)
+		case "collection":
+			ok = slices.ContainsFunc(collections, func(c string) bool { return strings.EqualFold(c, t.value) })
+		case "unread":
+			ok = t.compare(float64(m.Summary.Unread))
+		case "chapters":
+			ok = t.compare(float64(m.Summary.Chapters))
+		case "downloaded":
+			ok = t.compare(float64(m.Summary.Downloaded))
+		case "rating":
+			// Unreviewed series never match a rating, negated or not
+			if m.Review.MangaID == "" {
+				return false
+			}
+			ok = t.compare(float64(m.Review.Rating))
+		}
+
+		if ok == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare a number with the term's value using its operator
+func (t filterTerm) compare(n float64) bool {
+	switch t.op {
+	case ">=":
+		return n >= t.num
+	case "<=":
+		return n <= t.num
+	case "!=":
+		return n != t.num
+	case ">":
+		return n > t.num
+	case "<":
+		return n < t.num
+	default:
+		return n == t.num
+	}
+}
+
+// The SQL spelling of the term's comparison operator
+func (t filterTerm) sqlOp() string {
+	if t.op == "!=" {
+		return "<>"
+	}
+	return t.op
+}
+
+// ------- STORE FUNCTIONS -------
+
+// Get all the Manga matching a Filter, with tags, review and Summary
+func (r *SQLite) FilterManga(ctx context.Context, f Filter) []Manga {
+	cond, args := f.where()
+	return r.queryManga(ctx, "WHERE "+cond, args...)
+}
+
+// Save a Filter under the given name, replacing any filter already saved with that name.
+func (r *SQLite) SaveFilter(ctx context.Context, name string, f Filter) {
+	stmt := "INSERT OR REPLACE INTO SavedFilter VALUES (?, ?)"
+	if _, err := r.conn().ExecContext(ctx, stmt, name, f.Expr); err != nil {
+		fatalf(ctx, err, "Failed to save filter %s", name)
+	}
+}
+
+// Get all the saved filters, sorted by name.
+func (r *SQLite) GetSavedFilters(ctx context.Context) []SavedFilter {
+	rows, err := r.conn().QueryContext(ctx, "SELECT FilterName, Expr FROM SavedFilter ORDER BY FilterName")
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for saved filters")
+	}
+	defer rows.Close()
+
+	all := make([]SavedFilter, 0)
+	for rows.Next() {
+		var f SavedFilter
+		if err := rows.Scan(&f.FilterName, &f.Expr); err != nil {
+			fatalf(ctx, err, "Failed to parse saved filter")
+		}
+		all = append(all, f)
+	}
+
+	return all
+}
@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []filterTerm // nil for a parse error
+	}{
+		{"tag:Romance", []filterTerm{{key: "tag", op: ":", value: "Romance"}}},
+		{"TAG:Romance", []filterTerm{{key: "tag", op: ":", value: "Romance"}}},
+		{"-tag:Horror", []filterTerm{{negate: true, key: "tag", op: ":", value: "Horror"}}},
+		{`tag:"Slice of Life"`, []filterTerm{{key: "tag", op: ":", value: "Slice of Life"}}},
+		{`"tag:Slice of Life"`, []filterTerm{{key: "tag", op: ":", value: "Slice of Life"}}},
+		{`-"tag:Slice of Life"`, []filterTerm{{negate: true, key: "tag", op: ":", value: "Slice of Life"}}},
+		{"rstatus:plan-to-read", []filterTerm{{key: "rstatus", op: ":", value: "plan to read"}}},
+		{"rstatus:on_hold", []filterTerm{{key: "rstatus", op: ":", value: "on hold"}}},
+		{"unread>0", []filterTerm{{key: "unread", op: ">", value: "0"}}},
+		{"rating>=80", []filterTerm{{key: "rating", op: ">=", value: "80", num: 80}}},
+		{"rating<=80", []filterTerm{{key: "rating", op: "<=", value: "80", num: 80}}},
+		{"chapters!=3", []filterTerm{{key: "chapters", op: "!=", value: "3", num: 3}}},
+		{"downloaded<2.5", []filterTerm{{key: "downloaded", op: "<", value: "2.5", num: 2.5}}},
+		{"unread=0", []filterTerm{{key: "unread", op: "=", value: "0"}}},
+		{"unread:0", []filterTerm{{key: "unread", op: "=", value: "0"}}},
+		{"Frieren", []filterTerm{{key: "title", op: ":", value: "Frieren"}}},
+		{"Re:Zero", []filterTerm{{key: "title", op: ":", value: "Re:Zero"}}},
+		{"title:>", []filterTerm{{key: "title", op: ":", value: ">"}}},
+		{"  demo:seinen   status:completed ", []filterTerm{
+			{key: "demo", op: ":", value: "seinen"},
+			{key: "status", op: ":", value: "completed"},
+		}},
+		{"", nil},
+		{"   ", nil},
+		{`tag:"Slice of`, nil},
+		{"tag:", nil},
+		{"-rating>=", nil},
+		{"unread>some", nil},
+		{"tag>3", nil},
+		{"demo!=seinen", nil},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("ParseFilter(%q) = %+v, want an error", tt.expr, f.terms)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tt.expr, err)
+		} else if !slices.Equal(f.terms, tt.want) {
+			t.Errorf("ParseFilter(%q) = %+v, want %+v", tt.expr, f.terms, tt.want)
+		}
+		if f.Expr != tt.expr {
+			t.Errorf("ParseFilter(%q) kept %q", tt.expr, f.Expr)
+		}
+	}
+}
+
+// A few series covering every filter key, with two left unreviewed
+func filterLibrary(s Store) {
+	ctx := context.Background()
+	s.insertTags(ctx, []string{"Action", "Horror", "Romance", "Slice of Life"})
+	series := []struct {
+		chapters int
+		tags     []string
+		demo     string
+		status   string
+		rstatus  string
+		rating   int // -1 for no review
+	}{
+		{4, []string{"Romance", "Action"}, "Shounen", "Ongoing", "Plan to read", 90},
+		{8, []string{"Slice of Life"}, "Seinen", "Completed", "Reading", 40},
+		{0, []string{"Horror", "Romance"}, "Shoujo", "Ongoing", "", -1},
+		{4, nil, "Shounen", "Hiatus", "Completed", 0},
+		{2, nil, "Unknown", "Ongoing", "", -1},
+	}
+	for i, ser := range series {
+		m := testManga(i+1, ser.chapters)
+		m.Tags = s.tagNamesToTags(ctx, ser.tags)
+		m.Demographic, m.PubStatus, m.ReadStatus = ser.demo, ser.status, ser.rstatus
+		if i == 3 {
+			m.SerTitle, m.FullTitle = "frieren", "Sousou no Frieren"
+			for j := range m.Chapters {
+				m.Chapters[j].IsRead = true
+			}
+		}
+		s.insertManga(ctx, m)
+		if ser.rating >= 0 {
+			s.insertReview(ctx, Review{MangaID: m.MangaID, Rating: ser.rating, Rev: "A review"})
+		}
+	}
+	s.AddToCollection(ctx, "Favourites", "manga-00001")
+	s.AddToCollection(ctx, "Favourites", "manga-00003")
+}
+
+// where and match have to agree, so SQLite and Memory filter alike
+func TestFilterStores(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []int // Series numbers
+	}{
+		{"tag:Romance", []int{1, 3}},
+		{"tag:romance", []int{1, 3}},
+		{"-tag:Romance", []int{2, 4, 5}},
+		{`tag:"Slice of Life"`, []int{2}},
+		{`"tag:slice of life"`, []int{2}},
+		{"tag:Romance -tag:Horror", []int{1}},
+		{"tag:Drama", nil},
+		{"demo:seinen", []int{2}},
+		{"-demo:shounen", []int{2, 3, 5}},
+		{"status:completed", []int{2}},
+		{"-status:ongoing", []int{2, 4}},
+		{"rstatus:plan-to-read", []int{1}},
+		{"rstatus:Reading", []int{2}},
+		{"collection:favourites", []int{1, 3}},
+		{"-collection:Favourites", []int{2, 4, 5}},
+		{"unread>0", []int{1, 2, 5}},
+		{"unread=0", []int{3, 4}},
+		{"unread:0", []int{3, 4}},
+		{"-unread=0", []int{1, 2, 5}},
+		{"chapters>=4", []int{1, 2, 4}},
+		{"chapters<4", []int{3, 5}},
+		{"chapters<=2", []int{3, 5}},
+		{"chapters!=4", []int{2, 3, 5}},
+		{"downloaded>1", []int{1, 2, 4}},
+		// Unreviewed series have no rating, so never match one
+		{"rating>=80", []int{1}},
+		{"rating<50", []int{2, 4}},
+		{"rating=0", []int{4}},
+		{"rating!=40", []int{1, 4}},
+		{"-rating>=80", []int{2, 4}},
+		{"-rating=0", []int{1, 2}},
+		{"frieren", []int{4}},
+		{"SERIES", []int{1, 2, 3, 5}},
+		{"-series", []int{4}},
+		{`title:"series 1"`, []int{1}},
+		{"50%", nil},
+		{"tag:Romance unread>0 rating>50", []int{1}},
+	}
+
+	sqlite := testDB(t)
+	memory := NewMemory()
+	filterLibrary(sqlite)
+	filterLibrary(memory)
+	ctx := context.Background()
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %v", tt.expr, err)
+		}
+		var want []string
+		for _, i := range tt.want {
+			want = append(want, fmt.Sprintf("manga-%05d", i))
+		}
+		for name, s := range map[string]Store{"SQLite": sqlite, "Memory": memory} {
+			var got []string
+			for _, m := range s.FilterManga(ctx, f) {
+				got = append(got, m.MangaID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("%s: %q matched %q, want %q", name, tt.expr, got, want)
+			}
+		}
+	}
+}
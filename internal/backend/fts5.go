@@ -0,0 +1,6 @@
+//go:build sqlite_fts5 || fts5
+
+package backend
+
+// go-sqlite3 only includes FTS5 when built with the sqlite_fts5 tag.
+const haveFTS5 = true
@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// Every chapter number MangaDex lists for a series in any language, by volume.
+// Chapters without a volume are under volume 0.
+type Aggregate map[int][]ChapterNumber
+
+// Get the Aggregate of a series from MangaDex.
+// Only plain numbered chapters are kept, since the others can't leave gaps.
+func GetAggregate(ctx context.Context, mangaID string) (Aggregate, error) {
+	meta, err := pullAggregate(ctx, mangaID)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := make(Aggregate)
+	for _, v := range meta.Volumes {
+		vol, _ := strconv.Atoi(v.Volume) // "none" becomes volume 0
+		for _, c := range v.Chapters {
+			if n := ParseChapterNumber(c.Chapter); n.Kind == KindNumbered && n.Suffix == "" && n.End == 0 {
+				agg[vol] = append(agg[vol], n)
+			}
+		}
+	}
+
+	return agg, nil
+}
+
+// A run of chapters missing from a series, from First to Last inclusive.
+type Gap struct {
+	Volume      int // 0 if unknown
+	First       ChapterNumber
+	Last        ChapterNumber
+	Listed      bool // MangaDex lists the chapters, rather than them being inferred from the numbering
+	WholeVolume bool // No chapter of Volume is in the library
+}
+
+// Implement list.DefaultItem
+func (g Gap) FilterValue() string { return g.Title() }
+
+// Implement list.Item
+func (g Gap) Title() string {
+	if g.First == g.Last {
+		return fmt.Sprintf("%s: Missing", g.First)
+	}
+	return fmt.Sprintf("%s-%s: Missing", g.First, g.Last)
+}
+func (g Gap) Description() string {
+	var d string
+	if g.Listed {
+		d = "On MangaDex, not in library"
+	} else {
+		d = "Gap in numbering"
+	}
+	if g.WholeVolume {
+		d = fmt.Sprintf("Volume %d missing\t%s", g.Volume, d)
+	}
+	return d
+}
+
+// Find the chapters missing from a series: holes in the numbering of the
+// chapters we have, and any listed in agg that we don't have.
+// agg can be nil to only look at the numbering.
+// Returns the gaps in chapter order.
+func FindGaps(chapters []Chapter, agg Aggregate) []Gap {
+	// A range like 10-11 has every whole chapter it covers
+	have := make(map[float64]bool)
+	last := make(map[string]float64) // The last chapter each one covers, by hash
+	for _, c := range chapters {
+		last[c.ChapterHash] = max(c.ChapterNum, c.Number().End)
+		for n := c.ChapterNum; n <= last[c.ChapterHash]; n++ {
+			have[n] = true
+		}
+	}
+	missing := make(map[float64]Gap)
+	// The chapters inferred from the numbering are always whole
+	whole := func(n float64) ChapterNumber { return ParseChapterNumber(strconv.FormatFloat(n, 'f', -1, 64)) }
+
+	// Decimal chapters are extras, so only whole numbers are expected in sequence.
+	// Chapter 0 is usually a prologue, and not having one isn't a gap.
+	sorted := slices.Clone(chapters)
+	slices.SortFunc(sorted, func(a, b Chapter) int { return cmp.Compare(a.ChapterNum, b.ChapterNum) })
+	prev, prevLast := Chapter{}, 0.0
+	for _, c := range sorted {
+		if c.ChapterNum <= 0 {
+			continue
+		}
+		// Only trust the volume if the chapters either side agree
+		vol := 0
+		if prev.VolumeNum == c.VolumeNum {
+			vol = c.VolumeNum
+		}
+		for n := math.Floor(prevLast) + 1; n < math.Floor(c.ChapterNum); n++ {
+			missing[n] = Gap{Volume: vol, First: whole(n), Last: whole(n)}
+		}
+		prev, prevLast = c, max(prevLast, last[c.ChapterHash])
+	}
+
+	for vol, nums := range agg {
+		wholeVolume := vol != 0 && !slices.ContainsFunc(nums, func(n ChapterNumber) bool { return have[n.Num] })
+		for _, n := range nums {
+			if !have[n.Num] {
+				missing[n.Num] = Gap{Volume: vol, First: n, Last: n, Listed: true, WholeVolume: wholeVolume}
+			}
+		}
+	}
+
+	// Join up neighbouring chapters that are missing for the same reason
+	nums := make([]float64, 0, len(missing))
+	for n := range missing {
+		nums = append(nums, n)
+	}
+	slices.Sort(nums)
+
+	gaps := make([]Gap, 0)
+	for _, n := range nums {
+		g := missing[n]
+		if i := len(gaps) - 1; i >= 0 && n <= gaps[i].Last.Num+1 && !hasBetween(have, gaps[i].Last.Num, n) &&
+			gaps[i].Volume == g.Volume && gaps[i].Listed == g.Listed && gaps[i].WholeVolume == g.WholeVolume {
+			gaps[i].Last = g.Last
+			continue
+		}
+		gaps = append(gaps, g)
+	}
+
+	return gaps
+}
+
+// Check whether we have any chapter numbered between a and b, exclusive.
+func hasBetween(have map[float64]bool, a, b float64) bool {
+	for n := range have {
+		if a < n && n < b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package backend
+
+import (
+	"context"
+	"testing"
+)
+
+// Failing to reach MangaDex is an error for the caller, not fatal
+func TestGetAggregateFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if agg, err := GetAggregate(ctx, "manga-00001"); err == nil {
+		t.Errorf("GetAggregate returned %v without a connection", agg)
+	}
+}
@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Where downloaded chapters go: a root folder for the whole library, and a
+// template for the path of each chapter inside it.
+//
+// Chapters store their path relative to the root, so the library can be moved
+// by changing the root.
+type Layout struct {
+	Root    string
+	chapter *template.Template
+}
+
+// The chapter path template matching where chapters have always been downloaded.
+const DefaultChapterTemplate = `{{.Abbrev}}/{{printf "%02d" .Volume}}/{{printf "%05.1f" .Chapter}}-{{.Hash}}`
+
+// The fields a chapter path template can use.
+// Each is cleaned of path separators, so only the template itself can add folders.
+type PathFields struct {
+	Abbrev   string  // The abbreviated title of the series
+	Title    string  // The full title of the series
+	Volume   int     // 0 if the chapter has no volume
+	Chapter  float64 // The chapter number, 0 for anything but a numbered chapter
+	Number   string  // The chapter number as displayed, like 12a or Extra
+	Group    string  // The scanlation group, if known
+	Language string  // The language code of the translation, like en
+	Hash     string  // The MangaDex ID of the chapter, which keeps paths unique
+}
+
+// Create a Layout, checking that the root is absolute and that the
+// template gives a usable path, even for a chapter without a volume, number,
+// group or language, and a different one for each chapter.
+func NewLayout(root, chapterTemplate string) (Layout, error) {
+	if !filepath.IsAbs(root) {
+		return Layout{}, fmt.Errorf("library root %s must be an absolute path", root)
+	}
+
+	t, err := template.New("chapter").Option("missingkey=error").Parse(chapterTemplate)
+	if err != nil {
+		return Layout{}, fmt.Errorf("bad chapter path template: %w", err)
+	}
+
+	l := Layout{Root: filepath.Clean(root), chapter: t}
+	samples := []PathFields{
+		{Abbrev: "abbrev", Title: "Title", Volume: 1, Chapter: 1, Number: "1", Group: "Group", Language: "en", Hash: "hash"},
+		{Abbrev: "abbrev", Title: "Title", Hash: "hash"},
+	}
+	for _, sample := range samples {
+		p, err := l.chapterPath(sample)
+		if err != nil {
+			return Layout{}, err
+		}
+		// Chapters can share everything but their hash, like two groups' translations
+		sample.Hash = "other"
+		if q, err := l.chapterPath(sample); err != nil {
+			return Layout{}, err
+		} else if p == q {
+			return Layout{}, errors.New("chapter path template must use .Hash to give each chapter its own path")
+		}
+	}
+	return l, nil
+}
+
+// Build the path of a chapter, relative to the root.
+func (l Layout) chapterPath(f PathFields) (string, error) {
+	clean := strings.NewReplacer("/", "_", "\\", "_", "\x00", "")
+	f.Abbrev, f.Title, f.Number = clean.Replace(f.Abbrev), clean.Replace(f.Title), clean.Replace(f.Number)
+	f.Group, f.Language, f.Hash = clean.Replace(f.Group), clean.Replace(f.Language), clean.Replace(f.Hash)
+
+	var sb strings.Builder
+	if err := l.chapter.Execute(&sb, f); err != nil {
+		return "", fmt.Errorf("bad chapter path template: %w", err)
+	}
+
+	p := filepath.Clean(sb.String())
+	if filepath.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", errors.New("chapter path template must give a path inside the library root")
+	}
+	return p, nil
+}
+
+// Build the path of a chapter of the given Manga, relative to the root.
+func (l Layout) ChapterPath(m Manga, c Chapter) string {
+	p, err := l.chapterPath(PathFields{
+		Abbrev:   m.SerTitle,
+		Title:    m.FullTitle,
+		Volume:   c.VolumeNum,
+		Chapter:  c.ChapterNum,
+		Number:   c.Number().String(),
+		Group:    c.GroupName,
+		Language: c.Language,
+		Hash:     c.ChapterHash,
+	})
+	if err != nil {
+		log.Fatalf("%s: Failed to build path for %v", err, c)
+	}
+	return p
+}
+
+// The absolute path of a chapter's folder.
+func (l Layout) Abs(c Chapter) string {
+	return filepath.Join(l.Root, c.ChapterPath)
+}
+
+// Whether path is strictly inside the root, and so safe to delete.
+// Stored chapter paths can come from an import or an older database,
+// so their folders are checked before anything removes them.
+func (l Layout) Inside(path string) bool {
+	rel, err := filepath.Rel(l.Root, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// Rewrite absolute chapter paths, stored before paths were relative, to be
+// relative to root. A path outside root keeps its last three parts, which
+// is where the default template puts the series folder, and its chapter is
+// marked not downloaded if there's nothing there. Every rewrite is logged.
+func (r *SQLite) MigratePaths(ctx context.Context, root string) {
+	rows, err := r.conn().QueryContext(ctx, "SELECT ChapterHash, ChapterPath FROM Chapter WHERE ChapterPath LIKE '/%'")
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for chapter paths")
+	}
+	defer rows.Close()
+
+	all := make([]Chapter, 0)
+	for rows.Next() {
+		var c Chapter
+		if err := rows.Scan(&c.ChapterHash, &c.ChapterPath); err != nil {
+			fatalf(ctx, err, "Failed to parse chapter path")
+		}
+		all = append(all, c)
+	}
+	rows.Close()
+	if len(all) == 0 {
+		return
+	}
+
+	tx, err := r.begin(ctx)
+	if err != nil {
+		fatalf(ctx, err, "Failed to begin transaction")
+	}
+	stmt, err := tx.PrepareContext(ctx, "UPDATE Chapter SET ChapterPath = ?, Downloaded = Downloaded AND ? WHERE ChapterHash = ?")
+	if err != nil {
+		fatalf(ctx, err, "Failed to prepare transaction")
+	}
+	defer stmt.Close()
+
+	for _, c := range all {
+		rel, inside := relativePath(root, c.ChapterPath)
+		found := true
+		if inside {
+			log.Printf("Chapter %s: moved path %s to %s", c.ChapterHash, c.ChapterPath, rel)
+		} else {
+			_, err := os.Stat(filepath.Join(root, rel))
+			found = err == nil
+			log.Printf("Chapter %s: path %s is outside %s, shortened to %s (found: %t)", c.ChapterHash, c.ChapterPath, root, rel, found)
+		}
+		if _, err := stmt.ExecContext(ctx, rel, found, c.ChapterHash); err != nil {
+			fatalf(ctx, err, "Failed to execute transaction on %v", c)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		fatalf(ctx, err, "Failed to commit transaction")
+	}
+	log.Printf("Made %d chapter paths relative to %s", len(all), root)
+}
+
+// Make an absolute path relative to root, as described in MigratePaths,
+// and report whether it was inside root rather than shortened.
+func relativePath(root, p string) (string, bool) {
+	if rel, err := filepath.Rel(root, p); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return rel, true
+	}
+
+	parts := strings.Split(strings.Trim(filepath.Clean(p), "/"), "/")
+	return filepath.Join(parts[max(len(parts)-3, 0):]...), false
+}
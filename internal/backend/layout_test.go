@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigratePaths(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "abbrev/01/002.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := testDB(t)
+	m := testManga(1, 3)
+	for i := range m.Chapters {
+		m.Chapters[i].Downloaded = true
+	}
+	m.Chapters[0].ChapterPath = filepath.Join(root, "abbrev/01/001.0")
+	m.Chapters[1].ChapterPath = "/old/library/abbrev/01/002.0" // Moved under root since
+	m.Chapters[2].ChapterPath = "/old/library/abbrev/01/003.0" // Lost
+	r.insertManga(ctx, m)
+
+	r.MigratePaths(ctx, root)
+	want := []struct {
+		path       string
+		downloaded bool
+	}{
+		{"abbrev/01/001.0", true},
+		{"abbrev/01/002.0", true},
+		{"abbrev/01/003.0", false},
+	}
+	for i, c := range r.GetChapters(ctx, m.MangaID) {
+		if c.ChapterPath != want[i].path || c.Downloaded != want[i].downloaded {
+			t.Errorf("Chapter %d at %s, downloaded %t; want %s, %t", i+1, c.ChapterPath, c.Downloaded, want[i].path, want[i].downloaded)
+		}
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	tests := []struct {
+		p      string
+		want   string
+		inside bool
+	}{
+		{"/lib/abbrev/01/001.0", "abbrev/01/001.0", true},
+		{"/lib/..abbrev/01", "..abbrev/01", true},
+		{"/lib", ".", true},
+		{"/other/abbrev/01/001.0", "abbrev/01/001.0", false},
+		{"/abbrev", "abbrev", false},
+	}
+	for _, tt := range tests {
+		got, inside := relativePath("/lib", tt.p)
+		if got != tt.want || inside != tt.inside {
+			t.Errorf("relativePath(%s) = %s, %t; want %s, %t", tt.p, got, inside, tt.want, tt.inside)
+		}
+	}
+}
+
+func TestNewLayout(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		ok   bool
+	}{
+		{DefaultChapterTemplate, true},
+		{"{{.Title}}/{{.Number}} {{.Hash}}", true},
+		{"{{.Abbrev}}/{{with .Group}}{{.}}/{{end}}{{.Language}}-{{.Hash}}", true},
+		{"{{.Hash}}", true},
+		{"{{.Abbrev}}/{{.Volume}}/{{.Chapter}}", false},      // Chapters from two groups share a path
+		{"{{.Number}}{{.Group}}", false},                     // Empty for an unnumbered chapter
+		{"{{.Group}}/{{.Hash}}", false},                      // Absolute without a group
+		{"{{.Abbrev}}/{{.Language}}/../../{{.Hash}}", false}, // Outside the root without a language
+		{"../{{.Hash}}", false},
+		{"{{.Abbrev}}/{{.Missing}}-{{.Hash}}", false},
+		{"{{.Abbrev", false},
+	}
+	for _, tt := range tests {
+		_, err := NewLayout("/lib", tt.tmpl)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewLayout(%q) error %v, want ok %t", tt.tmpl, err, tt.ok)
+		}
+	}
+	if _, err := NewLayout("lib", DefaultChapterTemplate); err == nil {
+		t.Error("NewLayout accepted a relative root")
+	}
+}
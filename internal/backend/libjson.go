@@ -0,0 +1,566 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The version of the format written by ExportLibrary.
+// Bump it whenever a change means an older version can't read the file correctly.
+const ExportVersion = 1
+
+// The whole library, as written by ExportLibrary
+type libraryExport struct {
+	Version      int                `json:"version"`
+	Exported     time.Time          `json:"exported"`
+	Manga        []mangaExport      `json:"manga"`
+	Collections  []collectionExport `json:"collections"`
+	SavedFilters []SavedFilter      `json:"savedFilters"`
+}
+
+type mangaExport struct {
+	MangaID       string          `json:"mangaId"`
+	SerTitle      string          `json:"serTitle"`
+	FullTitle     string          `json:"fullTitle"`
+	AltTitles     []string        `json:"altTitles"`
+	Descr         string          `json:"descr"`
+	TimeModified  time.Time       `json:"timeModified"`
+	LastVolume    int             `json:"lastVolume"`
+	LastChapter   float64         `json:"lastChapter"`
+	Demographic   string          `json:"demographic"`
+	PubStatus     string          `json:"pubStatus"`
+	Author        string          `json:"author,omitempty"`
+	ReadStatus    string          `json:"readStatus"`
+	Retention     string          `json:"retention,omitempty"`
+	Processing    string          `json:"processing,omitempty"`
+	Tags          []string        `json:"tags"`
+	Review        *reviewExport   `json:"review,omitempty"`
+	Chapters      []chapterExport `json:"chapters"`
+	StatusHistory []statusExport  `json:"statusHistory"`
+}
+
+type reviewExport struct {
+	Rating int    `json:"rating"`
+	Rev    string `json:"rev"`
+}
+
+type chapterExport struct {
+	ChapterHash string        `json:"chapterHash"`
+	ChapterNum  float64       `json:"chapterNum"`
+	ChapterRaw  *string       `json:"chapterRaw"` // Missing from older exports
+	ChapterName string        `json:"chapterName"`
+	VolumeNum   int           `json:"volumeNum"`
+	Downloaded  bool          `json:"downloaded"` // Not imported, see chapter
+	IsRead      bool          `json:"isRead"`
+	LastPage    int           `json:"lastPage"`
+	ChapterPath string        `json:"chapterPath"` // Not imported, see chapter
+	Language    string        `json:"language,omitempty"`
+	GroupName   string        `json:"groupName,omitempty"`
+	Published   *time.Time    `json:"published,omitempty"` // Missing if unknown
+	TimeRead    *time.Time    `json:"timeRead,omitempty"`  // Missing if unknown, see chapter
+	ReadEvents  []eventExport `json:"readEvents"`
+}
+
+type eventExport struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	PagesViewed int       `json:"pagesViewed"`
+}
+
+type statusExport struct {
+	ReadStatus  string    `json:"readStatus"`
+	TimeChanged time.Time `json:"timeChanged"`
+}
+
+type collectionExport struct {
+	Name  string   `json:"name"`
+	Manga []string `json:"manga"` // MangaIDs
+}
+
+// What ImportLibrary changed, or would change on a dry run.
+type ImportReport struct {
+	Changes []string
+	Skipped []string // Things in the import that conflict with the library, which are left alone
+}
+
+func (rep *ImportReport) change(format string, a ...any) {
+	rep.Changes = append(rep.Changes, fmt.Sprintf(format, a...))
+}
+
+func (rep *ImportReport) skip(format string, a ...any) {
+	rep.Skipped = append(rep.Skipped, fmt.Sprintf(format, a...))
+}
+
+func (rep ImportReport) String() string {
+	var sb strings.Builder
+	if len(rep.Changes) == 0 {
+		sb.WriteString("No changes\n")
+	}
+	for _, c := range rep.Changes {
+		fmt.Fprintf(&sb, "%s\n", c)
+	}
+	for _, s := range rep.Skipped {
+		fmt.Fprintf(&sb, "skipped: %s\n", s)
+	}
+	return sb.String()
+}
+
+// Write the whole library as JSON: every Manga with its chapters, tags and review,
+// along with reading state, reading history, collections and saved filters.
+func ExportLibrary(ctx context.Context, store Store, out io.Writer) error {
+	exp := libraryExport{
+		Version:      ExportVersion,
+		Exported:     time.Now(),
+		Manga:        make([]mangaExport, 0),
+		Collections:  make([]collectionExport, 0),
+		SavedFilters: store.GetSavedFilters(ctx),
+	}
+
+	for _, m := range store.GetAll(ctx) {
+		me := mangaExport{
+			MangaID:       m.MangaID,
+			SerTitle:      m.SerTitle,
+			FullTitle:     m.FullTitle,
+			AltTitles:     m.AltTitles,
+			Descr:         m.Descr,
+			TimeModified:  m.TimeModified,
+			LastVolume:    m.lastVolume,
+			LastChapter:   m.lastChapter,
+			Demographic:   m.Demographic,
+			PubStatus:     m.PubStatus,
+			Author:        m.Author,
+			ReadStatus:    m.ReadStatus,
+			Retention:     m.Retention,
+			Processing:    m.Processing,
+			Tags:          make([]string, 0),
+			Chapters:      make([]chapterExport, 0),
+			StatusHistory: make([]statusExport, 0),
+		}
+		for _, t := range m.Tags {
+			me.Tags = append(me.Tags, t.TagTitle)
+		}
+		if m.Review.MangaID != "" {
+			me.Review = &reviewExport{Rating: m.Review.Rating, Rev: m.Review.Rev}
+		}
+		for _, c := range store.GetStatusHistory(ctx, m.MangaID) {
+			me.StatusHistory = append(me.StatusHistory, statusExport{c.ReadStatus, c.TimeChanged})
+		}
+
+		events := make(map[string][]eventExport)
+		for _, e := range store.GetSeriesHistory(ctx, m.MangaID) {
+			events[e.ChapterHash] = append(events[e.ChapterHash], eventExport{e.Start, e.End, e.PagesViewed})
+		}
+		for _, c := range store.GetChapters(ctx, m.MangaID) {
+			ce := chapterExport{
+				ChapterHash: c.ChapterHash,
+				ChapterNum:  c.ChapterNum,
+				ChapterRaw:  &c.ChapterRaw,
+				ChapterName: c.ChapterName,
+				VolumeNum:   c.VolumeNum,
+				Downloaded:  c.Downloaded,
+				IsRead:      c.IsRead,
+				LastPage:    c.LastPage,
+				ChapterPath: c.ChapterPath,
+				Language:    c.Language,
+				GroupName:   c.GroupName,
+				ReadEvents:  events[c.ChapterHash],
+			}
+			if !c.TimePublished.IsZero() {
+				ce.Published = &c.TimePublished
+			}
+			if !c.TimeRead.IsZero() {
+				ce.TimeRead = &c.TimeRead
+			}
+			if ce.ReadEvents == nil {
+				ce.ReadEvents = make([]eventExport, 0)
+			}
+			me.Chapters = append(me.Chapters, ce)
+		}
+
+		exp.Manga = append(exp.Manga, me)
+	}
+
+	for _, c := range store.GetCollections(ctx) {
+		ce := collectionExport{Name: c.Name, Manga: make([]string, 0)}
+		for _, m := range store.GetCollectionManga(ctx, c) {
+			ce.Manga = append(ce.Manga, m.MangaID)
+		}
+		exp.Collections = append(exp.Collections, ce)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "\t")
+	return enc.Encode(exp)
+}
+
+// Merge a library written by ExportLibrary into the store.
+// Manga are matched by MangaID and chapters by ChapterHash. Anything missing is added,
+// and the read state of chapters is combined, so nothing already read is ever
+// marked unread. Whether a chapter is downloaded comes from the layout and the disk,
+// never the import. Reviews, statuses and saved filters that differ from the
+// library's, and series with values the library doesn't allow, are left alone
+// and reported as skipped.
+// The changes are made in one transaction, so a failure partway keeps none of them.
+// With dryRun, nothing is changed and the report says what would have been.
+func ImportLibrary(ctx context.Context, store Store, layout Layout, in io.Reader, dryRun bool) (ImportReport, error) {
+	var rep ImportReport
+	var exp libraryExport
+	if err := json.NewDecoder(in).Decode(&exp); err != nil {
+		return rep, fmt.Errorf("%w: Failed to decode library", err)
+	}
+	if exp.Version < 1 || exp.Version > ExportVersion {
+		return rep, fmt.Errorf("Unsupported library version %d, expected at most %d", exp.Version, ExportVersion)
+	}
+
+	store.transaction(ctx, func(store Store) {
+		importLibrary(ctx, store, layout, exp, &rep, dryRun)
+	})
+	return rep, nil
+}
+
+func importLibrary(ctx context.Context, store Store, layout Layout, exp libraryExport, rep *ImportReport, dryRun bool) {
+	local := make(map[string]Manga)
+	abbrevs := make(map[string]string)
+	for _, m := range store.GetAll(ctx) {
+		local[m.MangaID] = m
+		abbrevs[m.SerTitle] = m.MangaID
+	}
+
+	// The MangaIDs that are in the library, or will be after the import
+	known := make(map[string]bool)
+	for id := range local {
+		known[id] = true
+	}
+
+	for _, me := range exp.Manga {
+		if err := me.validate(); err != nil {
+			rep.skip("series %s: %s", me.FullTitle, err)
+			continue
+		}
+
+		m, exists := local[me.MangaID]
+		if !exists {
+			// The abbreviation becomes a folder name, so it mustn't lead out of the library
+			if err := ValidateAbbrev(me.SerTitle); err != nil {
+				rep.skip("series %s: %s", me.FullTitle, err)
+				continue
+			}
+			if other, taken := abbrevs[me.SerTitle]; taken {
+				rep.skip("series %s: abbreviation %s is already used by %s", me.FullTitle, me.SerTitle, local[other].FullTitle)
+				continue
+			}
+			rep.change("add series %s (%s) with %d chapters", me.FullTitle, me.SerTitle, len(me.Chapters))
+			if !dryRun {
+				importNewManga(ctx, store, layout, me)
+			}
+			abbrevs[me.SerTitle] = me.MangaID
+			known[me.MangaID] = true
+		} else {
+			importMergeManga(ctx, store, layout, m, me, rep, dryRun)
+		}
+
+		importHistory(ctx, store, me, rep, dryRun)
+	}
+
+	importCollections(ctx, store, exp.Collections, known, rep, dryRun)
+
+	saved := make(map[string]string)
+	for _, f := range store.GetSavedFilters(ctx) {
+		saved[f.FilterName] = f.Expr
+	}
+	for _, f := range exp.SavedFilters {
+		expr, exists := saved[f.FilterName]
+		if exists && expr != f.Expr {
+			rep.skip("saved filter %s: differs from the one in the library", f.FilterName)
+			continue
+		} else if exists {
+			continue
+		}
+
+		parsed, err := ParseFilter(f.Expr)
+		if err != nil {
+			rep.skip("saved filter %s: %s", f.FilterName, err)
+			continue
+		}
+		rep.change("add saved filter %s", f.FilterName)
+		if !dryRun {
+			store.SaveFilter(ctx, f.FilterName, parsed)
+		}
+	}
+}
+
+// Check the values of an exported Manga that the library restricts,
+// so that a bad one is skipped instead of failing the whole import.
+func (me mangaExport) validate() error {
+	switch {
+	case !slices.Contains(demographics, me.Demographic):
+		return fmt.Errorf("unknown demographic %q", me.Demographic)
+	case !slices.Contains(pubStatuses, me.PubStatus):
+		return fmt.Errorf("unknown publication status %q", me.PubStatus)
+	case !slices.Contains(readStatuses, me.ReadStatus):
+		return fmt.Errorf("unknown status %q", me.ReadStatus)
+	case me.Review != nil && (me.Review.Rating < 0 || me.Review.Rating > 100):
+		return fmt.Errorf("rating %d isn't between 0 and 100", me.Review.Rating)
+	}
+	for _, c := range me.StatusHistory {
+		if !slices.Contains(readStatuses, c.ReadStatus) {
+			return fmt.Errorf("unknown status %q in its history", c.ReadStatus)
+		}
+	}
+	return nil
+}
+
+// Convert an exported chapter back to a Chapter of m.
+// Its path comes from the layout rather than the export, which may be from
+// another machine or edited by hand, and it's downloaded if there are pages there.
+func (ce chapterExport) chapter(m Manga, layout Layout) Chapter {
+	c := Chapter{
+		ChapterHash: ce.ChapterHash,
+		ChapterName: ce.ChapterName,
+		VolumeNum:   ce.VolumeNum,
+		MangaID:     m.MangaID,
+		IsRead:      ce.IsRead,
+		LastPage:    ce.LastPage,
+		Language:    ce.Language,
+		GroupName:   ce.GroupName,
+	}
+
+	if ce.Published != nil {
+		c.TimePublished = *ce.Published
+	}
+	c.TimeRead = ce.timeRead()
+	if ce.ChapterRaw != nil {
+		setChapterNumber(&c, *ce.ChapterRaw)
+	} else {
+		setChapterNumber(&c, strconv.FormatFloat(ce.ChapterNum, 'f', -1, 64))
+	}
+	c.ChapterPath = layout.ChapterPath(m, c)
+	c.PageCount, c.ByteCount = measureChapter(layout.Abs(c))
+	c.Downloaded = c.PageCount > 0
+	return c
+}
+
+// When an imported chapter was last marked read. Older exports don't say,
+// so a read chapter without a time counts as read when it is imported,
+// which lets days:N retention clean it up eventually.
+func (ce chapterExport) timeRead() time.Time {
+	if ce.TimeRead != nil {
+		return *ce.TimeRead
+	} else if ce.IsRead {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+// Add a Manga that isn't in the library yet.
+func importNewManga(ctx context.Context, store Store, layout Layout, me mangaExport) {
+	store.insertTags(ctx, me.Tags)
+	m := Manga{
+		MangaID:      me.MangaID,
+		SerTitle:     me.SerTitle,
+		FullTitle:    me.FullTitle,
+		AltTitles:    me.AltTitles,
+		Descr:        me.Descr,
+		TimeModified: me.TimeModified,
+		Tags:         store.tagNamesToTags(ctx, me.Tags),
+		Chapters:     make([]Chapter, 0),
+		lastVolume:   me.LastVolume,
+		lastChapter:  me.LastChapter,
+		Demographic:  me.Demographic,
+		PubStatus:    me.PubStatus,
+		Author:       me.Author,
+		ReadStatus:   me.ReadStatus,
+		Retention:    me.Retention,
+		Processing:   me.Processing,
+	}
+	for _, ce := range me.Chapters {
+		m.Chapters = append(m.Chapters, ce.chapter(m, layout))
+	}
+
+	store.insertManga(ctx, m)
+	if me.Review != nil {
+		store.insertReview(ctx, Review{MangaID: me.MangaID, Rating: me.Review.Rating, Rev: me.Review.Rev})
+	}
+}
+
+// Merge an exported Manga into the one already in the library.
+func importMergeManga(ctx context.Context, store Store, layout Layout, m Manga, me mangaExport, rep *ImportReport, dryRun bool) {
+	chapters := make(map[string]Chapter)
+	for _, c := range store.GetChapters(ctx, m.MangaID) {
+		chapters[c.ChapterHash] = c
+	}
+
+	added := make([]Chapter, 0)
+	merged := make([]Chapter, 0)
+	for _, ce := range me.Chapters {
+		c, exists := chapters[ce.ChapterHash]
+		if !exists {
+			added = append(added, ce.chapter(m, layout))
+			continue
+		}
+
+		new := c
+		new.IsRead = c.IsRead || ce.IsRead
+		new.LastPage = max(c.LastPage, ce.LastPage)
+		// Keep the later time a chapter was read, but don't date ones
+		// already read before times were recorded
+		if t := ce.timeRead(); ce.IsRead && (!c.IsRead || ce.TimeRead != nil) && t.After(c.TimeRead) {
+			new.TimeRead = t
+		}
+		if new != c {
+			merged = append(merged, new)
+		}
+	}
+
+	if len(added) > 0 {
+		rep.change("add %d chapters to %s", len(added), m.SerTitle)
+	}
+	if len(merged) > 0 {
+		rep.change("update read state of %d chapters of %s", len(merged), m.SerTitle)
+	}
+
+	var rev *Review
+	if me.Review != nil {
+		rev = &Review{MangaID: m.MangaID, Rating: me.Review.Rating, Rev: me.Review.Rev}
+		if m.Review.MangaID == "" {
+			rep.change("add review of %s", m.SerTitle)
+		} else {
+			if *rev != m.Review {
+				rep.skip("review of %s: differs from the one in the library", m.SerTitle)
+			}
+			rev = nil
+		}
+	}
+
+	status := ""
+	if me.ReadStatus != "" && me.ReadStatus != m.ReadStatus {
+		if m.ReadStatus == "" {
+			status = me.ReadStatus
+			rep.change("set status of %s to %s", m.SerTitle, status)
+		} else {
+			rep.skip("status of %s: %s in the library, %s in the import", m.SerTitle, m.ReadStatus, me.ReadStatus)
+		}
+	}
+
+	if dryRun {
+		return
+	}
+	store.insertChapters(ctx, added)
+	for _, c := range merged {
+		store.updateChapterState(ctx, c)
+	}
+	if rev != nil {
+		store.insertReview(ctx, *rev)
+	}
+	if status != "" {
+		store.setReadStatus(ctx, m.MangaID, status)
+	}
+}
+
+// Add the status changes and reading sessions of an exported Manga
+// that aren't in the library yet.
+func importHistory(ctx context.Context, store Store, me mangaExport, rep *ImportReport, dryRun bool) {
+	statuses := make(map[int64]bool)
+	for _, c := range store.GetStatusHistory(ctx, me.MangaID) {
+		statuses[c.TimeChanged.UnixNano()] = true
+	}
+	addedStatuses := make([]StatusChange, 0)
+	for _, s := range me.StatusHistory {
+		if !statuses[s.TimeChanged.UnixNano()] {
+			addedStatuses = append(addedStatuses, StatusChange{me.MangaID, s.ReadStatus, s.TimeChanged})
+		}
+	}
+
+	events := make(map[string]bool)
+	for _, e := range store.GetSeriesHistory(ctx, me.MangaID) {
+		events[fmt.Sprint(e.ChapterHash, e.Start.UnixNano())] = true
+	}
+	addedEvents := make([]ReadEvent, 0)
+	for _, ce := range me.Chapters {
+		for _, e := range ce.ReadEvents {
+			if !events[fmt.Sprint(ce.ChapterHash, e.Start.UnixNano())] {
+				addedEvents = append(addedEvents, ReadEvent{ChapterHash: ce.ChapterHash, Start: e.Start, End: e.End, PagesViewed: e.PagesViewed})
+			}
+		}
+	}
+
+	if len(addedStatuses) > 0 {
+		rep.change("add %d status changes to %s", len(addedStatuses), me.SerTitle)
+	}
+	if len(addedEvents) > 0 {
+		rep.change("add %d reading sessions to %s", len(addedEvents), me.SerTitle)
+	}
+	if dryRun {
+		return
+	}
+	for _, s := range addedStatuses {
+		store.insertStatusChange(ctx, s)
+	}
+	for _, e := range addedEvents {
+		store.insertReadEvent(ctx, e)
+	}
+}
+
+// Add Manga to collections they aren't in yet, creating the collections as needed.
+// Only Manga that are known to the library are added.
+func importCollections(ctx context.Context, store Store, collections []collectionExport, known map[string]bool, rep *ImportReport, dryRun bool) {
+	members := make(map[string]map[string]bool)
+	for _, c := range store.GetCollections(ctx) {
+		members[c.Name] = make(map[string]bool)
+		for _, m := range store.GetCollectionManga(ctx, c) {
+			members[c.Name][m.MangaID] = true
+		}
+	}
+
+	for _, ce := range collections {
+		added := make([]string, 0)
+		for _, id := range ce.Manga {
+			if known[id] && !members[ce.Name][id] {
+				added = append(added, id)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+
+		rep.change("add %d series to collection %s", len(added), ce.Name)
+		if dryRun {
+			continue
+		}
+		for _, id := range added {
+			store.AddToCollection(ctx, ce.Name, id)
+		}
+	}
+}
+
+// Set the read and downloaded state of a chapter, and when it was read,
+// for merging in an import.
+func (r *SQLite) updateChapterState(ctx context.Context, c Chapter) {
+	stmt := "UPDATE Chapter SET Downloaded = ?, IsRead = ?, LastPage = ?, TimeRead = ? WHERE ChapterHash = ?"
+	read := sql.NullTime{Time: c.TimeRead, Valid: !c.TimeRead.IsZero()}
+	if _, err := r.conn().ExecContext(ctx, stmt, c.Downloaded, c.IsRead, c.LastPage, read, c.ChapterHash); err != nil {
+		fatalf(ctx, err, "Failed to update chapter state %v", c)
+	}
+}
+
+// Set the ReadStatus of a Manga without recording it as a change,
+// for an import that brings its own history.
+func (r *SQLite) setReadStatus(ctx context.Context, mangaID string, status string) {
+	if _, err := r.conn().ExecContext(ctx, "UPDATE Manga SET ReadStatus = ? WHERE MangaID = ?", status, mangaID); err != nil {
+		fatalf(ctx, err, "Failed to update read status of %s", mangaID)
+	}
+}
+
+// Insert a past change of ReadStatus.
+func (r *SQLite) insertStatusChange(ctx context.Context, c StatusChange) {
+	if _, err := r.conn().ExecContext(ctx, "INSERT INTO StatusChange VALUES (?, ?, ?)", c.MangaID, c.ReadStatus, c.TimeChanged); err != nil {
+		fatalf(ctx, err, "Failed to insert %v", c)
+	}
+}
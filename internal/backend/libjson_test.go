@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportLibrary(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemory()
+	src.insertManga(ctx, testManga(1, 4))
+	var buf bytes.Buffer
+	if err := ExportLibrary(ctx, src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add series the library wouldn't allow
+	var exp libraryExport
+	if err := json.Unmarshal(buf.Bytes(), &exp); err != nil {
+		t.Fatal(err)
+	}
+	bad := exp.Manga[0]
+	bad.MangaID, bad.SerTitle, bad.FullTitle, bad.Chapters = "manga-bad", "bad", "Bad", nil
+	bad.PubStatus = "ongoing"
+	escape := exp.Manga[0]
+	escape.MangaID, escape.SerTitle, escape.FullTitle = "manga-escape", "../escape", "Escape"
+	exp.Manga = append(exp.Manga, bad, escape)
+	in, err := json.Marshal(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := NewLayout(t.TempDir(), DefaultChapterTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first chapter is on disk here, though the export says the first two are downloaded
+	m := src.GetSummary(ctx, "manga-00001")
+	first := src.GetChapters(ctx, m.MangaID)[0]
+	dir := filepath.Join(layout.Root, layout.ChapterPath(m, first))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.jpg"), []byte("page"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := testDB(t)
+	rep, err := ImportLibrary(ctx, store, layout, bytes.NewReader(in), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Skipped) != 2 {
+		t.Errorf("Skipped %q, want only the bad series", rep.Skipped)
+	}
+	if store.mangaExists(ctx, "manga-bad") {
+		t.Error("Imported a series with a bad publication status")
+	}
+	if store.mangaExists(ctx, "manga-escape") {
+		t.Error("Imported a series with an abbreviation leading out of the library")
+	}
+
+	chapters := store.GetChapters(ctx, m.MangaID)
+	if len(chapters) != 4 {
+		t.Fatalf("Imported %d chapters, want 4", len(chapters))
+	}
+	for i, c := range chapters {
+		if want := layout.ChapterPath(m, c); c.ChapterPath != want {
+			t.Errorf("Chapter %d path %s, want %s", i+1, c.ChapterPath, want)
+		}
+		if c.Downloaded != (i == 0) {
+			t.Errorf("Chapter %d downloaded %t, want %t", i+1, c.Downloaded, i == 0)
+		}
+	}
+	if chapters[0].PageCount != 1 {
+		t.Errorf("First chapter has %d pages, want 1", chapters[0].PageCount)
+	}
+
+	// Merging again doesn't take the downloaded state from the export
+	rep, err = ImportLibrary(ctx, store, layout, bytes.NewReader(in), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Changes) != 0 {
+		t.Errorf("Second import changed %q, want nothing", rep.Changes)
+	}
+	if c := store.GetChapters(ctx, m.MangaID)[1]; c.Downloaded {
+		t.Error("Second chapter marked downloaded from the export")
+	}
+}
+
+// Read chapters keep when they were read, so days:N retention can clean them up
+func TestImportTimeRead(t *testing.T) {
+	ctx := context.Background()
+	read := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	src := NewMemory()
+	m := testManga(1, 8) // The first two are read
+	m.Chapters[0].TimeRead = read
+	src.insertManga(ctx, m)
+	src.UpdateChapterRead(ctx, m.Chapters[2])
+
+	var buf bytes.Buffer
+	if err := ExportLibrary(ctx, src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var exp libraryExport
+	if err := json.Unmarshal(buf.Bytes(), &exp); err != nil {
+		t.Fatal(err)
+	}
+	if got := exp.Manga[0].Chapters[0].TimeRead; got == nil || !got.Equal(read) {
+		t.Fatalf("Exported time read %v, want %v", got, read)
+	}
+	if got := exp.Manga[0].Chapters[1].TimeRead; got != nil {
+		t.Errorf("Exported time read %v for a chapter read before it was kept", got)
+	}
+
+	layout, err := NewLayout(t.TempDir(), DefaultChapterTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := testDB(t)
+	// Already in the library, with the third chapter not read yet
+	store.insertManga(ctx, testManga(1, 8))
+	before := time.Now()
+	if _, err := ImportLibrary(ctx, store, layout, bytes.NewReader(buf.Bytes()), false); err != nil {
+		t.Fatal(err)
+	}
+	chapters := store.GetChapters(ctx, m.MangaID)
+	if !chapters[0].TimeRead.Equal(read) {
+		t.Errorf("Merged time read %v, want %v", chapters[0].TimeRead, read)
+	}
+	if !chapters[1].TimeRead.IsZero() {
+		t.Errorf("Merged time read %v for a chapter read before it was kept", chapters[1].TimeRead)
+	}
+	if !chapters[2].IsRead || chapters[2].TimeRead.Before(before.Add(-time.Minute)) {
+		t.Errorf("Merged chapter read %t at %v, want the time it was marked read", chapters[2].IsRead, chapters[2].TimeRead)
+	}
+
+	// An export from before times were kept dates read chapters by the import
+	for i := range exp.Manga[0].Chapters {
+		exp.Manga[0].Chapters[i].TimeRead = nil
+	}
+	old, err := json.Marshal(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = testDB(t)
+	if _, err := ImportLibrary(ctx, store, layout, bytes.NewReader(old), false); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range store.GetChapters(ctx, m.MangaID) {
+		if c.IsRead == c.TimeRead.IsZero() {
+			t.Errorf("Chapter %d read %t at %v", i+1, c.IsRead, c.TimeRead)
+		}
+		if c.IsRead && c.TimeRead.Before(before) {
+			t.Errorf("Chapter %d read at %v, want when it was imported", i+1, c.TimeRead)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"regexp"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -50,6 +52,13 @@ type MangaMeta struct {
 				} `json:"attributes"`
 			} `json:"tags"`
 		} `json:"attributes"`
+		// Includes the authors, with their names
+		Relationships []struct {
+			Type       string `json:"type"`
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		} `json:"relationships"`
 	} `json:"data"`
 }
 
@@ -72,10 +81,19 @@ type chapterMeta struct {
 type feedChData struct {
 	ID         string `json:"id"`
 	Attributes struct {
-		Title   string `json:"title"`
-		Volume  string `json:"volume"`
-		Chapter string `json:"chapter"`
+		Title              string    `json:"title"`
+		Volume             string    `json:"volume"`
+		Chapter            string    `json:"chapter"`
+		TranslatedLanguage string    `json:"translatedLanguage"`
+		PublishAt          time.Time `json:"publishAt"`
 	} `json:"attributes"`
+	// Includes the scanlation group, with its name
+	Relationships []struct {
+		Type       string `json:"type"`
+		Attributes struct {
+			Name string `json:"name"`
+		} `json:"attributes"`
+	} `json:"relationships"`
 }
 
 // Stores the response from a `manga/%s/feed` API query.
@@ -88,11 +106,40 @@ type SeriesFeed struct {
 	Total    int          `json:"total"`
 }
 
-// Download a chapter given the Chapter struct and return the
-// updated Chapter.
+// Stores the response from a `manga/%s/aggregate` API query:
+// every chapter of a series in any language, grouped by volume.
+// Volumes and chapters are keyed by their numbers as strings,
+// with "none" for chapters without a volume.
+type aggregateMeta struct {
+	Result  string `json:"result"`
+	Volumes aggMap[struct {
+		Volume   string `json:"volume"`
+		Chapters aggMap[struct {
+			Chapter string `json:"chapter"`
+		}] `json:"chapters"`
+	}] `json:"volumes"`
+}
+
+// The API sends an empty map as an empty list, so accept either.
+type aggMap[T any] map[string]T
+
+func (a *aggMap[T]) UnmarshalJSON(b []byte) error {
+	if string(b) == "[]" {
+		*a = aggMap[T]{}
+		return nil
+	}
+	return json.Unmarshal(b, (*map[string]T)(a))
+}
+
+// Download a chapter given the Chapter struct, process its pages
+// as its series says, and return the updated Chapter.
+// Pages already saved when a download fails are left for CheckLibrary to find.
 // NOTE: This also updates the Downloaded status in the DB.
-func dlChapter(c Chapter, store *SQLite) Chapter {
-	chap := getChapMetadata(c.ChapterHash)
+func dlChapter(ctx context.Context, c Chapter, store Store, opts Options) (Chapter, error) {
+	chap, err := getChapMetadata(ctx, c.ChapterHash)
+	if err != nil {
+		return c, err
+	}
 
 	// The regex takes a page name from the API like this:
 	// x6-23b96047cdd7217e5f493894de6d536afa046e7a33695e539a6960e2a7304d35.jpg
@@ -100,72 +147,101 @@ func dlChapter(c Chapter, store *SQLite) Chapter {
 	// 6.jpg
 	pageNameCleaner := regexp.MustCompile(`^[A-z]?([0-9]+)-.*(\.[a-z]*)`)
 
-	if err := os.MkdirAll(c.ChapterPath, 0770); err != nil {
-		log.Fatalf("%s: Failed to create directory %s", err, c.ChapterHash)
+	dir := opts.Layout.Abs(c)
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return c, err
 	}
 
 	// Respect API rate limit
-	limiter := time.Tick(350 * time.Millisecond)
+	limiter := time.Tick(opts.DownloadInterval)
 
 	for _, pageName := range chap.Chapter.Data {
 		pageURL := fmt.Sprintf("%s/data/%s/%s", chap.BaseURL, chap.Chapter.Hash, pageName)
 
 		// Clean and 0-pad each page
-		fname := fmt.Sprintf("%s/%07s", c.ChapterPath, pageNameCleaner.ReplaceAllString(pageName, "${1}${2}"))
+		fname := fmt.Sprintf("%s/%07s", dir, pageNameCleaner.ReplaceAllString(pageName, "${1}${2}"))
 
 		f, err := os.Create(fname)
 		if err != nil {
-			log.Fatalf("%s: Failed to create file %s", err, fname)
+			return c, err
 		}
 
 		<-limiter
 
-		dlPage(pageURL, f)
+		err = dlPage(ctx, pageURL, f)
+		f.Close()
+		if err != nil {
+			return c, err
+		}
+	}
+
+	processing := store.GetSummary(ctx, c.MangaID).ProcessingPolicy(opts.Processing)
+	n, err := processChapter(dir, processing)
+	if err != nil {
+		return c, err
+	} else if n > 0 {
+		log.Printf("Left %d pages of %s unprocessed, since they couldn't be decoded", n, dir)
 	}
 
-	return store.UpdateChapterDownloaded(c)
+	c.PageCount, c.ByteCount = measureChapter(dir)
+	return store.UpdateChapterDownloaded(ctx, c), nil
+}
+
+// Make a GET request that is cancelled along with ctx.
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
 }
 
 // Pull and decode a single chapter's metadata.
-func getChapMetadata(chapID string) chapterMeta {
+func getChapMetadata(ctx context.Context, chapID string) (chapterMeta, error) {
 	chapURL := fmt.Sprintf("https://api.mangadex.org/at-home/server/%s", chapID)
+	var chap chapterMeta
 
 	// Get the image delivery metadata
-	resp, err := http.Get(chapURL)
+	resp, err := httpGet(ctx, chapURL)
 	if err != nil {
-		log.Fatalf("%s: Failed to retrieve %s", err, chapURL)
+		return chap, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return chap, fmt.Errorf("retrieving %s: %s", chapURL, resp.Status)
+	}
 
 	// Attempt to decode the response into the chapter struct
-	dec := json.NewDecoder(resp.Body)
-	var chap chapterMeta
-	if err := dec.Decode(&chap); err != nil {
-		log.Fatalf("%s: Failed to decode response from %s", err, chapURL)
+	if err := json.NewDecoder(resp.Body).Decode(&chap); err != nil {
+		return chap, fmt.Errorf("decoding response from %s: %w", chapURL, err)
 	}
 
-	return chap
+	return chap, nil
 }
 
 // Download a single page.
-func dlPage(pageURL string, f *os.File) {
-	img, err := http.Get(pageURL)
+func dlPage(ctx context.Context, pageURL string, f *os.File) error {
+	img, err := httpGet(ctx, pageURL)
 	if err != nil {
-		log.Fatalf("%s: Failed to retrieve %s", err, pageURL)
+		return err
 	}
 	defer img.Body.Close()
+	if img.StatusCode != http.StatusOK {
+		return fmt.Errorf("retrieving %s: %s", pageURL, img.Status)
+	}
 
 	if _, err := io.Copy(f, img.Body); err != nil {
-		log.Fatalf("%s: Failed to write to file %s", err, f.Name())
+		return fmt.Errorf("writing %s: %w", f.Name(), err)
 	}
+	return nil
 }
 
 // Retrieve and parse the metadata for this given series from the series' ID.
-func PullMangaMeta(MangaID string) MangaMeta {
-	url := fmt.Sprintf("https://api.mangadex.org/manga/%s", MangaID)
-	resp, err := http.Get(url)
+func PullMangaMeta(ctx context.Context, MangaID string) MangaMeta {
+	url := fmt.Sprintf("https://api.mangadex.org/manga/%s?includes[]=author", MangaID)
+	resp, err := httpGet(ctx, url)
 	if err != nil {
-		log.Fatalf("%s: Failed to retrieve series from %s", err, url)
+		fatalf(ctx, err, "Failed to retrieve series from %s", url)
 	}
 	defer resp.Body.Close()
 
@@ -173,7 +249,7 @@ func PullMangaMeta(MangaID string) MangaMeta {
 
 	var m MangaMeta
 	if err := dec.Decode(&m); err != nil {
-		log.Fatalf("%s: Failed to decode response from %s", err, url)
+		fatalf(ctx, err, "Failed to decode response from %s", url)
 	}
 
 	return m
@@ -182,8 +258,8 @@ func PullMangaMeta(MangaID string) MangaMeta {
 // Create a new Manga, store it in the DB, and return it.
 // This does not do anything with feeds or getting the chapters,
 // it only gets the series info.
-func NewManga(meta MangaMeta, title string, abbrev string, store *SQLite) Manga {
-	tags := parseTags(&meta, store)
+func NewManga(ctx context.Context, meta MangaMeta, title string, abbrev string, store Store) Manga {
+	tags := parseTags(ctx, &meta, store)
 	var demo string
 	if meta.Data.Attributes.PublicationDemographic == "" {
 		demo = "Unknown"
@@ -211,6 +287,7 @@ func NewManga(meta MangaMeta, title string, abbrev string, store *SQLite) Manga
 		MangaID:      meta.Data.ID,
 		SerTitle:     abbrev,
 		FullTitle:    title,
+		AltTitles:    parseAltTitles(&meta, title),
 		Descr:        meta.Data.Attributes.Description.En,
 		TimeModified: time.Unix(0, 0),
 		Tags:         tags,
@@ -219,15 +296,16 @@ func NewManga(meta MangaMeta, title string, abbrev string, store *SQLite) Manga
 		lastChapter:  finC,
 		Demographic:  goodUpper(demo),
 		PubStatus:    goodUpper(meta.Data.Attributes.Status),
+		Author:       parseAuthors(&meta),
 	}
 
-	store.insertManga(m)
+	store.insertManga(ctx, m)
 	return m
 }
 
 // Parse the given tags, guarantee they are in the DB,
 // then return them in the Tag struct.
-func parseTags(meta *MangaMeta, store *SQLite) []Tag {
+func parseTags(ctx context.Context, meta *MangaMeta, store Store) []Tag {
 	tagNames := make([]string, 0)
 
 	for _, v := range meta.Data.Attributes.Tags {
@@ -239,8 +317,38 @@ func parseTags(meta *MangaMeta, store *SQLite) []Tag {
 		}
 	}
 
-	store.insertTags(tagNames)
-	return store.tagNamesToTags(tagNames)
+	store.insertTags(ctx, tagNames)
+	return store.tagNamesToTags(ctx, tagNames)
+}
+
+// Collect every title of the series other than the one chosen as its FullTitle.
+func parseAltTitles(meta *MangaMeta, title string) []string {
+	titles := make([]string, 0)
+	add := func(t string) {
+		if t != "" && t != title && !slices.Contains(titles, t) {
+			titles = append(titles, t)
+		}
+	}
+
+	add(meta.Data.Attributes.Title.En)
+	for _, v := range meta.Data.Attributes.AltTitles {
+		add(v.En)
+		add(v.Ja)
+		add(v.JaRo)
+	}
+
+	return titles
+}
+
+// Join the names of the authors of the series.
+func parseAuthors(meta *MangaMeta) string {
+	names := make([]string, 0)
+	for _, r := range meta.Data.Relationships {
+		if r.Type == "author" && r.Attributes.Name != "" && !slices.Contains(names, r.Attributes.Name) {
+			names = append(names, r.Attributes.Name)
+		}
+	}
+	return strings.Join(names, ", ")
 }
 
 // Helper to uppercase the first letter of a string
@@ -251,49 +359,71 @@ func goodUpper(text string) string {
 
 // Pull the MD feed and add the chapters to the DB.
 // Returns the updated Manga.
-func RefreshFeed(manga Manga, store *SQLite) Manga {
-	// Implementation note: Right now, this function only gets new chapters.
-	// However, it may be useful later to rework it to get everything every time, which would
-	// automatically update when MD sorts or updates old chapters.
+func RefreshFeed(ctx context.Context, manga Manga, store Store, opts Options) Manga {
+	// Implementation note: The whole feed is pulled every time, since chapters can be
+	// published late, with a publishAt before the last refresh. Only new chapters are kept,
+	// but it may be useful later to also update when MD sorts or updates old chapters.
 	offset := 0
-	feed := pullFeedMeta(manga.MangaID, offset, manga.TimeModified)
+	feed := pullFeedMeta(ctx, manga.MangaID, offset, opts.Languages)
 
+	// The library doesn't load chapters until they're needed
+	if manga.Chapters == nil {
+		manga.Chapters = store.GetChapters(ctx, manga.MangaID)
+	}
 	chapters := manga.Chapters
+	known := make(map[string]bool, len(chapters))
+	for _, c := range chapters {
+		known[c.ChapterHash] = true
+	}
+	added := 0
+
+	// Series added before alt titles were kept get them now, so search can find them.
+	// A series that has none asks again on each refresh, which is only one more request.
+	if len(manga.AltTitles) == 0 {
+		meta := PullMangaMeta(ctx, manga.MangaID)
+		manga.AltTitles = parseAltTitles(&meta, manga.FullTitle)
+		store.insertAltTitles(ctx, manga.MangaID, manga.AltTitles)
+	}
 
 	for ok := true; ok; ok = feed.Offset < feed.Total {
-		pageChapters := parseChData(feed.Data, manga.MangaID, manga.SerTitle)
-		chapters = append(chapters, pageChapters...)
+		for _, c := range parseChData(feed.Data, manga, opts) {
+			if !known[c.ChapterHash] {
+				known[c.ChapterHash] = true
+				chapters = append(chapters, c)
+				added++
+			}
+		}
 		offset += 50
-		feed = pullFeedMeta(manga.MangaID, offset, manga.TimeModified)
+		feed = pullFeedMeta(ctx, manga.MangaID, offset, opts.Languages)
 	}
 
 	slices.SortFunc(chapters, chapterCmp)
 	manga.Chapters = chapters
-	store.insertChapters(chapters)
-	//manga = store.UpdateTimeModified(manga)
+	store.insertChapters(ctx, chapters)
+	// TimeModified is when the series last got new chapters
+	if added > 0 {
+		manga = store.UpdateTimeModified(ctx, manga)
+	}
 	return manga
 }
 
 // Handle all the ugly stuff of parsing the chapters from the API response.
-func parseChData(data []feedChData, mangaID string, abbrev string) []Chapter {
+func parseChData(data []feedChData, manga Manga, opts Options) []Chapter {
 	chapters := make([]Chapter, 0)
-	var err error
 	for _, d := range data {
-		var title string
-		if d.Attributes.Title == "" {
-			title = fmt.Sprintf("Ch. %s", d.Attributes.Chapter)
-		} else {
-			title = d.Attributes.Title
+		c := Chapter{
+			ChapterHash:   d.ID,
+			ChapterName:   d.Attributes.Title,
+			MangaID:       manga.MangaID,
+			TimePublished: d.Attributes.PublishAt,
+			Downloaded:    false,
+			IsRead:        false,
 		}
-
-		var chNum float64
-		if d.Attributes.Chapter == "" {
-			chNum = 0
-		} else {
-			chNum, err = strconv.ParseFloat(d.Attributes.Chapter, 64)
-			if err != nil {
-				log.Fatalf("%s: Failed to parse float from %s", err, d.Attributes.Chapter)
-			}
+		setChapterNumber(&c, d.Attributes.Chapter)
+		if c.ChapterName == "" && c.Number().Kind == KindNumbered {
+			c.ChapterName = fmt.Sprintf("Ch. %s", c.Number())
+		} else if c.ChapterName == "" {
+			c.ChapterName = c.Number().String()
 		}
 
 		var vol int
@@ -313,18 +443,15 @@ func parseChData(data []feedChData, mangaID string, abbrev string) []Chapter {
 			vol = int(v)
 		}
 
-		path := fmt.Sprintf("/home/twells/media/manga/%s/%02d/%05.1f-%s", abbrev, vol, chNum, d.ID)
-
-		c := Chapter{
-			ChapterHash: d.ID,
-			ChapterNum:  chNum,
-			ChapterName: title,
-			VolumeNum:   vol,
-			MangaID:     mangaID,
-			Downloaded:  false,
-			IsRead:      false,
-			ChapterPath: path,
+		c.VolumeNum = vol
+		c.Language = d.Attributes.TranslatedLanguage
+		for _, r := range d.Relationships {
+			if r.Type == "scanlation_group" {
+				c.GroupName = r.Attributes.Name
+				break
+			}
 		}
+		c.ChapterPath = opts.Layout.ChapterPath(manga, c)
 		chapters = append(chapters, c)
 	}
 
@@ -332,39 +459,73 @@ func parseChData(data []feedChData, mangaID string, abbrev string) []Chapter {
 }
 
 // Pull and decode the feed for a series.
-func pullFeedMeta(mangaID string, offset int, lastUpdated time.Time) SeriesFeed {
+func pullFeedMeta(ctx context.Context, mangaID string, offset int, languages []string) SeriesFeed {
 	feedURL := fmt.Sprintf("https://api.mangadex.org/manga/%s/feed", mangaID)
 	params := url.Values{}
-	params.Add("translatedLanguage[]", "en")
+	for _, l := range languages {
+		params.Add("translatedLanguage[]", l)
+	}
 	params.Add("includeExternalUrl", "0")
+	params.Add("includes[]", "scanlation_group")
 	params.Add("offset", fmt.Sprint(offset))
-	params.Add("publishAtSince", lastUpdated.Format("2006-01-02T15:04:05"))
 	params.Add("limit", "50")
 	fullURL := fmt.Sprintf("%s?%s", feedURL, params.Encode())
 
-	feedResp, err := http.Get(fullURL)
+	feedResp, err := httpGet(ctx, fullURL)
 	if err != nil {
-		log.Fatalf("%s: Failed to retrieve feed %s", err, fullURL)
+		fatalf(ctx, err, "Failed to retrieve feed %s", fullURL)
 	}
 	defer feedResp.Body.Close()
 
 	dec := json.NewDecoder(feedResp.Body)
 	var m SeriesFeed
 	if err := dec.Decode(&m); err != nil {
-		log.Fatalf("%s: Failed to decode response from %s", err, fullURL)
+		fatalf(ctx, err, "Failed to decode response from %s", fullURL)
 	}
 
 	return m
 }
 
+func pullAggregate(ctx context.Context, mangaID string) (aggregateMeta, error) {
+	fullURL := fmt.Sprintf("https://api.mangadex.org/manga/%s/aggregate", mangaID)
+	var a aggregateMeta
+
+	resp, err := httpGet(ctx, fullURL)
+	if err != nil {
+		return a, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return a, fmt.Errorf("retrieving %s: %s", fullURL, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return a, fmt.Errorf("decoding response from %s: %w", fullURL, err)
+	}
+
+	return a, nil
+}
+
 // Downloads the given chapters, returning the updated entries.
 // Any chapters with Chapter.Downloaded == true are ignored.
-func DownloadChapters(store *SQLite, chapters ...Chapter) []Chapter {
+// A failed download is fatal; see DownloadChapter to handle it instead.
+func DownloadChapters(ctx context.Context, store Store, opts Options, chapters ...Chapter) []Chapter {
 	for i, c := range chapters {
-		if !c.Downloaded {
-			chapters[i] = dlChapter(c, store)
+		var err error
+		if chapters[i], err = DownloadChapter(ctx, store, opts, c); err != nil {
+			fatalf(ctx, err, "Failed to download chapter %s", c.ChapterHash)
 		}
 	}
 
 	return chapters
 }
+
+// Download a single chapter, unless it is already downloaded,
+// returning the updated entry or why it couldn't be downloaded.
+// The chapter stays not downloaded on failure.
+func DownloadChapter(ctx context.Context, store Store, opts Options, c Chapter) (Chapter, error) {
+	if c.Downloaded {
+		return c, nil
+	}
+	return dlChapter(ctx, c, store, opts)
+}
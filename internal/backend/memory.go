@@ -0,0 +1,554 @@
+package backend
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The values allowed by the checks on the Manga table
+var (
+	demographics = []string{"Shounen", "Shoujo", "Seinen", "Josei", "Unknown"}
+	pubStatuses  = []string{"Ongoing", "Completed", "Hiatus", "Cancelled"}
+	readStatuses = append([]string{""}, ReadStatuses...)
+)
+
+// A Store that keeps everything in memory, behaving the same as SQLite.
+// Nothing is saved when the program exits.
+type Memory struct {
+	mu          sync.Mutex
+	manga       []Manga            // In the order they were added, without chapters
+	chapters    map[string]Chapter // By ChapterHash
+	tags        []Tag
+	reviews     map[string]Review // By MangaID
+	events      []ReadEvent
+	statuses    []StatusChange
+	collections []Collection
+	items       map[int][]string // The MangaIDs in each Collection, by CollectionID
+	filters     map[string]SavedFilter
+}
+
+// Return a new, empty Memory store
+func NewMemory() *Memory {
+	return &Memory{
+		chapters: make(map[string]Chapter),
+		reviews:  make(map[string]Review),
+		items:    make(map[int][]string),
+		filters:  make(map[string]SavedFilter),
+	}
+}
+
+// Find the index of a Manga by ID, or -1 if it isn't stored
+func (r *Memory) mangaIndex(mangaID string) int {
+	return slices.IndexFunc(r.manga, func(m Manga) bool { return m.MangaID == mangaID })
+}
+
+// Fill in the review and Summary of a stored Manga, copying its slices
+// so callers can't change what's stored.
+func (r *Memory) summarize(m Manga) Manga {
+	m.Tags = slices.Clone(m.Tags)
+	m.AltTitles = slices.Clone(m.AltTitles)
+	m.Review = r.reviews[m.MangaID]
+	m.Summary = Summary{}
+	for _, c := range r.chapters {
+		if c.MangaID != m.MangaID {
+			continue
+		}
+		m.Summary.Chapters++
+		if !c.IsRead {
+			m.Summary.Unread++
+		}
+		if c.Downloaded {
+			m.Summary.Downloaded++
+			m.Summary.Pages += c.PageCount
+			m.Summary.Bytes += c.ByteCount
+		}
+		m.Summary.Latest = max(m.Summary.Latest, c.ChapterNum)
+		m.Summary.LatestVolume = max(m.Summary.LatestVolume, c.VolumeNum)
+	}
+	return m
+}
+
+// Get every stored Manga for which keep returns true, with tags, review and Summary
+func (r *Memory) queryManga(keep func(m Manga) bool) []Manga {
+	all := make([]Manga, 0)
+	for _, m := range r.manga {
+		if m = r.summarize(m); keep(m) {
+			all = append(all, m)
+		}
+	}
+	return all
+}
+
+// The names of the collections a Manga is in
+func (r *Memory) collectionNames(mangaID string) []string {
+	names := make([]string, 0)
+	for _, c := range r.collections {
+		if slices.Contains(r.items[c.CollectionID], mangaID) {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// ------- CREATE FUNCTIONS -------
+
+func (r *Memory) insertTags(ctx context.Context, names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		if !slices.ContainsFunc(r.tags, func(t Tag) bool { return t.TagTitle == name }) {
+			r.tags = append(r.tags, Tag{TagID: len(r.tags) + 1, TagTitle: name})
+		}
+	}
+}
+
+func (r *Memory) insertChapters(ctx context.Context, chapters []Chapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addChapters(chapters)
+}
+
+// Add chapters, ignoring any that already exist. The lock must be held.
+func (r *Memory) addChapters(chapters []Chapter) {
+	for _, c := range chapters {
+		if _, ok := r.chapters[c.ChapterHash]; !ok {
+			r.chapters[c.ChapterHash] = c
+		}
+	}
+}
+
+func (r *Memory) insertManga(ctx context.Context, m Manga) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if slices.ContainsFunc(r.manga, func(o Manga) bool { return o.MangaID == m.MangaID || o.SerTitle == m.SerTitle }) {
+		log.Fatalf("Failed to insert %v: Already exists", m)
+	}
+	// The same checks as the Manga table
+	if !slices.Contains(demographics, m.Demographic) || !slices.Contains(pubStatuses, m.PubStatus) || !slices.Contains(readStatuses, m.ReadStatus) {
+		log.Fatalf("Failed to insert %v: Bad demographic, publication status or read status", m)
+	}
+
+	r.addChapters(m.Chapters)
+	m.Chapters = nil
+	m.Tags = slices.Clone(m.Tags)
+	titles := make([]string, 0)
+	for _, t := range m.AltTitles {
+		if !slices.Contains(titles, t) {
+			titles = append(titles, t)
+		}
+	}
+	m.AltTitles = titles
+	r.manga = append(r.manga, m)
+}
+
+func (r *Memory) insertAltTitles(ctx context.Context, mangaID string, titles []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.mangaIndex(mangaID)
+	if i < 0 {
+		log.Fatalf("Failed to insert alt titles: No manga %s", mangaID)
+	}
+	for _, t := range titles {
+		if !slices.Contains(r.manga[i].AltTitles, t) {
+			r.manga[i].AltTitles = append(r.manga[i].AltTitles, t)
+		}
+	}
+}
+
+func (r *Memory) AddToCollection(ctx context.Context, name string, mangaID string) Collection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.collections, func(c Collection) bool { return c.Name == name })
+	if i < 0 {
+		r.collections = append(r.collections, Collection{CollectionID: len(r.collections) + 1, Name: name})
+		i = len(r.collections) - 1
+	}
+
+	c := r.collections[i]
+	if !slices.Contains(r.items[c.CollectionID], mangaID) {
+		r.items[c.CollectionID] = append(r.items[c.CollectionID], mangaID)
+	}
+	return c
+}
+
+func (r *Memory) insertReview(ctx context.Context, rev Review) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.reviews[rev.MangaID]; ok {
+		log.Fatalf("Failed to insert %v: Already exists", rev)
+	}
+	if rev.Rating < 0 || rev.Rating > 100 {
+		log.Fatalf("Failed to insert %v: Rating out of range", rev)
+	}
+	r.reviews[rev.MangaID] = rev
+}
+
+func (r *Memory) insertReadEvent(ctx context.Context, e ReadEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.EventID = len(r.events) + 1
+	r.events = append(r.events, e)
+}
+
+func (r *Memory) insertStatusChange(ctx context.Context, c StatusChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, c)
+}
+
+func (r *Memory) SaveFilter(ctx context.Context, name string, f Filter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filters[name] = SavedFilter{FilterName: name, Expr: f.Expr}
+}
+
+// ------- READ FUNCTIONS -------
+
+func (r *Memory) tagNamesToTags(ctx context.Context, names []string) []Tag {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tags := make([]Tag, 0)
+	for _, name := range names {
+		i := slices.IndexFunc(r.tags, func(t Tag) bool { return t.TagTitle == name })
+		if i < 0 {
+			log.Fatalf("Failed to get tags: No tag %s", name)
+		}
+		tags = append(tags, r.tags[i])
+	}
+	return tags
+}
+
+func (r *Memory) mangaExists(ctx context.Context, mangaID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mangaIndex(mangaID) >= 0
+}
+
+func (r *Memory) serTitleID(ctx context.Context, abbrev string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.manga, func(m Manga) bool { return m.SerTitle == abbrev })
+	if i < 0 {
+		return "", false
+	}
+	return r.manga[i].MangaID, true
+}
+
+func (r *Memory) GetAll(ctx context.Context) []Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queryManga(func(Manga) bool { return true })
+}
+
+func (r *Memory) GetByID(ctx context.Context, mangaID string) Manga {
+	m := r.GetSummary(ctx, mangaID)
+	m.Chapters = r.GetChapters(ctx, mangaID)
+	return m
+}
+
+func (r *Memory) GetSummary(ctx context.Context, mangaID string) Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.mangaIndex(mangaID)
+	if i < 0 {
+		log.Fatalf("Failed to query db for manga %s: Found 0", mangaID)
+	}
+	return r.summarize(r.manga[i])
+}
+
+func (r *Memory) GetChapters(ctx context.Context, mangaID string) []Chapter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make([]Chapter, 0)
+	for _, c := range r.chapters {
+		if c.MangaID == mangaID {
+			all = append(all, c)
+		}
+	}
+	slices.SortFunc(all, chapterCmp)
+	return all
+}
+
+func (r *Memory) GetReview(ctx context.Context, mangaID string) Review {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reviews[mangaID]
+}
+
+func (r *Memory) GetCollections(ctx context.Context) []Collection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := slices.Clone(r.collections)
+	slices.SortFunc(all, func(a, b Collection) int { return strings.Compare(a.Name, b.Name) })
+	return all
+}
+
+func (r *Memory) GetTags(ctx context.Context) []TagCount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[Tag]int)
+	for _, m := range r.manga {
+		for _, t := range m.Tags {
+			counts[t]++
+		}
+	}
+	all := make([]TagCount, 0, len(counts))
+	for t, n := range counts {
+		all = append(all, TagCount{t, n})
+	}
+	slices.SortFunc(all, func(a, b TagCount) int { return strings.Compare(a.TagTitle, b.TagTitle) })
+	return all
+}
+
+func (r *Memory) GetCollectionManga(ctx context.Context, c Collection) []Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queryManga(func(m Manga) bool { return slices.Contains(r.items[c.CollectionID], m.MangaID) })
+}
+
+func (r *Memory) FilterManga(ctx context.Context, f Filter) []Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queryManga(func(m Manga) bool { return f.match(m, r.collectionNames(m.MangaID)) })
+}
+
+func (r *Memory) GetSavedFilters(ctx context.Context) []SavedFilter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make([]SavedFilter, 0)
+	for _, f := range r.filters {
+		all = append(all, f)
+	}
+	slices.SortFunc(all, func(a, b SavedFilter) int { return strings.Compare(a.FilterName, b.FilterName) })
+	return all
+}
+
+// Search the same way as SQLite does without the full-text index:
+// every word has to appear somewhere, and matches in the titles rank highest.
+func (r *Memory) Search(ctx context.Context, text string) []Manga {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) == 0 {
+		return []Manga{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	scores := make(map[string]int)
+	found := r.queryManga(func(m Manga) bool {
+		for _, w := range words {
+			has := func(s string) bool { return strings.Contains(strings.ToLower(s), w) }
+			switch {
+			case has(m.FullTitle) || has(m.SerTitle):
+				scores[m.MangaID] += 10
+			case slices.ContainsFunc(m.AltTitles, has):
+				scores[m.MangaID] += 5
+			case has(m.Descr) || has(m.Review.Rev):
+				scores[m.MangaID]++
+			default:
+				return false
+			}
+		}
+		return true
+	})
+
+	slices.SortStableFunc(found, func(a, b Manga) int { return scores[b.MangaID] - scores[a.MangaID] })
+	return found
+}
+
+// Get the stored reading history for which keep returns true, most recent first,
+// returning at most limit events. A negative limit returns everything.
+func (r *Memory) queryHistory(limit int, keep func(e ReadEvent) bool) []ReadEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make([]ReadEvent, 0)
+	for _, e := range r.events {
+		c, ok := r.chapters[e.ChapterHash]
+		i := r.mangaIndex(c.MangaID)
+		if !ok || i < 0 {
+			continue
+		}
+		e.MangaID, e.FullTitle = c.MangaID, r.manga[i].FullTitle
+		e.ChapterRaw, e.ChapterName = c.ChapterRaw, c.ChapterName
+		if keep(e) {
+			all = append(all, e)
+		}
+	}
+
+	slices.SortStableFunc(all, func(a, b ReadEvent) int { return b.Start.Compare(a.Start) })
+	if limit >= 0 && len(all) > limit {
+		all = all[:limit]
+	}
+	return all
+}
+
+func (r *Memory) GetHistory(ctx context.Context, limit int) []ReadEvent {
+	return r.queryHistory(limit, func(ReadEvent) bool { return true })
+}
+
+func (r *Memory) GetSeriesHistory(ctx context.Context, mangaID string) []ReadEvent {
+	return r.queryHistory(-1, func(e ReadEvent) bool { return e.MangaID == mangaID })
+}
+
+func (r *Memory) GetStatusHistory(ctx context.Context, mangaID string) []StatusChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make([]StatusChange, 0)
+	for _, c := range r.statuses {
+		if c.MangaID == mangaID {
+			all = append(all, c)
+		}
+	}
+	slices.SortStableFunc(all, func(a, b StatusChange) int { return b.TimeChanged.Compare(a.TimeChanged) })
+	return all
+}
+
+// ------- UPDATE FUNCTIONS -------
+
+func (r *Memory) UpdateTimeModified(ctx context.Context, m Manga) Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m.TimeModified = time.Now()
+	if i := r.mangaIndex(m.MangaID); i >= 0 {
+		r.manga[i].TimeModified = m.TimeModified
+	}
+	return m
+}
+
+// Fail the same way as the check on the Manga table for a status it doesn't allow
+func checkReadStatus(status string) {
+	if !slices.Contains(readStatuses, status) {
+		log.Fatalf("Failed to update read status: Bad status %q", status)
+	}
+}
+
+func (r *Memory) UpdateReadStatus(ctx context.Context, m Manga, status string) Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	checkReadStatus(status)
+
+	if i := r.mangaIndex(m.MangaID); i >= 0 {
+		r.manga[i].ReadStatus = status
+	}
+	r.statuses = append(r.statuses, StatusChange{MangaID: m.MangaID, ReadStatus: status, TimeChanged: time.Now()})
+
+	m.ReadStatus = status
+	return m
+}
+
+func (r *Memory) UpdateRetention(ctx context.Context, m Manga, policy string) Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := r.mangaIndex(m.MangaID); i >= 0 {
+		r.manga[i].Retention = policy
+	}
+	m.Retention = policy
+	return m
+}
+
+func (r *Memory) UpdateProcessing(ctx context.Context, m Manga, processing string) Manga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := r.mangaIndex(m.MangaID); i >= 0 {
+		r.manga[i].Processing = processing
+	}
+	m.Processing = processing
+	return m
+}
+
+func (r *Memory) setReadStatus(ctx context.Context, mangaID string, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	checkReadStatus(status)
+
+	if i := r.mangaIndex(mangaID); i >= 0 {
+		r.manga[i].ReadStatus = status
+	}
+}
+
+// Apply a change to a stored chapter, if it exists. The lock must be held.
+func (r *Memory) updateChapter(hash string, update func(c *Chapter)) {
+	if c, ok := r.chapters[hash]; ok {
+		update(&c)
+		r.chapters[hash] = c
+	}
+}
+
+func (r *Memory) UpdateChapterDownloaded(ctx context.Context, c Chapter) Chapter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.updateChapter(c.ChapterHash, func(s *Chapter) { s.Downloaded, s.PageCount, s.ByteCount = true, c.PageCount, c.ByteCount })
+	c.Downloaded = true
+	return c
+}
+
+func (r *Memory) UpdateChapterRead(ctx context.Context, c Chapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updateChapter(c.ChapterHash, func(s *Chapter) { s.IsRead, s.TimeRead = true, time.Now() })
+}
+
+func (r *Memory) UpdateChapterProgress(ctx context.Context, c Chapter, page int, finished bool) Chapter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if finished {
+		c.TimeRead = time.Now()
+	}
+	r.updateChapter(c.ChapterHash, func(s *Chapter) {
+		s.LastPage = page
+		s.IsRead = s.IsRead || finished
+		if finished {
+			s.TimeRead = c.TimeRead
+		}
+	})
+	c.LastPage = page
+	c.IsRead = c.IsRead || finished
+	return c
+}
+
+func (r *Memory) updateChapterState(ctx context.Context, c Chapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.updateChapter(c.ChapterHash, func(s *Chapter) {
+		s.Downloaded, s.IsRead, s.LastPage, s.TimeRead = c.Downloaded, c.IsRead, c.LastPage, c.TimeRead
+	})
+}
+
+// ------- DELETE FUNCTIONS -------
+
+func (r *Memory) RemoveFromCollection(ctx context.Context, c Collection, mangaID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.items[c.CollectionID] = slices.DeleteFunc(r.items[c.CollectionID], func(id string) bool { return id == mangaID })
+}
+
+// Run f against the store itself. A failure is fatal, so the store is never
+// left half-changed for anything to see.
+func (r *Memory) transaction(ctx context.Context, f func(store Store)) {
+	f(r)
+}
@@ -0,0 +1,7 @@
+//go:build !(sqlite_fts5 || fts5)
+
+package backend
+
+// go-sqlite3 only includes FTS5 when built with the sqlite_fts5 tag.
+// Without it, Search falls back to plain substring matching.
+const haveFTS5 = false
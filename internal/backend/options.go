@@ -0,0 +1,17 @@
+package backend
+
+import "time"
+
+// Settings for fetching, downloading and reading, beyond what's in the Store.
+type Options struct {
+	Layout           Layout
+	Languages        []string      // Translation languages to fetch chapters in
+	DownloadInterval time.Duration // The least time between page downloads, to respect the API rate limit
+	// The reader command and its arguments. It has to accept imv's options,
+	// since the start page and a key binding to report the last page are added.
+	Reader []string
+	// The cleanup policy of series that don't have their own
+	Retention Retention
+	// How downloaded pages are processed, for series that don't say
+	Processing Processing
+}
@@ -0,0 +1,266 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// How the pages of a chapter are changed after they are downloaded.
+// The zero value leaves them as they were served.
+type Processing struct {
+	Format    string // "jpeg" or "png" to convert pages to, empty to keep their format
+	MaxHeight int    // Pages taller than this are scaled down, 0 for no limit
+	Gray      bool   // Convert pages to grayscale, for e-ink
+	Quality   int    // The JPEG quality to recompress with, 0 to only re-encode pages that are changed
+	Keep      bool   // Keep the original pages in a hidden folder of the chapter
+}
+
+// The processing the TUI cycles through, after the default
+var ProcessingPresets = []string{"none", "height:2000", "gray,height:1600,quality:80", "gray,height:1600,quality:80,keep"}
+
+// The folder in a chapter that Keep moves the original pages to.
+// It is hidden, so it isn't taken for pages.
+const originalsDir = ".originals"
+
+// Parse a comma-separated Processing, like "jpeg,height:1600,gray,quality:80,keep",
+// or "none" for no processing.
+func ParseProcessing(s string) (Processing, error) {
+	var p Processing
+	if s == "none" {
+		return p, nil
+	}
+
+	for _, opt := range strings.Split(s, ",") {
+		name, val, found := strings.Cut(opt, ":")
+		num, err := strconv.Atoi(val)
+		switch {
+		case !found && (name == "jpeg" || name == "png"):
+			p.Format = name
+		case !found && name == "gray":
+			p.Gray = true
+		case !found && name == "keep":
+			p.Keep = true
+		case found && name == "height":
+			if err != nil || num <= 0 {
+				return Processing{}, fmt.Errorf("processing %q needs a positive height", s)
+			}
+			p.MaxHeight = num
+		case found && name == "quality":
+			if err != nil || num < 1 || num > 100 {
+				return Processing{}, fmt.Errorf("processing %q needs a quality from 1 to 100", s)
+			}
+			p.Quality = num
+		default:
+			return Processing{}, fmt.Errorf(`processing %q should be "none" or a list of jpeg, png, height:N, gray, quality:N and keep`, s)
+		}
+	}
+	return p, nil
+}
+
+func (p Processing) String() string {
+	opts := make([]string, 0)
+	if p.Format != "" {
+		opts = append(opts, p.Format)
+	}
+	if p.MaxHeight > 0 {
+		opts = append(opts, fmt.Sprintf("height:%d", p.MaxHeight))
+	}
+	if p.Gray {
+		opts = append(opts, "gray")
+	}
+	if p.Quality > 0 {
+		opts = append(opts, fmt.Sprintf("quality:%d", p.Quality))
+	}
+	if len(opts) == 0 {
+		return "none"
+	}
+	if p.Keep {
+		opts = append(opts, "keep")
+	}
+	return strings.Join(opts, ",")
+}
+
+// Whether the processing changes anything
+func (p Processing) IsZero() bool {
+	return p.Format == "" && p.MaxHeight == 0 && !p.Gray && p.Quality == 0
+}
+
+// The processing a Manga uses: its own, or else the default.
+// Processing that can't be parsed, say from an imported library, falls back to the default.
+func (m Manga) ProcessingPolicy(def Processing) Processing {
+	if m.Processing == "" {
+		return def
+	}
+	p, err := ParseProcessing(m.Processing)
+	if err != nil {
+		log.Printf("%s: Using the default processing for %s", err, m.SerTitle)
+		return def
+	}
+	return p
+}
+
+// Process the pages of a downloaded chapter in place.
+// Pages that can't be decoded, like webp, are left as they are.
+// Returns how many pages were left that way.
+func processChapter(dir string, p Processing) (int, error) {
+	if p.IsZero() {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	skipped := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		ok, err := processPage(dir, e.Name(), p)
+		if err != nil {
+			return skipped, fmt.Errorf("processing %s: %w", filepath.Join(dir, e.Name()), err)
+		} else if !ok {
+			skipped++
+		}
+	}
+	return skipped, nil
+}
+
+// Process a single page, replacing it with the result.
+// A page that is only recompressed is only replaced if it gets smaller.
+// Returns false if the page couldn't be decoded.
+func processPage(dir, name string, p Processing) (bool, error) {
+	path := filepath.Join(dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return false, nil
+	}
+
+	// Only JPEG and PNG can be written, so GIFs become PNGs
+	target := p.Format
+	if target == "" && format == "jpeg" {
+		target = "jpeg"
+	} else if target == "" {
+		target = "png"
+	}
+
+	_, isGray := src.(*image.Gray)
+	resize := p.MaxHeight > 0 && src.Bounds().Dy() > p.MaxHeight
+	changed := target != format || resize || (p.Gray && !isGray)
+	if !changed && (p.Quality == 0 || format != "jpeg") {
+		return true, nil
+	}
+
+	img := flatten(src, p.Gray || isGray)
+	if resize {
+		img = downscale(img, p.MaxHeight)
+	}
+
+	var buf bytes.Buffer
+	switch target {
+	case "jpeg":
+		quality := p.Quality
+		if quality == 0 {
+			quality = 90
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	default:
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&buf, img)
+	}
+	if err != nil {
+		return false, err
+	}
+	if !changed && buf.Len() >= len(data) {
+		return true, nil
+	}
+
+	if p.Keep {
+		orig := filepath.Join(dir, originalsDir)
+		if err := os.MkdirAll(orig, 0770); err != nil {
+			return false, err
+		}
+		if err := os.Rename(path, filepath.Join(orig, name)); err != nil {
+			return false, err
+		}
+	} else if err := os.Remove(path); err != nil {
+		return false, err
+	}
+
+	ext := map[string]string{"jpeg": ".jpg", "png": ".png"}[target]
+	return true, os.WriteFile(strings.TrimSuffix(path, filepath.Ext(path))+ext, buf.Bytes(), 0644)
+}
+
+// Copy an image into one whose pixels can be worked on directly:
+// grayscale, or RGBA with any transparency flattened onto white.
+func flatten(src image.Image, gray bool) draw.Image {
+	b := src.Bounds()
+	var dst draw.Image
+	if gray {
+		dst = image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	}
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+	return dst
+}
+
+// Scale an image from flatten down to the given height, keeping its shape.
+// Each new pixel is the average of the pixels it covers.
+func downscale(src draw.Image, height int) draw.Image {
+	var pix []byte
+	var stride, channels int
+	switch img := src.(type) {
+	case *image.Gray:
+		pix, stride, channels = img.Pix, img.Stride, 1
+	case *image.RGBA:
+		pix, stride, channels = img.Pix, img.Stride, 4
+	}
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dw := max(sw*height/sh, 1)
+	out := make([]byte, dw*height*channels)
+	sums := make([]int, channels)
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			clear(sums)
+			for sy := y0; sy < y1; sy++ {
+				row := pix[sy*stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range sums {
+						sums[c] += int(row[sx*channels+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			for c, s := range sums {
+				out[(y*dw+x)*channels+c] = byte(s / n)
+			}
+		}
+	}
+
+	r := image.Rect(0, 0, dw, height)
+	if channels == 1 {
+		return &image.Gray{Pix: out, Stride: dw, Rect: r}
+	}
+	return &image.RGBA{Pix: out, Stride: dw * 4, Rect: r}
+}
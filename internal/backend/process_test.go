@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestParseProcessing(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Processing
+		ok   bool
+	}{
+		{"none", Processing{}, true},
+		{"jpeg", Processing{Format: "jpeg"}, true},
+		{"png,gray", Processing{Format: "png", Gray: true}, true},
+		{"height:2000", Processing{MaxHeight: 2000}, true},
+		{"jpeg,height:1600,gray,quality:80,keep", Processing{Format: "jpeg", MaxHeight: 1600, Gray: true, Quality: 80, Keep: true}, true},
+		{"quality:1", Processing{Quality: 1}, true},
+		{"quality:100", Processing{Quality: 100}, true},
+		{"", Processing{}, false},
+		{"webp", Processing{}, false},
+		{"gray,", Processing{}, false},
+		{"gray:1", Processing{}, false},
+		{"jpeg:90", Processing{}, false},
+		{"height", Processing{}, false},
+		{"height:0", Processing{}, false},
+		{"height:-5", Processing{}, false},
+		{"height:tall", Processing{}, false},
+		{"quality:0", Processing{}, false},
+		{"quality:101", Processing{}, false},
+		{"none,gray", Processing{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseProcessing(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseProcessing(%q) = %+v, %v, want %+v, ok %t", tt.in, got, err, tt.want, tt.ok)
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("ParseProcessing(%q).String() = %q", tt.in, got)
+		}
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	gray := func(w, h int, pix ...byte) *image.Gray {
+		return &image.Gray{Pix: pix, Stride: w, Rect: image.Rect(0, 0, w, h)}
+	}
+	tests := []struct {
+		name   string
+		src    *image.Gray
+		height int
+		want   *image.Gray
+	}{
+		{"halved", gray(4, 4,
+			0, 0, 100, 100,
+			0, 0, 100, 100,
+			200, 200, 40, 40,
+			200, 200, 40, 50),
+			2, gray(2, 2, 0, 100, 200, 42)},
+		{"uneven", gray(3, 3,
+			10, 20, 30,
+			40, 50, 60,
+			70, 80, 90),
+			2, gray(2, 2, 10, 25, 55, 70)},
+		{"narrow", gray(1, 6, 0, 10, 20, 30, 40, 50), 3, gray(1, 3, 5, 25, 45)},
+		{"too narrow to keep its shape", gray(2, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9), 2, gray(1, 2, 0, 9)},
+	}
+	for _, tt := range tests {
+		got, ok := downscale(tt.src, tt.height).(*image.Gray)
+		if !ok || got.Rect != tt.want.Rect || !slices.Equal(got.Pix, tt.want.Pix) {
+			t.Errorf("%s: downscale gave %v %v, want %v %v", tt.name, got.Rect, got.Pix, tt.want.Rect, tt.want.Pix)
+		}
+	}
+
+	// RGBA averages each channel on its own
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	copy(src.Pix, []byte{
+		255, 0, 0, 255, 0, 255, 0, 255,
+		0, 0, 255, 255, 255, 255, 255, 255,
+	})
+	got, ok := downscale(src, 1).(*image.RGBA)
+	if !ok || got.Rect != image.Rect(0, 0, 1, 1) || !slices.Equal(got.Pix, []byte{127, 127, 127, 255}) {
+		t.Errorf("RGBA downscale gave %v, want one pixel of 127, 127, 127, 255", got)
+	}
+}
@@ -0,0 +1,79 @@
+package backend
+
+import "fmt"
+
+// Where a series stands, comparing the chapters we have and have read
+// with the final chapter and volume MangaDex lists for it.
+type SeriesState int
+
+const (
+	StateNoChapters      SeriesState = iota // Nothing has been published, or fetched yet
+	StateCaughtUp                           // Every available chapter of an unfinished series is read
+	StateBehind                             // Chapters of an unfinished series are unread
+	StateFinished                           // The series is finished and its final chapter is available
+	StateFinishedRead                       // The series is finished and every chapter is read
+	StateMissingChapters                    // The series is finished but its final chapter isn't available
+)
+
+// The computed SeriesState of a Manga, along with the numbers behind it.
+type Progress struct {
+	State  SeriesState
+	Unread int
+	Latest float64 // The highest chapter number available
+	Final  float64 // The final chapter number, 0 if unknown
+}
+
+func (p Progress) String() string {
+	switch p.State {
+	case StateNoChapters:
+		return "No chapters"
+	case StateCaughtUp:
+		return "Caught up"
+	case StateBehind:
+		return fmt.Sprintf("Behind by %d", p.Unread)
+	case StateFinished:
+		return fmt.Sprintf("Finished, all available, %d unread", p.Unread)
+	case StateFinishedRead:
+		return "Finished and fully read"
+	case StateMissingChapters:
+		if p.Final > 0 {
+			return fmt.Sprintf("Finished, missing chapters (have %g of %g)", p.Latest, p.Final)
+		}
+		return "Finished, missing chapters"
+	}
+	return ""
+}
+
+// Work out the Progress of a Manga from its Summary.
+//
+// MangaDex only fills in the last chapter and volume once a series has
+// finished, so having either one set means there won't be any more chapters.
+// Neither is always filled in, so a Completed series is finished regardless.
+func (m Manga) Progress() Progress {
+	p := Progress{Unread: m.Summary.Unread, Latest: m.Summary.Latest, Final: m.lastChapter}
+	finished := m.lastChapter > 0 || m.lastVolume > 0 || m.PubStatus == "Completed"
+
+	// Prefer the chapter number, since chapters aren't always given a volume
+	var available bool
+	if m.lastChapter > 0 {
+		available = m.Summary.Latest >= m.lastChapter
+	} else {
+		available = m.Summary.LatestVolume >= m.lastVolume
+	}
+
+	switch {
+	case m.Summary.Chapters == 0:
+		p.State = StateNoChapters
+	case finished && !available:
+		p.State = StateMissingChapters
+	case finished && p.Unread == 0:
+		p.State = StateFinishedRead
+	case finished:
+		p.State = StateFinished
+	case p.Unread == 0:
+		p.State = StateCaughtUp
+	default:
+		p.State = StateBehind
+	}
+	return p
+}
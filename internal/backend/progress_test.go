@@ -0,0 +1,32 @@
+package backend
+
+import "testing"
+
+func TestProgress(t *testing.T) {
+	tests := []struct {
+		name        string
+		pubStatus   string
+		lastChapter float64
+		lastVolume  int
+		summary     Summary
+		want        SeriesState
+	}{
+		{"nothing fetched", "Ongoing", 0, 0, Summary{}, StateNoChapters},
+		{"caught up", "Ongoing", 0, 0, Summary{Chapters: 10, Latest: 10}, StateCaughtUp},
+		{"behind", "Ongoing", 0, 0, Summary{Chapters: 10, Unread: 2, Latest: 10}, StateBehind},
+		{"final chapter available", "Completed", 50, 0, Summary{Chapters: 50, Unread: 3, Latest: 50}, StateFinished},
+		{"final chapter read", "Completed", 50, 0, Summary{Chapters: 50, Latest: 50}, StateFinishedRead},
+		{"final chapter missing", "Completed", 50, 0, Summary{Chapters: 40, Latest: 40}, StateMissingChapters},
+		{"final volume missing", "Ongoing", 0, 5, Summary{Chapters: 30, Latest: 30, LatestVolume: 4}, StateMissingChapters},
+		{"completed without a last chapter", "Completed", 0, 0, Summary{Chapters: 12, Unread: 1, Latest: 12}, StateFinished},
+		{"completed without a last chapter, read", "Completed", 0, 0, Summary{Chapters: 12, Latest: 12}, StateFinishedRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Manga{PubStatus: tt.pubStatus, lastChapter: tt.lastChapter, lastVolume: tt.lastVolume, Summary: tt.summary}
+			if got := m.Progress().State; got != tt.want {
+				t.Errorf("Progress is %v, want %v", m.Progress(), Progress{State: tt.want})
+			}
+		})
+	}
+}
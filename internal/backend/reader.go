@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Count the pages of a downloaded chapter.
+// Returns 0 if the chapter folder doesn't exist.
+func PageCount(c Chapter, layout Layout) int {
+	return len(PagePaths(c, layout))
+}
+
+// List the paths of the pages of a downloaded chapter, in reading order.
+// Returns nothing if the chapter folder doesn't exist.
+func PagePaths(c Chapter, layout Layout) []string {
+	dir := layout.Abs(c)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	// Page names are zero-padded, so ReadDir's order is reading order
+	paths := make([]string, 0)
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	return paths
+}
+
+// Open the given chapter in the reader, which has to understand imv's options, starting at the last page viewed,
+// and record where the reader was closed along with a ReadEvent for the session.
+// The chapter is downloaded first if it isn't already, and it is only
+// marked read if the final page was reached.
+// Returns the updated Chapter.
+func ReadChapter(ctx context.Context, c Chapter, store Store, opts Options) Chapter {
+	c = DownloadChapters(ctx, store, opts, c)[0]
+
+	start := max(c.LastPage, 1)
+	// imv doesn't report where it was closed, so bind q to print the current
+	// page before quitting. imv splits commands on ';', so the quit has to be
+	// sent from the shell that exec starts.
+	args := append(slices.Clone(opts.Reader[1:]),
+		"-n", strconv.Itoa(start),
+		"-c", `bind q exec echo page $imv_current_index && imv-msg $imv_pid quit`,
+		opts.Layout.Abs(c))
+	readCmd := exec.CommandContext(ctx, opts.Reader[0], args...)
+	var out bytes.Buffer
+	readCmd.Stdout = &out
+	e := ReadEvent{ChapterHash: c.ChapterHash, Start: time.Now()}
+	if err := readCmd.Run(); err != nil {
+		log.Println(err)
+	}
+	e.End = time.Now()
+
+	page, ok := lastPageViewed(out.Bytes())
+	if !ok {
+		// Closed some other way, so we don't know where the reader got to
+		store.insertReadEvent(ctx, e)
+		return c
+	}
+
+	// Paging backwards past the start still counts the starting page
+	e.PagesViewed = max(page-start, 0) + 1
+	return RecordReading(ctx, store, opts, c, e, page)
+}
+
+// Record a reading session that ended on the given page, counting from 1,
+// and mark the chapter read if that was its final page.
+// Returns the updated Chapter.
+func RecordReading(ctx context.Context, store Store, opts Options, c Chapter, e ReadEvent, page int) Chapter {
+	store.insertReadEvent(ctx, e)
+	return store.UpdateChapterProgress(ctx, c, page, IsFinalPage(c, opts.Layout, page))
+}
+
+// Whether page, counting from 1, is the final page of a downloaded chapter.
+// A chapter without any pages on disk has no final page, since it can't have been read.
+func IsFinalPage(c Chapter, layout Layout, page int) bool {
+	pages := PageCount(c, layout)
+	return pages > 0 && page >= pages
+}
+
+// Find the last "page N" line the reader printed.
+func lastPageViewed(out []byte) (int, bool) {
+	page, found := 0, false
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimSpace(sc.Text()), "page %d", &n); err == nil {
+			page, found = n, true
+		}
+	}
+	return page, found
+}
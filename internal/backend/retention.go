@@ -0,0 +1,257 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What a Retention policy keeps
+type RetentionKind int
+
+const (
+	KeepAll  RetentionKind = iota // Never clean up
+	KeepDays                      // Clean up chapters some days after they were read
+	KeepLast                      // Keep only the last few read chapters
+)
+
+// When the pages of read chapters are deleted to save space.
+// Cleaning up a chapter only deletes its pages: it stays read, and can be downloaded again.
+type Retention struct {
+	Kind RetentionKind
+	N    int // The days for KeepDays, or chapters for KeepLast
+}
+
+// The policies the TUI cycles through, after the default
+var RetentionPresets = []string{"all", "days:7", "days:30", "last:1", "last:5"}
+
+// Parse a Retention policy: "all", "days:N" or "last:N".
+func ParseRetention(s string) (Retention, error) {
+	kind, n, found := strings.Cut(s, ":")
+	if !found {
+		if kind == "all" {
+			return Retention{Kind: KeepAll}, nil
+		}
+		return Retention{}, fmt.Errorf(`cleanup policy %q should be "all", "days:N" or "last:N"`, s)
+	}
+
+	num, err := strconv.Atoi(n)
+	if err != nil || num < 0 {
+		return Retention{}, fmt.Errorf("cleanup policy %q needs a number that isn't negative", s)
+	}
+	switch kind {
+	case "days":
+		return Retention{Kind: KeepDays, N: num}, nil
+	case "last":
+		return Retention{Kind: KeepLast, N: num}, nil
+	}
+	return Retention{}, fmt.Errorf(`cleanup policy %q should be "all", "days:N" or "last:N"`, s)
+}
+
+func (r Retention) String() string {
+	switch r.Kind {
+	case KeepDays:
+		return fmt.Sprintf("days:%d", r.N)
+	case KeepLast:
+		return fmt.Sprintf("last:%d", r.N)
+	default:
+		return "all"
+	}
+}
+
+// The policy a Manga uses: its own, or else the default.
+// A policy that can't be parsed, say from an imported library, falls back to the default.
+func (m Manga) RetentionPolicy(def Retention) Retention {
+	if m.Retention == "" {
+		return def
+	}
+	r, err := ParseRetention(m.Retention)
+	if err != nil {
+		log.Printf("%s: Using the default cleanup policy for %s", err, m.SerTitle)
+		return def
+	}
+	return r
+}
+
+// Pick the chapters of a series that its policy says to clean up:
+// downloaded chapters that have been read, and were read long enough ago
+// or are older than the last few read.
+// A chapter was last read at its TimeRead or the end of its last reading
+// session, whichever is later. One with neither, read before either was kept,
+// is never old enough.
+func expiredChapters(chapters []Chapter, history []ReadEvent, r Retention, now time.Time) []Chapter {
+	read := make([]Chapter, 0)
+	for _, c := range chapters {
+		if c.IsRead && c.Downloaded {
+			read = append(read, c)
+		}
+	}
+
+	switch r.Kind {
+	case KeepDays:
+		lastRead := make(map[string]time.Time)
+		for _, e := range history {
+			if e.End.After(lastRead[e.ChapterHash]) {
+				lastRead[e.ChapterHash] = e.End
+			}
+		}
+		cutoff := now.AddDate(0, 0, -r.N)
+		expired := make([]Chapter, 0)
+		for _, c := range read {
+			last := lastRead[c.ChapterHash]
+			if c.TimeRead.After(last) {
+				last = c.TimeRead
+			}
+			if !last.IsZero() && last.Before(cutoff) {
+				expired = append(expired, c)
+			}
+		}
+		return expired
+	case KeepLast:
+		// chapters are in reading order, so the last read are at the end
+		return read[:max(len(read)-r.N, 0)]
+	}
+	return nil
+}
+
+// What Cleanup removed from a series
+type CleanupItem struct {
+	Series   string // The abbreviated title of the series
+	Chapters []Chapter
+	Bytes    int64
+}
+
+// What Cleanup removed, series by series
+type CleanupReport struct {
+	Items  []CleanupItem
+	DryRun bool
+}
+
+// The total space reclaimed
+func (rep CleanupReport) Bytes() int64 {
+	var n int64
+	for _, it := range rep.Items {
+		n += it.Bytes
+	}
+	return n
+}
+
+func (rep CleanupReport) String() string {
+	var sb strings.Builder
+	verb := "Removed"
+	if rep.DryRun {
+		verb = "Would remove"
+	}
+
+	chapters := 0
+	for _, it := range rep.Items {
+		nums := make([]string, 0, len(it.Chapters))
+		for _, c := range it.Chapters {
+			nums = append(nums, c.Number().String())
+		}
+		fmt.Fprintf(&sb, "%s: %s %d chapters (%s), %s\n", it.Series, verb, len(it.Chapters),
+			strings.Join(nums, ", "), FormatBytes(it.Bytes))
+		chapters += len(it.Chapters)
+	}
+	fmt.Fprintf(&sb, "%s %d chapters, %s in total\n", verb, chapters, FormatBytes(rep.Bytes()))
+	return sb.String()
+}
+
+// Delete the pages of read chapters according to each series' cleanup policy,
+// or def for those without one. The chapters are marked not downloaded,
+// but stay read.
+// With dryRun, nothing is changed, only reported.
+func Cleanup(ctx context.Context, store Store, opts Options, def Retention, now time.Time, dryRun bool) CleanupReport {
+	rep := CleanupReport{Items: make([]CleanupItem, 0), DryRun: dryRun}
+	for _, m := range store.GetAll(ctx) {
+		r := m.RetentionPolicy(def)
+		if r.Kind == KeepAll {
+			continue
+		}
+
+		expired := expiredChapters(store.GetChapters(ctx, m.MangaID), store.GetSeriesHistory(ctx, m.MangaID), r, now)
+		if len(expired) == 0 {
+			continue
+		}
+
+		item := CleanupItem{Series: m.SerTitle, Chapters: make([]Chapter, 0)}
+		for _, c := range expired {
+			dir := opts.Layout.Abs(c)
+			if !opts.Layout.Inside(dir) {
+				log.Printf("Not cleaning up %s ch. %s, since %s isn't inside %s", m.SerTitle, c.Number(), dir, opts.Layout.Root)
+				continue
+			}
+			item.Chapters = append(item.Chapters, c)
+			item.Bytes += dirSize(dir)
+			if dryRun {
+				continue
+			}
+
+			if err := os.RemoveAll(dir); err != nil {
+				log.Fatalf("%s: Failed to remove %s", err, dir)
+			}
+			removeEmptyParents(dir, opts.Layout.Root)
+			c.Downloaded = false
+			store.updateChapterState(ctx, c)
+		}
+		if len(item.Chapters) > 0 {
+			rep.Items = append(rep.Items, item)
+		}
+	}
+
+	return rep
+}
+
+// The total size of the files in a folder and its subfolders.
+// A missing folder has size 0.
+func dirSize(dir string) int64 {
+	var n int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			n += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("%s: Failed to measure %s", err, dir)
+	}
+	return n
+}
+
+// Remove the folders above a removed one that are left empty, stopping at root.
+func removeEmptyParents(dir, root string) {
+	for p := filepath.Dir(dir); p != root && strings.HasPrefix(p, root+string(filepath.Separator)); p = filepath.Dir(p) {
+		// Removing a folder that isn't empty fails, which is where to stop
+		if os.Remove(p) != nil {
+			return
+		}
+	}
+}
+
+// Format a number of bytes for people, like 1.5 GiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Retention
+		ok   bool
+	}{
+		{"all", Retention{Kind: KeepAll}, true},
+		{"days:7", Retention{Kind: KeepDays, N: 7}, true},
+		{"days:0", Retention{Kind: KeepDays}, true},
+		{"last:5", Retention{Kind: KeepLast, N: 5}, true},
+		{"", Retention{}, false},
+		{"none", Retention{}, false},
+		{"all:3", Retention{}, false},
+		{"days:", Retention{}, false},
+		{"days:-1", Retention{}, false},
+		{"last:two", Retention{}, false},
+		{"weeks:2", Retention{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseRetention(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseRetention(%q) = %v, %v, want %v, ok %t", tt.in, got, err, tt.want, tt.ok)
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("ParseRetention(%q).String() = %q", tt.in, got)
+		}
+	}
+}
+
+func TestExpiredChapters(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) time.Time { return now.AddDate(0, 0, -n) }
+
+	// Chapters 1-5 are read and downloaded, 6 is read but not downloaded, 7 is unread
+	chapters := testManga(0, 8).Chapters[:7]
+	for i := range chapters {
+		chapters[i].IsRead = i < 6
+		chapters[i].Downloaded = i != 5
+	}
+	chapters[0].TimeRead = daysAgo(40)
+	chapters[1].TimeRead = daysAgo(40) // But read again since
+	chapters[2].TimeRead = daysAgo(3)
+	// Chapter 4 was only read in a session, and 5 has no record of when it was read
+	chapters[5].TimeRead = daysAgo(40)
+	history := []ReadEvent{
+		{ChapterHash: chapters[1].ChapterHash, Start: daysAgo(2), End: daysAgo(2)},
+		{ChapterHash: chapters[3].ChapterHash, Start: daysAgo(41), End: daysAgo(41)},
+		{ChapterHash: chapters[3].ChapterHash, Start: daysAgo(50), End: daysAgo(50)},
+	}
+
+	tests := []struct {
+		r    string
+		want []int
+	}{
+		{"all", nil},
+		{"days:30", []int{1, 4}},
+		{"days:1", []int{1, 2, 3, 4}},
+		{"days:60", nil},
+		{"last:0", []int{1, 2, 3, 4, 5}},
+		{"last:2", []int{1, 2, 3}},
+		{"last:10", nil},
+	}
+	for _, tt := range tests {
+		r, err := ParseRetention(tt.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]int, 0)
+		for _, c := range expiredChapters(chapters, history, r, now) {
+			got = append(got, int(c.ChapterNum))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s expired chapters %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+// Cleanup only deletes folders inside the root, whatever the stored chapter paths say
+func TestCleanupInsideRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	layout, err := NewLayout(filepath.Join(dir, "library"), DefaultChapterTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemory()
+	m := testManga(0, 4)
+	m.Chapters[0].ChapterPath = "series0/01/001.0"
+	m.Chapters[1].ChapterPath = "../outside"
+	for _, c := range m.Chapters[:2] {
+		if err := os.MkdirAll(layout.Abs(c), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(layout.Abs(c), "1.jpg"), []byte("page"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.insertManga(ctx, m)
+
+	rep := Cleanup(ctx, store, Options{Layout: layout}, Retention{Kind: KeepLast}, time.Now(), false)
+	if len(rep.Items) != 1 || len(rep.Items[0].Chapters) != 1 || rep.Items[0].Chapters[0].ChapterNum != 1 {
+		t.Fatalf("Cleanup removed %+v, want only chapter 1", rep.Items)
+	}
+	if _, err := os.Stat(layout.Abs(m.Chapters[0])); !os.IsNotExist(err) {
+		t.Error("Chapter 1 wasn't removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside", "1.jpg")); err != nil {
+		t.Errorf("Folder outside the root was touched: %s", err)
+	}
+	if got := store.GetChapters(ctx, m.MangaID); got[0].Downloaded || !got[1].Downloaded {
+		t.Errorf("Chapters downloaded %t and %t after cleanup, want false and true", got[0].Downloaded, got[1].Downloaded)
+	}
+}
+
+func TestLayoutInside(t *testing.T) {
+	l := Layout{Root: "/lib"}
+	for path, want := range map[string]bool{
+		"/lib/a/1":    true,
+		"/lib/..a":    true,
+		"/lib":        false,
+		"/lib/..":     false,
+		"/lib/../b":   false,
+		"/library/a":  false,
+		"/":           false,
+		"/lib/a/../b": true,
+	} {
+		if got := l.Inside(path); got != want {
+			t.Errorf("Inside(%q) = %t, want %t", path, got, want)
+		}
+	}
+}
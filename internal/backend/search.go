@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The full-text index over everything worth searching a Manga by,
+// kept up to date by triggers on the tables the text comes from.
+// Only created when built with FTS5 support.
+const searchSchema = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS MangaSearch USING fts5(
+		MangaID UNINDEXED,
+		FullTitle,
+		SerTitle,
+		AltTitles,
+		Descr,
+		Rev
+	);
+
+	CREATE TRIGGER IF NOT EXISTS MangaSearch_ai AFTER INSERT ON Manga BEGIN
+		INSERT INTO MangaSearch (MangaID, FullTitle, SerTitle, AltTitles, Descr, Rev)
+		VALUES (new.MangaID, new.FullTitle, new.SerTitle, '', new.Descr, '');
+	END;
+	CREATE TRIGGER IF NOT EXISTS MangaSearch_au AFTER UPDATE OF FullTitle, SerTitle, Descr ON Manga BEGIN
+		UPDATE MangaSearch
+		SET FullTitle = new.FullTitle, SerTitle = new.SerTitle, Descr = new.Descr
+		WHERE MangaID = old.MangaID;
+	END;
+	CREATE TRIGGER IF NOT EXISTS MangaSearch_ad AFTER DELETE ON Manga BEGIN
+		DELETE FROM MangaSearch WHERE MangaID = old.MangaID;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS MangaSearchAlt_ai AFTER INSERT ON AltTitle BEGIN
+		UPDATE MangaSearch
+		SET AltTitles = (SELECT group_concat(AltTitle, ' ') FROM AltTitle WHERE MangaID = new.MangaID)
+		WHERE MangaID = new.MangaID;
+	END;
+	CREATE TRIGGER IF NOT EXISTS MangaSearchAlt_ad AFTER DELETE ON AltTitle BEGIN
+		UPDATE MangaSearch
+		SET AltTitles = coalesce((SELECT group_concat(AltTitle, ' ') FROM AltTitle WHERE MangaID = old.MangaID), '')
+		WHERE MangaID = old.MangaID;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS MangaSearchRev_ai AFTER INSERT ON Review BEGIN
+		UPDATE MangaSearch SET Rev = new.Rev WHERE MangaID = new.MangaID;
+	END;
+	CREATE TRIGGER IF NOT EXISTS MangaSearchRev_au AFTER UPDATE OF Rev ON Review BEGIN
+		UPDATE MangaSearch SET Rev = new.Rev WHERE MangaID = new.MangaID;
+	END;
+	CREATE TRIGGER IF NOT EXISTS MangaSearchRev_ad AFTER DELETE ON Review BEGIN
+		UPDATE MangaSearch SET Rev = '' WHERE MangaID = old.MangaID;
+	END;
+
+	INSERT INTO MangaSearch (MangaID, FullTitle, SerTitle, AltTitles, Descr, Rev)
+	SELECT MangaID, FullTitle, SerTitle,
+		coalesce((SELECT group_concat(AltTitle, ' ') FROM AltTitle WHERE AltTitle.MangaID = Manga.MangaID), ''),
+		coalesce(Descr, ''),
+		coalesce((SELECT Rev FROM Review WHERE Review.MangaID = Manga.MangaID), '')
+	FROM Manga
+	WHERE MangaID NOT IN (SELECT MangaID FROM MangaSearch);`
+
+// The triggers in searchSchema, which fail without FTS5
+var searchTriggers = []string{
+	"MangaSearch_ai", "MangaSearch_au", "MangaSearch_ad",
+	"MangaSearchAlt_ai", "MangaSearchAlt_ad",
+	"MangaSearchRev_ai", "MangaSearchRev_au", "MangaSearchRev_ad",
+}
+
+// Create the full-text index, if this build supports it,
+// and fill in any Manga that aren't indexed yet.
+//
+// A database can be opened by builds with and without FTS5 in turn.
+// Without FTS5, the index's triggers would fail every change to the tables
+// they watch, so they are dropped; the index itself can't be dropped without
+// FTS5 either, so it is left, and rebuilt by the next build that has FTS5.
+func (r *SQLite) initSearch(ctx context.Context) {
+	if !haveFTS5 {
+		for _, name := range searchTriggers {
+			if _, err := r.conn().ExecContext(ctx, "DROP TRIGGER IF EXISTS "+name); err != nil {
+				fatalf(ctx, err, "Failed to drop search trigger %s", name)
+			}
+		}
+		return
+	}
+
+	var stale bool
+	err := r.conn().QueryRowContext(ctx, `SELECT
+		EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'MangaSearch')
+		AND NOT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'MangaSearch_ai')`).Scan(&stale)
+	if err != nil {
+		fatalf(ctx, err, "Failed to check search index")
+	}
+	if stale {
+		if _, err := r.conn().ExecContext(ctx, "DELETE FROM MangaSearch"); err != nil {
+			fatalf(ctx, err, "Failed to clear stale search index")
+		}
+	}
+	if _, err := r.conn().ExecContext(ctx, searchSchema); err != nil {
+		fatalf(ctx, err, "Failed to initialize search index")
+	}
+}
+
+// Search the titles, alternate titles, descriptions and reviews of every Manga,
+// returning the matches with the most relevant first.
+func (r *SQLite) Search(ctx context.Context, text string) []Manga {
+	if !haveFTS5 {
+		return r.searchLike(ctx, text)
+	}
+
+	query := ftsQuery(text)
+	if query == "" {
+		return []Manga{}
+	}
+
+	// Matches in the titles count for far more than in the longer text
+	return r.queryManga(ctx, `
+	JOIN (
+		SELECT MangaID, bm25(MangaSearch, 0, 10, 10, 5, 1, 1) AS Score
+		FROM MangaSearch
+		WHERE MangaSearch MATCH ?
+	) USING (MangaID)
+	ORDER BY Score`, query)
+}
+
+// Turn what was typed in the search prompt into an FTS5 query that
+// matches every word, treating each as a prefix.
+// Quoting each word stops punctuation being read as query syntax.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = fmt.Sprintf(`"%s"*`, strings.ReplaceAll(w, `"`, `""`))
+	}
+	return strings.Join(words, " ")
+}
+
+// Search without the full-text index, by requiring every word to appear
+// somewhere and ranking by which field it was found in.
+func (r *SQLite) searchLike(ctx context.Context, text string) []Manga {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []Manga{}
+	}
+
+	var where, score []string
+	var args []any
+	for i, w := range words {
+		// Each word is used several times, so bind it to its own numbered parameter
+		param := fmt.Sprintf("?%d", i+1)
+		where = append(where, strings.ReplaceAll(`(FullTitle LIKE ?N ESCAPE '\' OR SerTitle LIKE ?N ESCAPE '\'
+			OR Descr LIKE ?N ESCAPE '\' OR Rev LIKE ?N ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM AltTitle a WHERE a.MangaID = Manga.MangaID AND a.AltTitle LIKE ?N ESCAPE '\'))`, "?N", param))
+		score = append(score, strings.ReplaceAll(`(CASE
+			WHEN FullTitle LIKE ?N ESCAPE '\' OR SerTitle LIKE ?N ESCAPE '\' THEN 10
+			WHEN EXISTS (SELECT 1 FROM AltTitle a WHERE a.MangaID = Manga.MangaID AND a.AltTitle LIKE ?N ESCAPE '\') THEN 5
+			ELSE 1 END)`, "?N", param))
+		args = append(args, "%"+likeEscaper.Replace(w)+"%")
+	}
+
+	return r.queryManga(ctx, fmt.Sprintf("WHERE %s ORDER BY %s DESC",
+		strings.Join(where, " AND "), strings.Join(score, " + ")), args...)
+}
+
+// Escape the LIKE wildcards so user input only matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
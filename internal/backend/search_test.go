@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// A database can be used by builds with and without FTS5 in turn.
+// Each build is tested against a database left by the other, as far as
+// this build can make one.
+func TestSearchOtherBuild(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "manga.sqlite3")
+	r := Opendb(ctx, path)
+	r.insertManga(ctx, testManga(1, 0))
+
+	if haveFTS5 {
+		// A build without FTS5 drops the triggers, then changes what they'd have indexed
+		for _, name := range searchTriggers {
+			if _, err := r.db.ExecContext(ctx, "DROP TRIGGER "+name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.insertReview(ctx, Review{MangaID: "manga-00001", Rating: 80, Rev: "Dragons everywhere"})
+	} else {
+		// A build with FTS5 leaves triggers that write to an index this build can't use
+		schema := `
+		CREATE TABLE MangaSearch (MangaID, FullTitle, SerTitle, AltTitles, Descr, Rev);
+		CREATE TRIGGER MangaSearch_ai AFTER INSERT ON Manga BEGIN
+			SELECT RAISE(ABORT, 'no such module: fts5');
+		END;
+		CREATE TRIGGER MangaSearchRev_ai AFTER INSERT ON Review BEGIN
+			SELECT RAISE(ABORT, 'no such module: fts5');
+		END;`
+		if _, err := r.db.ExecContext(ctx, schema); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.db.Close()
+
+	// Any failure to write is fatal, so getting through this is most of the test
+	r = Opendb(ctx, path)
+	t.Cleanup(func() { r.db.Close() })
+	r.insertManga(ctx, testManga(2, 0))
+	if !haveFTS5 {
+		r.insertReview(ctx, Review{MangaID: "manga-00001", Rating: 80, Rev: "Dragons everywhere"})
+	}
+
+	for q, want := range map[string]string{"dragons": "manga-00001", "Alt series 2": "manga-00002"} {
+		if found := r.Search(ctx, q); len(found) != 1 || found[0].MangaID != want {
+			t.Errorf("Searching %q found %d series, want only %s", q, len(found), want)
+		}
+	}
+}
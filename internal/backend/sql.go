@@ -2,9 +2,14 @@ package backend
 
 import (
 	"cmp"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -29,23 +34,41 @@ func (t Tag) String() string {
 	return t.TagTitle
 }
 
+// A Tag and how many Manga have it
+type TagCount struct {
+	Tag
+	Series int
+}
+
 // Hold a single chapter of a manga.
 type Chapter struct {
-	ChapterHash string
-	ChapterNum  float64
-	ChapterName string
-	VolumeNum   int
-	MangaID     string
-	Downloaded  bool
-	IsRead      bool
-	ChapterPath string
+	ChapterHash   string
+	ChapterNum    float64 // The number from ChapterRaw, or 0 for anything but a numbered chapter
+	ChapterRaw    string  // The chapter number as given by MangaDex, see ParseChapterNumber
+	SortKey       string  // The Key of the parsed ChapterRaw
+	ChapterName   string
+	VolumeNum     int
+	MangaID       string
+	Downloaded    bool
+	IsRead        bool
+	ChapterPath   string    // Relative to the Layout root
+	LastPage      int       // The last page viewed, starting at 1. 0 if never opened.
+	Language      string    // The language code of the translation, like en
+	GroupName     string    // The scanlation group, empty if unknown
+	PageCount     int       // The pages on disk as of the last download, or 0 if unknown
+	ByteCount     int64     // The size of those pages
+	TimeRead      time.Time // When it was last marked read, zero if unknown
+	TimePublished time.Time // When MangaDex published it, zero if unknown
 }
 
+// The parsed chapter number.
+func (c Chapter) Number() ChapterNumber { return ParseChapterNumber(c.ChapterRaw) }
+
 // Implement list.DefaultItem
-func (c Chapter) FilterValue() string { return fmt.Sprintf("%.1f %s", c.ChapterNum, c.ChapterName) }
+func (c Chapter) FilterValue() string { return fmt.Sprintf("%s %s", c.Number(), c.ChapterName) }
 
 // Implement list.Item
-func (c Chapter) Title() string { return fmt.Sprintf("%.1f: %s", c.ChapterNum, c.ChapterName) }
+func (c Chapter) Title() string { return fmt.Sprintf("%s: %s", c.Number(), c.ChapterName) }
 func (c Chapter) Description() string {
 	var dl, r string
 	if c.Downloaded {
@@ -58,23 +81,59 @@ func (c Chapter) Description() string {
 	} else {
 		r = "Read: X"
 	}
+	if c.LastPage > 0 && !c.IsRead {
+		r = fmt.Sprintf("Read: p. %d", c.LastPage)
+	}
 	return dl + "\t" + r
 }
 
 // Function to use with slice.SortFunc.
 // Returns a negative number when a < b, a positive number
 // when a > b, and 0 when a == b.
+//
+// Chapters are ordered by their number, since plenty of them have no volume.
+// The volume and then the hash only break ties, so the order is always the same.
 func chapterCmp(a, b Chapter) int {
-	if volDiff := cmp.Compare(a.VolumeNum, b.VolumeNum); (a.VolumeNum != 0 && b.VolumeNum != 0) && volDiff != 0 {
-		return volDiff
-	}
-	return cmp.Compare(a.ChapterNum, b.ChapterNum)
+	return cmp.Or(
+		strings.Compare(a.SortKey, b.SortKey),
+		cmp.Compare(a.VolumeNum, b.VolumeNum),
+		strings.Compare(a.ChapterHash, b.ChapterHash))
+}
+
+// A single reading session of a Chapter.
+type ReadEvent struct {
+	EventID     int
+	ChapterHash string
+	Start       time.Time
+	End         time.Time
+	PagesViewed int
+
+	// Filled in from the Chapter and Manga being read, for display
+	MangaID     string
+	FullTitle   string
+	ChapterRaw  string
+	ChapterName string
+}
+
+// Implement list.DefaultItem
+func (e ReadEvent) FilterValue() string { return fmt.Sprintf("%s %s", e.FullTitle, e.ChapterName) }
+
+// Implement list.Item
+func (e ReadEvent) Title() string {
+	return fmt.Sprintf("%s - %s: %s", e.FullTitle, ParseChapterNumber(e.ChapterRaw), e.ChapterName)
+}
+func (e ReadEvent) Description() string {
+	return fmt.Sprintf("%s\t%s\t%d pages",
+		e.Start.Local().Format("2006-01-02 15:04"),
+		e.End.Sub(e.Start).Round(time.Second),
+		e.PagesViewed)
 }
 
 type Manga struct {
 	MangaID      string
 	SerTitle     string
 	FullTitle    string
+	AltTitles    []string
 	Descr        string
 	TimeModified time.Time
 	Tags         []Tag
@@ -83,19 +142,105 @@ type Manga struct {
 	lastChapter  float64
 	Demographic  string
 	PubStatus    string
+	Author       string // The authors on MangaDex, comma-separated. Empty if unknown.
+	ReadStatus   string // Our own relationship to the series, one of ReadStatuses
+	Retention    string // When to clean up read chapters, see ParseRetention. Empty to use the default.
+	Processing   string // How to process downloaded pages, see ParseProcessing. Empty to use the default.
 	Review       Review
+	Summary      Summary
+}
+
+// Totals over the chapters of a Manga, so that the library can show them
+// without loading every chapter.
+type Summary struct {
+	Chapters     int
+	Unread       int
+	Downloaded   int
+	Latest       float64 // The highest chapter number
+	LatestVolume int     // The highest volume number
+	Pages        int     // The pages of the downloaded chapters
+	Bytes        int64   // The size of the downloaded chapters
+}
+
+// The personal reading statuses a Manga can have.
+// A Manga with an empty ReadStatus has never had one set.
+const (
+	StatusReading    = "Reading"
+	StatusOnHold     = "On hold"
+	StatusDropped    = "Dropped"
+	StatusPlanToRead = "Plan to read"
+	StatusCompleted  = "Completed"
+)
+
+var ReadStatuses = []string{StatusReading, StatusOnHold, StatusDropped, StatusPlanToRead, StatusCompleted}
+
+// A change to the ReadStatus of a Manga.
+type StatusChange struct {
+	MangaID     string
+	ReadStatus  string
+	TimeChanged time.Time
 }
 
 // Implement list.DefaultItem
-func (m Manga) FilterValue() string { return fmt.Sprintf("%s %s %v", m.FullTitle, m.SerTitle, m.Tags) }
+func (m Manga) FilterValue() string {
+	return fmt.Sprintf("%s %s %v %s", m.FullTitle, m.SerTitle, m.Tags, m.ReadStatus)
+}
 
 // Implement list.Item
-func (m Manga) Title() string       { return fmt.Sprintf("%s (%s)", m.FullTitle, m.SerTitle) }
-func (m Manga) Description() string { return m.Descr }
+func (m Manga) Title() string {
+	if m.ReadStatus == "" {
+		return fmt.Sprintf("%s (%s)", m.FullTitle, m.SerTitle)
+	}
+	return fmt.Sprintf("[%s] %s (%s)", m.ReadStatus, m.FullTitle, m.SerTitle)
+}
+func (m Manga) Description() string { return m.Progress().String() + " | " + m.Descr }
+
+// Suggest a new ReadStatus based on the Summary and publication status:
+// a finished series with every chapter read should be Completed.
+// Returns false if there's nothing to suggest.
+func (m Manga) SuggestedStatus() (string, bool) {
+	if m.ReadStatus == StatusCompleted || m.PubStatus != "Completed" || m.Summary.Chapters == 0 || m.Summary.Unread > 0 {
+		return "", false
+	}
+	return StatusCompleted, true
+}
+
+// Find the chapter to continue reading from: the first unread chapter
+// in reading order. Returns false if every chapter has been read.
+func (m Manga) NextUnread() (Chapter, bool) {
+	for _, c := range m.Chapters {
+		if !c.IsRead {
+			return c, true
+		}
+	}
+	return Chapter{}, false
+}
+
+// The columns of the Chapter table, in the order they are scanned by scanChapter.
+const chapterCols = "ChapterHash, ChapterNum, ChapterRaw, ChapterKey, ChapterName, VolumeNum, MangaID, Downloaded, IsRead, ChapterPath, LastPage, Language, GroupName, PageCount, ByteCount, TimeRead, TimePublished"
+
+// Scan a single row selected with chapterCols.
+func scanChapter(row interface{ Scan(...any) error }) (Chapter, error) {
+	var c Chapter
+	var read, published sql.NullTime
+	err := row.Scan(&c.ChapterHash, &c.ChapterNum, &c.ChapterRaw, &c.SortKey, &c.ChapterName, &c.VolumeNum, &c.MangaID, &c.Downloaded, &c.IsRead, &c.ChapterPath, &c.LastPage, &c.Language, &c.GroupName, &c.PageCount, &c.ByteCount, &read, &published)
+	c.TimeRead, c.TimePublished = read.Time, published.Time
+	return c, err
+}
+
+// The columns of the Manga table, in the order they are scanned by queryManga.
+const mangaCols = "MangaID, SerTitle, FullTitle, Descr, TimeModified, LastVolume, LastChapter, Demographic, PubStatus, Author, ReadStatus, Retention, Processing"
+
+// A named, user-defined group of Manga, like a shelf.
+type Collection struct {
+	CollectionID int
+	Name         string
+}
 
 // Store the SQL connection.
 type SQLite struct {
 	db *sql.DB
+	tx *sql.Tx // Set on the SQLite given to a transaction's function
 }
 
 // Return a new SQLite connection
@@ -103,100 +248,164 @@ func newDb(db *sql.DB) *SQLite {
 	return &SQLite{db: db}
 }
 
+// What queries run against: the DB, or the transaction in progress
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+func (r *SQLite) conn() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// A group of writes made by one method, committed together
+type txn struct {
+	querier
+	commit func() error
+}
+
+func (t txn) Commit() error { return t.commit() }
+
+// Begin a group of writes. Within a transaction, the writes join it
+// and are only committed along with the rest of it.
+func (r *SQLite) begin(ctx context.Context) (txn, error) {
+	if r.tx != nil {
+		return txn{r.tx, func() error { return nil }}, nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return txn{}, err
+	}
+	return txn{tx, tx.Commit}, nil
+}
+
+// Run f with a Store whose changes are all committed at once when it returns.
+// Since a failure is fatal and exits before the commit, none of them are kept then.
+func (r *SQLite) transaction(ctx context.Context, f func(store Store)) {
+	if r.tx != nil {
+		f(r)
+		return
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		fatalf(ctx, err, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	f(&SQLite{db: r.db, tx: tx})
+	if err := tx.Commit(); err != nil {
+		fatalf(ctx, err, "Failed to commit transaction")
+	}
+}
+
 // ------- CREATE FUNCTIONS -------
 
 // Given a slice of tag names, add the tags to the DB if they don't already exist.
 // Because of the database structure, to associate a Tag with a Manga, use linkTags
-func (r *SQLite) insertTags(names []string) {
-	tx, err := r.db.Begin()
+func (r *SQLite) insertTags(ctx context.Context, names []string) {
+	tx, err := r.begin(ctx)
 	if err != nil {
-		log.Fatalf("%s: Failed to begin transaction", err)
+		fatalf(ctx, err, "Failed to begin transaction")
 	}
 
-	stmt, err := tx.Prepare("INSERT OR IGNORE INTO Tag (TagTitle) values (?)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO Tag (TagTitle) values (?)")
 	if err != nil {
-		log.Fatalf("%s: Failed to prepare transaction", err)
+		fatalf(ctx, err, "Failed to prepare transaction")
 	}
 	defer stmt.Close()
 
 	for _, name := range names {
-		_, err = stmt.Exec(name)
+		_, err = stmt.ExecContext(ctx, name)
 		if err != nil {
-			log.Fatalf("%s: Failed to execute transaction on %s", err, name)
+			fatalf(ctx, err, "Failed to execute transaction on %s", name)
 		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Fatalf("%s: Failed to commit transaction", err)
+		fatalf(ctx, err, "Failed to commit transaction")
 	}
 }
 
 // Link the specified Tags with a Manga.
 // Both the Tags and the Manga referenced must already be in the DB.
-func (r *SQLite) linkTags(MangaID string, tags []Tag) {
-	tx, err := r.db.Begin()
+func (r *SQLite) linkTags(ctx context.Context, MangaID string, tags []Tag) {
+	tx, err := r.begin(ctx)
 	if err != nil {
-		log.Fatalf("%s: Failed to begin transaction", err)
+		fatalf(ctx, err, "Failed to begin transaction")
 	}
-	stmt, err := tx.Prepare("INSERT INTO ItemTag values (?, ?)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO ItemTag values (?, ?)")
 	if err != nil {
-		log.Fatalf("%s: Failed to prepare transaction", err)
+		fatalf(ctx, err, "Failed to prepare transaction")
 	}
 	defer stmt.Close()
 
 	for _, t := range tags {
-		_, err = stmt.Exec(MangaID, t.TagID)
+		_, err = stmt.ExecContext(ctx, MangaID, t.TagID)
 		if err != nil {
-			log.Fatalf("%s: Failed to execute transaction on %v", err, t)
+			fatalf(ctx, err, "Failed to execute transaction on %v", t)
 		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Fatalf("%s: Failed to commit transaction", err)
+		fatalf(ctx, err, "Failed to commit transaction")
 	}
 }
 
 // Insert the given chapters to the DB.
-func (r *SQLite) insertChapters(chapters []Chapter) {
-	tx, err := r.db.Begin()
+func (r *SQLite) insertChapters(ctx context.Context, chapters []Chapter) {
+	tx, err := r.begin(ctx)
 	if err != nil {
-		log.Fatalf("%s: Failed to begin chapter add transaction", err)
+		fatalf(ctx, err, "Failed to begin chapter add transaction")
 	}
 	// Sometimes the API return duplicates
 	// Don't know why it does, but just ignore them
-	stmt, err := tx.Prepare("INSERT OR IGNORE INTO Chapter values (?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO Chapter ("+chapterCols+") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
-		log.Fatalf("%s: Failed to prepare transaction", err)
+		fatalf(ctx, err, "Failed to prepare transaction")
 	}
 	defer stmt.Close()
 
 	for _, c := range chapters {
-		_, err = stmt.Exec(
+		_, err = stmt.ExecContext(ctx,
 			c.ChapterHash,
 			c.ChapterNum,
+			c.ChapterRaw,
+			c.SortKey,
 			c.ChapterName,
 			c.VolumeNum,
 			c.MangaID,
 			c.Downloaded,
 			c.IsRead,
-			c.ChapterPath)
+			c.ChapterPath,
+			c.LastPage,
+			c.Language,
+			c.GroupName,
+			c.PageCount,
+			c.ByteCount,
+			sql.NullTime{Time: c.TimeRead, Valid: !c.TimeRead.IsZero()},
+			sql.NullTime{Time: c.TimePublished, Valid: !c.TimePublished.IsZero()})
 		if err != nil {
-			log.Fatalf("%s: Failed to execute transaction on %v", err, c)
+			fatalf(ctx, err, "Failed to execute transaction on %v", c)
 		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Fatalf("%s: Failed to commit chapter add transaction", err)
+		fatalf(ctx, err, "Failed to commit chapter add transaction")
 	}
 }
 
 // Insert the given Manga into the DB
-func (r *SQLite) insertManga(m Manga) {
-	insertStmt := `INSERT INTO Manga values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err := r.db.Exec(insertStmt,
+func (r *SQLite) insertManga(ctx context.Context, m Manga) {
+	insertStmt := "INSERT INTO Manga (" + mangaCols + ") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := r.conn().ExecContext(ctx, insertStmt,
 		m.MangaID,
 		m.SerTitle,
 		m.FullTitle,
@@ -205,23 +414,82 @@ func (r *SQLite) insertManga(m Manga) {
 		m.lastVolume,
 		m.lastChapter,
 		m.Demographic,
-		m.PubStatus)
+		m.PubStatus,
+		m.Author,
+		m.ReadStatus,
+		m.Retention,
+		m.Processing)
 	if err != nil {
-		log.Fatalf("%s: Failed to insert %v", err, m)
+		fatalf(ctx, err, "Failed to insert %v", m)
 	}
 
-	r.insertChapters(m.Chapters)
-	r.linkTags(m.MangaID, m.Tags)
+	r.insertChapters(ctx, m.Chapters)
+	r.linkTags(ctx, m.MangaID, m.Tags)
+	r.insertAltTitles(ctx, m.MangaID, m.AltTitles)
 
 	//log.Printf("Successfully inserted %v", m)
 }
 
+// Insert the alternate titles of a Manga.
+func (r *SQLite) insertAltTitles(ctx context.Context, mangaID string, titles []string) {
+	tx, err := r.begin(ctx)
+	if err != nil {
+		fatalf(ctx, err, "Failed to begin transaction")
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO AltTitle VALUES (?, ?)")
+	if err != nil {
+		fatalf(ctx, err, "Failed to prepare transaction")
+	}
+	defer stmt.Close()
+
+	for _, t := range titles {
+		if _, err := stmt.ExecContext(ctx, mangaID, t); err != nil {
+			fatalf(ctx, err, "Failed to execute transaction on %s", t)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		fatalf(ctx, err, "Failed to commit transaction")
+	}
+}
+
+// Add a Manga to the named Collection, creating the Collection if it doesn't exist.
+// Returns the Collection.
+func (r *SQLite) AddToCollection(ctx context.Context, name string, mangaID string) Collection {
+	_, err := r.conn().ExecContext(ctx, "INSERT OR IGNORE INTO Collection (CollectionName) VALUES (?)", name)
+	if err != nil {
+		fatalf(ctx, err, "Failed to create collection %s", name)
+	}
+
+	c := Collection{Name: name}
+	row := r.conn().QueryRowContext(ctx, "SELECT CollectionID FROM Collection WHERE CollectionName = ?", name)
+	if err := row.Scan(&c.CollectionID); err != nil {
+		fatalf(ctx, err, "Failed to query db for collection %s", name)
+	}
+
+	_, err = r.conn().ExecContext(ctx, "INSERT OR IGNORE INTO CollectionItem VALUES (?, ?)", c.CollectionID, mangaID)
+	if err != nil {
+		fatalf(ctx, err, "Failed to add %s to collection %s", mangaID, name)
+	}
+
+	return c
+}
+
 // Insert a new review into the DB
-func (r *SQLite) insertReview(rev Review) {
+func (r *SQLite) insertReview(ctx context.Context, rev Review) {
 	insertStmt := "INSERT INTO Review VALUES (?, ?, ?)"
-	_, err := r.db.Exec(insertStmt, rev.MangaID, rev.Rating, rev.Rev)
+	_, err := r.conn().ExecContext(ctx, insertStmt, rev.MangaID, rev.Rating, rev.Rev)
+	if err != nil {
+		fatalf(ctx, err, "Failed to insert %v", rev)
+	}
+}
+
+// Record a reading session.
+func (r *SQLite) insertReadEvent(ctx context.Context, e ReadEvent) {
+	insertStmt := "INSERT INTO ReadEvent (ChapterHash, StartTime, EndTime, PagesViewed) VALUES (?, ?, ?, ?)"
+	_, err := r.conn().ExecContext(ctx, insertStmt, e.ChapterHash, e.Start, e.End, e.PagesViewed)
 	if err != nil {
-		log.Fatalf("%s: Failed to insert %v", err, rev)
+		fatalf(ctx, err, "Failed to insert %v", e)
 	}
 }
 
@@ -229,16 +497,16 @@ func (r *SQLite) insertReview(rev Review) {
 
 // Given a slice of tag names, retrieve the ID and return a slice of Tag structs.
 // Intended solely for use in parseTags.
-func (r *SQLite) tagNamesToTags(names []string) []Tag {
+func (r *SQLite) tagNamesToTags(ctx context.Context, names []string) []Tag {
 	tags := make([]Tag, 0)
-	stmt, _ := r.db.Prepare("SELECT TagID, TagTitle FROM Tag WHERE TagTitle = ?")
+	stmt, _ := r.conn().PrepareContext(ctx, "SELECT TagID, TagTitle FROM Tag WHERE TagTitle = ?")
 
 	for _, v := range names {
-		row := stmt.QueryRow(v)
-		//row := r.db.QueryRow("SELECT TagID, TagTitle FROM Tag WHERE TagTitle = ?", v)
+		row := stmt.QueryRowContext(ctx, v)
+		//row := r.conn().QueryRowContext(ctx, "SELECT TagID, TagTitle FROM Tag WHERE TagTitle = ?", v)
 		var t Tag
 		if err := row.Scan(&t.TagID, &t.TagTitle); err != nil {
-			log.Fatalf("%s: Failed to get tags", err)
+			fatalf(ctx, err, "Failed to get tags")
 		}
 		tags = append(tags, t)
 	}
@@ -246,52 +514,51 @@ func (r *SQLite) tagNamesToTags(names []string) []Tag {
 	return tags
 }
 
-// Get all the tags for a given manga.
-// This is only indended for use in GetByID and GetAll.
-func (r *SQLite) getTags(MangaID string) []Tag {
-	query := `
-	SELECT TagID, TagTitle
-	FROM Tag
-	JOIN ItemTag USING (TagID)
-	JOIN Manga USING (MangaID)
-	WHERE Manga.MangaID = ?`
-	rows, err := r.db.Query(query, MangaID)
-	if err != nil {
-		log.Fatalf("%s: Failed to query get tags", err)
-	}
-	defer rows.Close()
+// Check whether a Manga is in the DB.
+func (r *SQLite) mangaExists(ctx context.Context, mangaID string) bool {
+	return r.exists(ctx, "SELECT 1 FROM Manga WHERE MangaID = ?", mangaID)
+}
 
-	all := make([]Tag, 0)
-	for rows.Next() {
-		var t Tag
-		err := rows.Scan(&t.TagID, &t.TagTitle)
-		if err != nil {
-			log.Fatalf("%s: Failed to parse tags", err)
-		}
-		all = append(all, t)
+// Get the MangaID of the Manga in the DB with the abbreviated title, if there is one.
+func (r *SQLite) serTitleID(ctx context.Context, abbrev string) (string, bool) {
+	var mangaID string
+	err := r.conn().QueryRowContext(ctx, "SELECT MangaID FROM Manga WHERE SerTitle = ?", abbrev).Scan(&mangaID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false
+	} else if err != nil {
+		fatalf(ctx, err, "Failed to query db for %s", abbrev)
 	}
+	return mangaID, true
+}
 
-	return all
+// Check whether a query returns any rows.
+func (r *SQLite) exists(ctx context.Context, query string, args ...any) bool {
+	var found bool
+	err := r.conn().QueryRowContext(ctx, "SELECT EXISTS ("+query+")", args...).Scan(&found)
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db with %s", query)
+	}
+	return found
 }
 
-// Get all the chapters for a given manga.
-func (r *SQLite) GetChapters(MangaID string) []Chapter {
+// Get all the chapters for a given manga, in reading order.
+func (r *SQLite) GetChapters(ctx context.Context, MangaID string) []Chapter {
 	query := `
-	SELECT *
+	SELECT ` + chapterCols + `
 	FROM Chapter
-	WHERE MangaID = ?`
-	rows, err := r.db.Query(query, MangaID)
+	WHERE MangaID = ?
+	ORDER BY ChapterKey, VolumeNum, ChapterHash`
+	rows, err := r.conn().QueryContext(ctx, query, MangaID)
 	if err != nil {
-		log.Fatalf("%s: Failed to query db for chapters", err)
+		fatalf(ctx, err, "Failed to query db for chapters")
 	}
 	defer rows.Close()
 
 	all := make([]Chapter, 0)
 	for rows.Next() {
-		var c Chapter
-		err := rows.Scan(&c.ChapterHash, &c.ChapterNum, &c.ChapterName, &c.VolumeNum, &c.MangaID, &c.Downloaded, &c.IsRead, &c.ChapterPath)
+		c, err := scanChapter(rows)
 		if err != nil {
-			log.Fatalf("%s: Failed to parse chapter", err)
+			fatalf(ctx, err, "Failed to parse chapter")
 		}
 		all = append(all, c)
 	}
@@ -300,48 +567,77 @@ func (r *SQLite) GetChapters(MangaID string) []Chapter {
 }
 
 // Get the review for a given manga
-func (r *SQLite) GetReview(MangaID string) Review {
-	row := r.db.QueryRow("SELECT * FROM Review WHERE MangaID = ?", MangaID)
+func (r *SQLite) GetReview(ctx context.Context, MangaID string) Review {
+	row := r.conn().QueryRowContext(ctx, "SELECT * FROM Review WHERE MangaID = ?", MangaID)
 
 	var rev Review
 	err := row.Scan(&rev.MangaID, &rev.Rating, &rev.Rev)
 	if err == sql.ErrNoRows {
 		return Review{}
 	} else if err != nil {
-		log.Fatalf("%s: Failed to query db for revew for id %s", err, MangaID)
+		fatalf(ctx, err, "Failed to query db for revew for id %s", MangaID)
 	}
 
 	return rev
 }
 
-// Get a single Manga from the DB
-func (r *SQLite) GetByID(mangaID string) Manga {
-	row := r.db.QueryRow("SELECT * FROM Manga WHERE MangaID = ?", mangaID)
-	var m Manga
-	err := row.Scan(&m.MangaID, &m.SerTitle, &m.FullTitle, &m.Descr,
-		&m.TimeModified, &m.lastVolume, &m.lastChapter, &m.Demographic, &m.PubStatus)
-	if err != nil {
-		log.Fatalf("%s: Failed to query db for manga", err)
-	}
-	m.Chapters = r.GetChapters(m.MangaID)
-	m.Tags = r.getTags(m.MangaID)
-	m.Review = r.GetReview(m.MangaID)
-
+// Get a single Manga from the DB, complete with tags, chapters, and review
+func (r *SQLite) GetByID(ctx context.Context, mangaID string) Manga {
+	m := r.GetSummary(ctx, mangaID)
+	m.Chapters = r.GetChapters(ctx, m.MangaID)
 	return m
 }
 
-// Get all the Manga from the DB, complete with tags, chapters, and review
-func (r *SQLite) GetAll() []Manga {
-	rows, err := r.db.Query("SELECT * FROM Manga")
+// Get a single Manga from the DB with tags, review and Summary, but not chapters
+func (r *SQLite) GetSummary(ctx context.Context, mangaID string) Manga {
+	all := r.queryManga(ctx, "WHERE MangaID = ?", mangaID)
+	if len(all) != 1 {
+		log.Fatalf("Failed to query db for manga %s: Found %d", mangaID, len(all))
+	}
+	return all[0]
+}
+
+// Query the Manga table, complete with tags, review and Summary.
+// Chapters are left out, use GetChapters to load them when they're needed.
+// The where clause and its arguments narrow down which Manga are returned.
+//
+// However many Manga there are, this makes only three queries:
+// one for the Manga and their totals, then one each for all their tags and alt titles.
+func (r *SQLite) queryManga(ctx context.Context, where string, args ...any) []Manga {
+	query := `
+	SELECT ` + mangaCols + `,
+		coalesce(ChapterCount, 0), coalesce(UnreadCount, 0),
+		coalesce(DownloadedCount, 0), coalesce(LatestChapter, 0),
+		coalesce(LatestVolume, 0), coalesce(PageTotal, 0),
+		coalesce(ByteTotal, 0), Rating, Rev
+	FROM Manga
+	LEFT JOIN (
+		SELECT MangaID,
+			count(*) AS ChapterCount,
+			sum(NOT IsRead) AS UnreadCount,
+			sum(Downloaded) AS DownloadedCount,
+			max(ChapterNum) AS LatestChapter,
+			max(VolumeNum) AS LatestVolume,
+			sum(PageCount * Downloaded) AS PageTotal,
+			sum(ByteCount * Downloaded) AS ByteTotal
+		FROM Chapter
+		GROUP BY MangaID
+	) USING (MangaID)
+	LEFT JOIN Review USING (MangaID)
+	` + where
+	rows, err := r.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Fatalf("%s: Failed to query db for manga", err)
+		fatalf(ctx, err, "Failed to query db for manga")
 	}
 	defer rows.Close()
 
 	all := make([]Manga, 0)
+	ids := make([]string, 0)
 
 	for rows.Next() {
 		var m Manga
+		var rating sql.NullInt64
+		var rev sql.NullString
 		err := rows.Scan(
 			&m.MangaID,
 			&m.SerTitle,
@@ -351,16 +647,220 @@ func (r *SQLite) GetAll() []Manga {
 			&m.lastVolume,
 			&m.lastChapter,
 			&m.Demographic,
-			&m.PubStatus)
+			&m.PubStatus,
+			&m.Author,
+			&m.ReadStatus,
+			&m.Retention,
+			&m.Processing,
+			&m.Summary.Chapters,
+			&m.Summary.Unread,
+			&m.Summary.Downloaded,
+			&m.Summary.Latest,
+			&m.Summary.LatestVolume,
+			&m.Summary.Pages,
+			&m.Summary.Bytes,
+			&rating,
+			&rev)
 		if err != nil {
-			log.Fatalf("%s: Failed to parse manga", err)
+			fatalf(ctx, err, "Failed to parse manga")
+		}
+		if rating.Valid {
+			m.Review = Review{MangaID: m.MangaID, Rating: int(rating.Int64), Rev: rev.String}
 		}
-
-		m.Chapters = r.GetChapters(m.MangaID)
-		m.Tags = r.getTags(m.MangaID)
-		m.Review = r.GetReview(m.MangaID)
 
 		all = append(all, m)
+		ids = append(ids, m.MangaID)
+	}
+	rows.Close()
+
+	tags := r.getAllTags(ctx, ids)
+	altTitles := r.getAllAltTitles(ctx, ids)
+	for i, m := range all {
+		all[i].Tags = append(make([]Tag, 0), tags[m.MangaID]...)
+		all[i].AltTitles = append(make([]string, 0), altTitles[m.MangaID]...)
+	}
+
+	return all
+}
+
+// Get the tags of each of the given manga, by MangaID.
+func (r *SQLite) getAllTags(ctx context.Context, mangaIDs []string) map[string][]Tag {
+	query := `
+	SELECT MangaID, TagID, TagTitle
+	FROM ItemTag
+	JOIN Tag USING (TagID)
+	WHERE MangaID IN (SELECT value FROM json_each(?))`
+	rows, err := r.conn().QueryContext(ctx, query, jsonList(mangaIDs))
+	if err != nil {
+		fatalf(ctx, err, "Failed to query get tags")
+	}
+	defer rows.Close()
+
+	all := make(map[string][]Tag)
+	for rows.Next() {
+		var id string
+		var t Tag
+		if err := rows.Scan(&id, &t.TagID, &t.TagTitle); err != nil {
+			fatalf(ctx, err, "Failed to parse tags")
+		}
+		all[id] = append(all[id], t)
+	}
+
+	return all
+}
+
+// Get the alternate titles of each of the given manga, by MangaID.
+func (r *SQLite) getAllAltTitles(ctx context.Context, mangaIDs []string) map[string][]string {
+	query := `
+	SELECT MangaID, AltTitle
+	FROM AltTitle
+	WHERE MangaID IN (SELECT value FROM json_each(?))`
+	rows, err := r.conn().QueryContext(ctx, query, jsonList(mangaIDs))
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for alt titles")
+	}
+	defer rows.Close()
+
+	all := make(map[string][]string)
+	for rows.Next() {
+		var id, t string
+		if err := rows.Scan(&id, &t); err != nil {
+			fatalf(ctx, err, "Failed to parse alt title")
+		}
+		all[id] = append(all[id], t)
+	}
+
+	return all
+}
+
+// Encode a list of IDs as a JSON array, to pass any number of them
+// as a single parameter to json_each.
+func jsonList(ids []string) string {
+	b, err := json.Marshal(ids)
+	if err != nil {
+		log.Fatalf("%s: Failed to encode %v", err, ids)
+	}
+	return string(b)
+}
+
+// Get all the Manga from the DB, with tags, review and Summary
+func (r *SQLite) GetAll(ctx context.Context) []Manga {
+	return r.queryManga(ctx, "")
+}
+
+// Get all the Manga in a Collection, with tags, review and Summary
+func (r *SQLite) GetCollectionManga(ctx context.Context, c Collection) []Manga {
+	return r.queryManga(ctx, "WHERE MangaID IN (SELECT MangaID FROM CollectionItem WHERE CollectionID = ?)", c.CollectionID)
+}
+
+// Get all the collections, sorted by name.
+func (r *SQLite) GetCollections(ctx context.Context) []Collection {
+	rows, err := r.conn().QueryContext(ctx, "SELECT CollectionID, CollectionName FROM Collection ORDER BY CollectionName")
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for collections")
+	}
+	defer rows.Close()
+
+	all := make([]Collection, 0)
+	for rows.Next() {
+		var c Collection
+		if err := rows.Scan(&c.CollectionID, &c.Name); err != nil {
+			fatalf(ctx, err, "Failed to parse collection")
+		}
+		all = append(all, c)
+	}
+
+	return all
+}
+
+// Get the tags that at least one Manga has, by title.
+func (r *SQLite) GetTags(ctx context.Context) []TagCount {
+	query := `
+	SELECT TagID, TagTitle, count(DISTINCT MangaID)
+	FROM Tag JOIN ItemTag USING (TagID)
+	GROUP BY TagID
+	ORDER BY TagTitle`
+	rows, err := r.conn().QueryContext(ctx, query)
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for tags")
+	}
+	defer rows.Close()
+
+	all := make([]TagCount, 0)
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.TagID, &t.TagTitle, &t.Series); err != nil {
+			fatalf(ctx, err, "Failed to parse tag")
+		}
+		all = append(all, t)
+	}
+
+	return all
+}
+
+// Query the reading history, most recent first, returning at most limit events.
+// A negative limit returns everything.
+// The where clause and its arguments narrow down which events are returned.
+func (r *SQLite) queryHistory(ctx context.Context, limit int, where string, args ...any) []ReadEvent {
+	query := `
+	SELECT EventID, ChapterHash, StartTime, EndTime, PagesViewed,
+		MangaID, FullTitle, ChapterRaw, ChapterName
+	FROM ReadEvent
+	JOIN Chapter USING (ChapterHash)
+	JOIN Manga USING (MangaID)
+	` + where + `
+	ORDER BY StartTime DESC
+	LIMIT ?`
+	rows, err := r.conn().QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for reading history")
+	}
+	defer rows.Close()
+
+	all := make([]ReadEvent, 0)
+	for rows.Next() {
+		var e ReadEvent
+		err := rows.Scan(&e.EventID, &e.ChapterHash, &e.Start, &e.End, &e.PagesViewed,
+			&e.MangaID, &e.FullTitle, &e.ChapterRaw, &e.ChapterName)
+		if err != nil {
+			fatalf(ctx, err, "Failed to parse read event")
+		}
+		all = append(all, e)
+	}
+
+	return all
+}
+
+// Get the most recent reading sessions across the whole library.
+func (r *SQLite) GetHistory(ctx context.Context, limit int) []ReadEvent {
+	return r.queryHistory(ctx, limit, "")
+}
+
+// Get every reading session for a single Manga.
+func (r *SQLite) GetSeriesHistory(ctx context.Context, mangaID string) []ReadEvent {
+	return r.queryHistory(ctx, -1, "WHERE MangaID = ?", mangaID)
+}
+
+// Get every change to the ReadStatus of a Manga, most recent first.
+func (r *SQLite) GetStatusHistory(ctx context.Context, mangaID string) []StatusChange {
+	query := `
+	SELECT MangaID, ReadStatus, TimeChanged
+	FROM StatusChange
+	WHERE MangaID = ?
+	ORDER BY TimeChanged DESC`
+	rows, err := r.conn().QueryContext(ctx, query, mangaID)
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for status history")
+	}
+	defer rows.Close()
+
+	all := make([]StatusChange, 0)
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.MangaID, &c.ReadStatus, &c.TimeChanged); err != nil {
+			fatalf(ctx, err, "Failed to parse status change")
+		}
+		all = append(all, c)
 	}
 
 	return all
@@ -370,12 +870,12 @@ func (r *SQLite) GetAll() []Manga {
 
 // Update the TimeModified for the given Manga in the DB
 // and return the updated Manga.
-func (r *SQLite) UpdateTimeModified(m Manga) Manga {
+func (r *SQLite) UpdateTimeModified(ctx context.Context, m Manga) Manga {
 	m.TimeModified = time.Now()
 	updateStmt := "UPDATE Manga SET TimeModified = ? WHERE MangaID = ?"
-	res, err := r.db.Exec(updateStmt, m.TimeModified, m.MangaID)
+	res, err := r.conn().ExecContext(ctx, updateStmt, m.TimeModified, m.MangaID)
 	if err != nil {
-		log.Fatalf("%s: Failed to update access time %v", err, m)
+		fatalf(ctx, err, "Failed to update access time %v", m)
 	} else if n, _ := res.RowsAffected(); n > 1 {
 		log.Fatalf("Bad UpdateAtime: Updated %d rows", n)
 	}
@@ -383,13 +883,58 @@ func (r *SQLite) UpdateTimeModified(m Manga) Manga {
 	return m
 }
 
+// Update the ReadStatus for the given Manga in the DB, recording the change,
+// and return the updated Manga.
+func (r *SQLite) UpdateReadStatus(ctx context.Context, m Manga, status string) Manga {
+	tx, err := r.begin(ctx)
+	if err != nil {
+		fatalf(ctx, err, "Failed to begin transaction")
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE Manga SET ReadStatus = ? WHERE MangaID = ?", status, m.MangaID)
+	if err != nil {
+		fatalf(ctx, err, "Failed to update read status %v", m)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO StatusChange VALUES (?, ?, ?)", m.MangaID, status, time.Now())
+	if err != nil {
+		fatalf(ctx, err, "Failed to record status change for %s", m.MangaID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		fatalf(ctx, err, "Failed to commit transaction")
+	}
+
+	m.ReadStatus = status
+	return m
+}
+
+// Set the cleanup policy of a Manga, empty for the default, and return the updated Manga.
+func (r *SQLite) UpdateRetention(ctx context.Context, m Manga, policy string) Manga {
+	if _, err := r.conn().ExecContext(ctx, "UPDATE Manga SET Retention = ? WHERE MangaID = ?", policy, m.MangaID); err != nil {
+		fatalf(ctx, err, "Failed to update cleanup policy %v", m)
+	}
+
+	m.Retention = policy
+	return m
+}
+
+// Set the processing of a Manga's pages, empty for the default, and return the updated Manga.
+func (r *SQLite) UpdateProcessing(ctx context.Context, m Manga, processing string) Manga {
+	if _, err := r.conn().ExecContext(ctx, "UPDATE Manga SET Processing = ? WHERE MangaID = ?", processing, m.MangaID); err != nil {
+		fatalf(ctx, err, "Failed to update processing %v", m)
+	}
+
+	m.Processing = processing
+	return m
+}
+
 // Update Downloaded for the given Chapter in the DB
 // and return the updated Chapter.
-func (r *SQLite) UpdateChapterDownloaded(c Chapter) Chapter {
-	stmt := "UPDATE Chapter SET Downloaded = 1 WHERE ChapterHash = ?"
-	res, err := r.db.Exec(stmt, c.ChapterHash)
+func (r *SQLite) UpdateChapterDownloaded(ctx context.Context, c Chapter) Chapter {
+	stmt := "UPDATE Chapter SET Downloaded = 1, PageCount = ?, ByteCount = ? WHERE ChapterHash = ?"
+	res, err := r.conn().ExecContext(ctx, stmt, c.PageCount, c.ByteCount, c.ChapterHash)
 	if err != nil {
-		log.Fatalf("%s: Failed to update downloaded status %v", err, c)
+		fatalf(ctx, err, "Failed to update downloaded status %v", c)
 	} else if n, _ := res.RowsAffected(); n > 1 {
 		log.Fatalf("Bad UpdateChapterDownloaded: Updated %d rows", n)
 	}
@@ -398,23 +943,52 @@ func (r *SQLite) UpdateChapterDownloaded(c Chapter) Chapter {
 	return c
 }
 
-// Update IsRead for the given Chapter in the DB.
-func (r *SQLite) UpdateChapterRead(c Chapter) {
-	stmt := "UPDATE Chapter SET IsRead = 1 WHERE ChapterHash = ?"
-	res, err := r.db.Exec(stmt, c.ChapterHash)
+// Update IsRead for the given Chapter in the DB, recording when.
+func (r *SQLite) UpdateChapterRead(ctx context.Context, c Chapter) {
+	stmt := "UPDATE Chapter SET IsRead = 1, TimeRead = ? WHERE ChapterHash = ?"
+	res, err := r.conn().ExecContext(ctx, stmt, time.Now(), c.ChapterHash)
 	if err != nil {
-		log.Fatalf("%s: Failed to update read status %v", err, c)
+		fatalf(ctx, err, "Failed to update read status %v", c)
 	} else if n, _ := res.RowsAffected(); n > 1 {
 		log.Fatalf("Bad UpdateChapterRead: Updated %d rows", n)
 	}
 }
 
+// Update LastPage for the given Chapter in the DB.
+// If the final page was reached, IsRead and TimeRead are set as well.
+// Returns the updated Chapter.
+func (r *SQLite) UpdateChapterProgress(ctx context.Context, c Chapter, page int, finished bool) Chapter {
+	if finished {
+		c.TimeRead = time.Now()
+	}
+	stmt := "UPDATE Chapter SET LastPage = ?, IsRead = IsRead OR ?, TimeRead = CASE WHEN ? THEN ? ELSE TimeRead END WHERE ChapterHash = ?"
+	res, err := r.conn().ExecContext(ctx, stmt, page, finished, finished, c.TimeRead, c.ChapterHash)
+	if err != nil {
+		fatalf(ctx, err, "Failed to update reading progress %v", c)
+	} else if n, _ := res.RowsAffected(); n > 1 {
+		log.Fatalf("Bad UpdateChapterProgress: Updated %d rows", n)
+	}
+
+	c.LastPage = page
+	c.IsRead = c.IsRead || finished
+	return c
+}
+
+// ------- DELETE FUNCTIONS -------
+
+// Remove a Manga from a Collection. The Collection itself is kept, even if empty.
+func (r *SQLite) RemoveFromCollection(ctx context.Context, c Collection, mangaID string) {
+	stmt := "DELETE FROM CollectionItem WHERE CollectionID = ? AND MangaID = ?"
+	if _, err := r.conn().ExecContext(ctx, stmt, c.CollectionID, mangaID); err != nil {
+		fatalf(ctx, err, "Failed to remove %s from collection %s", mangaID, c.Name)
+	}
+}
+
 // Initialization functions
 
 // Initialize the database
-func (r *SQLite) initdb() {
+func (r *SQLite) initdb(ctx context.Context) {
 	create_stmt := `
-	PRAGMA foreign_keys = ON;
 		CREATE TABLE IF NOT EXISTS Manga(
 	    MangaID VARCHAR(64) PRIMARY KEY,
 	    SerTitle VARCHAR(32) NOT NULL UNIQUE,
@@ -425,11 +999,26 @@ func (r *SQLite) initdb() {
 		LastChapter REAL,
 	    Demographic VARCHAR(7),
 	    PubStatus VARCHAR(9),
+		Author VARCHAR(128) NOT NULL DEFAULT '',
+		ReadStatus VARCHAR(12) NOT NULL DEFAULT '',
+		Retention VARCHAR(16) NOT NULL DEFAULT '',
+		Processing VARCHAR(64) NOT NULL DEFAULT '',
 
 	    CHECK (Demographic IN ('Shounen', 'Shoujo', 'Seinen', 'Josei', 'Unknown')),
-	    CHECK (PubStatus IN ('Ongoing', 'Completed', 'Hiatus', 'Cancelled'))
+	    CHECK (PubStatus IN ('Ongoing', 'Completed', 'Hiatus', 'Cancelled')),
+		CHECK (ReadStatus IN ('', 'Reading', 'On hold', 'Dropped', 'Plan to read', 'Completed'))
     );
 
+	CREATE TABLE IF NOT EXISTS AltTitle (
+		MangaID VARCHAR(64),
+		AltTitle VARCHAR(128),
+
+		PRIMARY KEY (MangaID, AltTitle),
+		FOREIGN KEY (MangaID) REFERENCES Manga(MangaID)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE
+	);
+
 	CREATE TABLE IF NOT EXISTS Tag (
 	    TagID INTEGER PRIMARY KEY,
 	    TagTitle VARCHAR(16) UNIQUE
@@ -451,12 +1040,21 @@ func (r *SQLite) initdb() {
 	CREATE TABLE IF NOT EXISTS Chapter (
 	    ChapterHash VARCHAR(64) PRIMARY KEY,
 	    ChapterNum REAL,
+		ChapterRaw VARCHAR(16) NOT NULL DEFAULT '',
+		ChapterKey VARCHAR(64) NOT NULL DEFAULT '',
 	    ChapterName VARCHAR(32),
 		VolumeNum INTEGER,
 	    MangaID VARCHAR(64),
 	    Downloaded INTEGER NOT NULL,
 	    IsRead INTEGER NOT NULL,
 		ChapterPath VARCHAR(64),
+		LastPage INTEGER NOT NULL DEFAULT 0,
+		Language VARCHAR(8) NOT NULL DEFAULT '',
+		GroupName VARCHAR(64) NOT NULL DEFAULT '',
+		PageCount INTEGER NOT NULL DEFAULT 0,
+		ByteCount INTEGER NOT NULL DEFAULT 0,
+		TimeRead DATETIME,
+		TimePublished DATETIME,
 
 	    FOREIGN KEY (MangaID) REFERENCES Manga(MangaID)
 	);
@@ -471,25 +1069,276 @@ func (r *SQLite) initdb() {
 		CHECK (
 			Rating BETWEEN 0 AND 100
 		)
+	);
+
+	CREATE TABLE IF NOT EXISTS ReadEvent (
+		EventID INTEGER PRIMARY KEY,
+		ChapterHash VARCHAR(64) NOT NULL,
+		StartTime DATETIME NOT NULL,
+		EndTime DATETIME NOT NULL,
+		PagesViewed INTEGER NOT NULL,
+
+		FOREIGN KEY (ChapterHash) REFERENCES Chapter(ChapterHash)
+			ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS ReadEventChapter_idx on ReadEvent(ChapterHash);
+	CREATE INDEX IF NOT EXISTS ReadEventStart_idx on ReadEvent(StartTime);
+
+	CREATE TABLE IF NOT EXISTS StatusChange (
+		MangaID VARCHAR(64) NOT NULL,
+		ReadStatus VARCHAR(12) NOT NULL,
+		TimeChanged DATETIME NOT NULL,
+
+		FOREIGN KEY (MangaID) REFERENCES Manga(MangaID)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS StatusChangeMid_idx on StatusChange(MangaID);
+
+	CREATE TABLE IF NOT EXISTS SavedFilter (
+		FilterName VARCHAR(64) PRIMARY KEY,
+		Expr VARCHAR(512) NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS Collection (
+		CollectionID INTEGER PRIMARY KEY,
+		CollectionName VARCHAR(64) NOT NULL UNIQUE
+	);
+
+	CREATE TABLE IF NOT EXISTS CollectionItem (
+		CollectionID INTEGER,
+		MangaID VARCHAR(64),
+
+		PRIMARY KEY (CollectionID, MangaID),
+		FOREIGN KEY (CollectionID) REFERENCES Collection(CollectionID)
+			ON DELETE CASCADE,
+		FOREIGN KEY (MangaID) REFERENCES Manga(MangaID)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE
 	);`
 
-	_, err := r.db.Exec(create_stmt)
+	_, err := r.conn().ExecContext(ctx, create_stmt)
+	if err != nil {
+		fatalf(ctx, err, "Failed to initialize DB")
+	}
+
+	r.migrate(ctx)
+	r.initSearch(ctx)
+}
+
+// Bring databases created by older versions up to date.
+// CREATE TABLE IF NOT EXISTS won't touch an existing table,
+// so any column added after the first release has to be added here too.
+func (r *SQLite) migrate(ctx context.Context) {
+	r.addColumn(ctx, "Chapter", "LastPage", "INTEGER NOT NULL DEFAULT 0")
+	r.addColumn(ctx, "Manga", "ReadStatus", "VARCHAR(12) NOT NULL DEFAULT ''")
+	r.addColumn(ctx, "Chapter", "Language", "VARCHAR(8) NOT NULL DEFAULT ''")
+	r.addColumn(ctx, "Chapter", "GroupName", "VARCHAR(64) NOT NULL DEFAULT ''")
+	r.addColumn(ctx, "Manga", "Author", "VARCHAR(128) NOT NULL DEFAULT ''")
+	r.addColumn(ctx, "Manga", "Retention", "VARCHAR(16) NOT NULL DEFAULT ''")
+	r.addColumn(ctx, "Manga", "Processing", "VARCHAR(64) NOT NULL DEFAULT ''")
+	r.addColumn(ctx, "Chapter", "PageCount", "INTEGER NOT NULL DEFAULT 0")
+	r.addColumn(ctx, "Chapter", "ByteCount", "INTEGER NOT NULL DEFAULT 0")
+	r.addColumn(ctx, "Chapter", "ChapterRaw", "VARCHAR(16) NOT NULL DEFAULT ''")
+	if r.addColumn(ctx, "Chapter", "ChapterKey", "VARCHAR(64) NOT NULL DEFAULT ''") {
+		r.migrateChapterKeys(ctx)
+	}
+	r.addColumn(ctx, "Chapter", "TimeRead", "DATETIME")
+	r.addColumn(ctx, "Chapter", "TimePublished", "DATETIME")
+	if v := r.userVersion(ctx); v&migratedKeys == 0 {
+		r.rekeyChapters(ctx, v)
+	}
+	r.checkReadStatus(ctx)
+}
+
+// The one-time migrations that can't tell from the schema whether they've run,
+// recorded as a bit each in PRAGMA user_version once they have.
+const (
+	migratedMeasure = 1 << iota // MeasureChapters has measured chapters downloaded before their sizes were kept
+	migratedKeys                // Chapter keys tell 1.10 from 1.1
+)
+
+func (r *SQLite) userVersion(ctx context.Context) int {
+	var v int
+	if err := r.conn().QueryRowContext(ctx, "PRAGMA user_version").Scan(&v); err != nil {
+		fatalf(ctx, err, "Failed to get the database version")
+	}
+	return v
+}
+
+// Record that a migration has run, given the user_version from before it ran.
+// q is the transaction the migration ran in, so it's recorded along with it.
+func setMigrated(ctx context.Context, q querier, v, migration int) {
+	if _, err := q.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", v|migration)); err != nil {
+		fatalf(ctx, err, "Failed to set the database version")
+	}
+}
+
+// Update the sort keys of chapters whose key has changed since they were stored,
+// like 1.10, which used to share its key with 1.1.
+func (r *SQLite) rekeyChapters(ctx context.Context, v int) {
+	rows, err := r.conn().QueryContext(ctx, "SELECT ChapterHash, ChapterRaw, ChapterKey FROM Chapter")
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for chapters")
+	}
+	defer rows.Close()
+
+	stale := make([]Chapter, 0)
+	for rows.Next() {
+		var c Chapter
+		if err := rows.Scan(&c.ChapterHash, &c.ChapterRaw, &c.SortKey); err != nil {
+			fatalf(ctx, err, "Failed to parse chapter")
+		}
+		if key := ParseChapterNumber(c.ChapterRaw).Key(); key != c.SortKey {
+			c.SortKey = key
+			stale = append(stale, c)
+		}
+	}
+	rows.Close()
+
+	tx, err := r.begin(ctx)
+	if err != nil {
+		fatalf(ctx, err, "Failed to begin transaction")
+	}
+	stmt, err := tx.PrepareContext(ctx, "UPDATE Chapter SET ChapterKey = ? WHERE ChapterHash = ?")
+	if err != nil {
+		fatalf(ctx, err, "Failed to prepare transaction")
+	}
+	defer stmt.Close()
+
+	for _, c := range stale {
+		if _, err := stmt.ExecContext(ctx, c.SortKey, c.ChapterHash); err != nil {
+			fatalf(ctx, err, "Failed to execute transaction on %v", c)
+		}
+	}
+	setMigrated(ctx, tx, v, migratedKeys)
+
+	if err := tx.Commit(); err != nil {
+		fatalf(ctx, err, "Failed to commit transaction")
+	}
+}
+
+// Check the ReadStatus of Manga tables created before it had a CHECK.
+// SQLite can't add a CHECK to an existing table, so triggers do the same job.
+func (r *SQLite) checkReadStatus(ctx context.Context) {
+	var schema string
+	if err := r.conn().QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'Manga'").Scan(&schema); err != nil {
+		fatalf(ctx, err, "Failed to get the schema of Manga")
+	}
+	if strings.Contains(schema, "CHECK (ReadStatus IN") {
+		return
+	}
+
+	triggers := `
+	CREATE TRIGGER IF NOT EXISTS MangaReadStatus_bi BEFORE INSERT ON Manga
+	WHEN new.ReadStatus NOT IN ('', 'Reading', 'On hold', 'Dropped', 'Plan to read', 'Completed') BEGIN
+		SELECT RAISE(ABORT, 'CHECK constraint failed: ReadStatus');
+	END;
+	CREATE TRIGGER IF NOT EXISTS MangaReadStatus_bu BEFORE UPDATE OF ReadStatus ON Manga
+	WHEN new.ReadStatus NOT IN ('', 'Reading', 'On hold', 'Dropped', 'Plan to read', 'Completed') BEGIN
+		SELECT RAISE(ABORT, 'CHECK constraint failed: ReadStatus');
+	END;`
+	if _, err := r.conn().ExecContext(ctx, triggers); err != nil {
+		fatalf(ctx, err, "Failed to add ReadStatus check")
+	}
+}
+
+// Fill in the raw chapter number and sort key of chapters stored
+// before they existed, from the number that was stored as a float.
+func (r *SQLite) migrateChapterKeys(ctx context.Context) {
+	rows, err := r.conn().QueryContext(ctx, "SELECT ChapterHash, ChapterNum FROM Chapter")
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for chapters")
+	}
+	defer rows.Close()
+
+	all := make([]Chapter, 0)
+	for rows.Next() {
+		var c Chapter
+		if err := rows.Scan(&c.ChapterHash, &c.ChapterNum); err != nil {
+			fatalf(ctx, err, "Failed to parse chapter")
+		}
+		setChapterNumber(&c, strconv.FormatFloat(c.ChapterNum, 'f', -1, 64))
+		all = append(all, c)
+	}
+	rows.Close()
+
+	tx, err := r.begin(ctx)
+	if err != nil {
+		fatalf(ctx, err, "Failed to begin transaction")
+	}
+	stmt, err := tx.PrepareContext(ctx, "UPDATE Chapter SET ChapterRaw = ?, ChapterKey = ? WHERE ChapterHash = ?")
+	if err != nil {
+		fatalf(ctx, err, "Failed to prepare transaction")
+	}
+	defer stmt.Close()
+
+	for _, c := range all {
+		if _, err := stmt.ExecContext(ctx, c.ChapterRaw, c.SortKey, c.ChapterHash); err != nil {
+			fatalf(ctx, err, "Failed to execute transaction on %v", c)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		fatalf(ctx, err, "Failed to commit transaction")
+	}
+}
+
+// Add a column to a table if it doesn't already have it.
+// Returns true if the column was added.
+func (r *SQLite) addColumn(ctx context.Context, table, column, def string) bool {
+	rows, err := r.conn().QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
 	if err != nil {
-		log.Fatalf("%s: Failed to initialize DB", err)
+		fatalf(ctx, err, "Failed to get columns of %s", table)
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			fatalf(ctx, err, "Failed to parse columns of %s", table)
+		}
+		if name == column {
+			return false
+		}
+	}
+	rows.Close()
+
+	stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def)
+	if _, err := r.conn().ExecContext(ctx, stmt); err != nil {
+		fatalf(ctx, err, "Failed to add column %s to %s", column, table)
+	}
+	return true
 }
 
+// How long a connection waits for another to finish writing
+// before giving up with "database is locked".
+const busyTimeout = 10 * time.Second
+
 // Get a new DB connection.
 // Guarantees that the file you specify will be created
 // and the tables will be initialized.
-func Opendb(name string) *SQLite {
-	db, err := sql.Open("sqlite3", name)
+//
+// Downloads write to the DB from their own goroutines while the UI reads and writes,
+// so every connection in the pool is set up to share it: WAL lets reads carry on
+// during a write, writers wait on each other instead of failing, and transactions
+// take the write lock up front so two of them can't deadlock upgrading to it.
+// Foreign keys are a per-connection setting in SQLite, so they're set here too.
+func Opendb(ctx context.Context, name string) *SQLite {
+	sep := "?"
+	if strings.Contains(name, "?") {
+		sep = "&"
+	}
+	dsn := fmt.Sprintf("%s%s_journal_mode=WAL&_busy_timeout=%d&_foreign_keys=on&_txlock=immediate",
+		name, sep, busyTimeout.Milliseconds())
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		log.Fatalf("%s: Failed to open %s", err, name)
+		fatalf(ctx, err, "Failed to open %s", name)
 	}
 
 	store := newDb(db)
-	store.initdb()
+	store.initdb(ctx)
 
 	return store
 }
@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Open a new database file in a temporary directory, closed when the test ends
+func testDB(tb testing.TB) *SQLite {
+	tb.Helper()
+	r := Opendb(context.Background(), filepath.Join(tb.TempDir(), "manga.sqlite3"))
+	tb.Cleanup(func() { r.db.Close() })
+	return r
+}
+
+// A generated series with chapters, some of them downloaded and read, and a few tags
+func testManga(i, chapters int) Manga {
+	m := Manga{
+		MangaID:      fmt.Sprintf("manga-%05d", i),
+		SerTitle:     fmt.Sprintf("series%d", i),
+		FullTitle:    fmt.Sprintf("Series %d", i),
+		AltTitles:    []string{fmt.Sprintf("Alt series %d", i)},
+		Descr:        "A generated series",
+		TimeModified: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+		Demographic:  "Shounen",
+		PubStatus:    "Ongoing",
+	}
+	for j := 1; j <= chapters; j++ {
+		raw := fmt.Sprint(j)
+		c := Chapter{
+			ChapterHash: fmt.Sprintf("%s-%04d", m.MangaID, j),
+			ChapterNum:  float64(j),
+			ChapterRaw:  raw,
+			SortKey:     ParseChapterNumber(raw).Key(),
+			ChapterName: fmt.Sprintf("Chapter %d", j),
+			VolumeNum:   (j-1)/10 + 1,
+			MangaID:     m.MangaID,
+			Downloaded:  j <= chapters/2,
+			IsRead:      j <= chapters/4,
+			Language:    "en",
+		}
+		if c.Downloaded {
+			c.ChapterPath = fmt.Sprintf("%s/%02d/%05.1f", m.SerTitle, c.VolumeNum, c.ChapterNum)
+			c.PageCount = 20
+			c.ByteCount = 20 << 20
+		}
+		m.Chapters = append(m.Chapters, c)
+	}
+	return m
+}
+
+// A database of n generated series, each with 20 chapters and 3 of 10 tags
+func testLibrary(tb testing.TB, n int) *SQLite {
+	tb.Helper()
+	ctx := context.Background()
+	r := testDB(tb)
+	var names []string
+	for i := range 10 {
+		names = append(names, fmt.Sprintf("Tag %d", i))
+	}
+	r.insertTags(ctx, names)
+	for i := range n {
+		m := testManga(i, 20)
+		m.Tags = r.tagNamesToTags(ctx, []string{names[i%10], names[(i+3)%10], names[(i+7)%10]})
+		r.insertManga(ctx, m)
+	}
+	return r
+}
+
+// Readers and writers on their own goroutines, like downloads alongside the UI,
+// shouldn't fail with "database is locked". Any failure is fatal, so finishing is passing.
+func TestConcurrentAccess(t *testing.T) {
+	const workers, rounds = 16, 50
+	ctx := context.Background()
+	r := testLibrary(t, workers)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := r.GetSummary(ctx, fmt.Sprintf("manga-%05d", w))
+			chapters := r.GetChapters(ctx, m.MangaID)
+			for i := range rounds {
+				c := chapters[i%len(chapters)]
+				switch i % 5 {
+				case 0:
+					r.UpdateChapterProgress(ctx, c, i, false)
+				case 1:
+					now := time.Now()
+					r.insertReadEvent(ctx, ReadEvent{ChapterHash: c.ChapterHash, Start: now, End: now, PagesViewed: 1})
+				case 2:
+					m = r.UpdateReadStatus(ctx, m, ReadStatuses[i%len(ReadStatuses)])
+				case 3:
+					r.GetAll(ctx)
+				case 4:
+					r.GetHistory(ctx, 10)
+					r.GetChapters(ctx, m.MangaID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(r.GetHistory(ctx, workers*rounds)); n != workers*rounds/5 {
+		t.Errorf("Recorded %d reading sessions, want %d", n, workers*rounds/5)
+	}
+}
+
+func BenchmarkGetAll(b *testing.B) {
+	r := testLibrary(b, 5000)
+	ctx := context.Background()
+	b.ResetTimer()
+	for range b.N {
+		if all := r.GetAll(ctx); len(all) != 5000 {
+			b.Fatalf("GetAll returned %d series, want 5000", len(all))
+		}
+	}
+}
+
+func BenchmarkGetChapters(b *testing.B) {
+	r := testLibrary(b, 5000)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := range b.N {
+		if chapters := r.GetChapters(ctx, fmt.Sprintf("manga-%05d", i%5000)); len(chapters) != 20 {
+			b.Fatalf("GetChapters returned %d chapters, want 20", len(chapters))
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+// Everything the rest of the program needs from storage.
+// SQLite is the real implementation, and Memory keeps everything in memory
+// with the same behaviour, for trying things out without a DB file.
+//
+// Like the rest of the backend, implementations treat a failure to read or
+// write storage as fatal.
+type Store interface {
+	// ------- CREATE -------
+
+	// Insert the given Manga, along with its chapters, tags and alt titles.
+	// The tags must already exist, see insertTags.
+	insertManga(ctx context.Context, m Manga)
+	// Insert the given chapters, ignoring any that already exist.
+	insertChapters(ctx context.Context, chapters []Chapter)
+	// Create the named tags, ignoring any that already exist.
+	insertTags(ctx context.Context, names []string)
+	// Add alternate titles to a Manga, ignoring any it already has.
+	insertAltTitles(ctx context.Context, mangaID string, titles []string)
+	insertReview(ctx context.Context, rev Review)
+	insertReadEvent(ctx context.Context, e ReadEvent)
+	insertStatusChange(ctx context.Context, c StatusChange)
+	AddToCollection(ctx context.Context, name string, mangaID string) Collection
+	SaveFilter(ctx context.Context, name string, f Filter)
+
+	// ------- READ -------
+
+	mangaExists(ctx context.Context, mangaID string) bool
+	// Get the MangaID of the Manga with the abbreviated title, if there is one.
+	serTitleID(ctx context.Context, abbrev string) (string, bool)
+	// Look up existing tags by name.
+	tagNamesToTags(ctx context.Context, names []string) []Tag
+	// Get every Manga with tags, review and Summary, but not chapters.
+	GetAll(ctx context.Context) []Manga
+	// Get a single Manga, complete with chapters.
+	GetByID(ctx context.Context, mangaID string) Manga
+	// Get a single Manga with tags, review and Summary, but not chapters.
+	GetSummary(ctx context.Context, mangaID string) Manga
+	// Get the chapters of a Manga, in reading order.
+	GetChapters(ctx context.Context, mangaID string) []Chapter
+	GetReview(ctx context.Context, mangaID string) Review
+	// Get the tags that at least one Manga has, by title, with how many have each.
+	GetTags(ctx context.Context) []TagCount
+	GetCollections(ctx context.Context) []Collection
+	GetCollectionManga(ctx context.Context, c Collection) []Manga
+	FilterManga(ctx context.Context, f Filter) []Manga
+	GetSavedFilters(ctx context.Context) []SavedFilter
+	// Search titles, alt titles, descriptions and reviews, most relevant first.
+	Search(ctx context.Context, text string) []Manga
+	GetHistory(ctx context.Context, limit int) []ReadEvent
+	GetSeriesHistory(ctx context.Context, mangaID string) []ReadEvent
+	GetStatusHistory(ctx context.Context, mangaID string) []StatusChange
+
+	// ------- UPDATE -------
+
+	UpdateTimeModified(ctx context.Context, m Manga) Manga
+	UpdateReadStatus(ctx context.Context, m Manga, status string) Manga
+	// Set the ReadStatus without recording the change.
+	setReadStatus(ctx context.Context, mangaID string, status string)
+	// Set the cleanup policy, see ParseRetention. Empty uses the default.
+	UpdateRetention(ctx context.Context, m Manga, policy string) Manga
+	// Set how downloaded pages are processed, see ParseProcessing. Empty uses the default.
+	UpdateProcessing(ctx context.Context, m Manga, processing string) Manga
+	UpdateChapterDownloaded(ctx context.Context, c Chapter) Chapter
+	UpdateChapterRead(ctx context.Context, c Chapter)
+	UpdateChapterProgress(ctx context.Context, c Chapter, page int, finished bool) Chapter
+	// Set Downloaded, IsRead and LastPage of a chapter.
+	updateChapterState(ctx context.Context, c Chapter)
+
+	// ------- DELETE -------
+
+	RemoveFromCollection(ctx context.Context, c Collection, mangaID string)
+
+	// Run f against a Store whose changes are kept all together or not at all.
+	transaction(ctx context.Context, f func(store Store))
+}
+
+var (
+	_ Store = (*SQLite)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// Exit on a failure to use storage or MangaDex, like log.Fatalf with err first.
+// When ctx was cancelled, the failure is only the program being interrupted,
+// so it exits quietly instead.
+func fatalf(ctx context.Context, err error, format string, args ...any) {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		log.Print("Interrupted")
+		os.Exit(130)
+	}
+	log.Fatalf("%s: "+format, append([]any{err}, args...)...)
+}
+
+// Get a single Manga with tags, review, Summary and chapters, if there is one
+// with the given ID. For looking up IDs from outside, like in a URL,
+// which GetByID and GetSummary expect to exist.
+func FindManga(ctx context.Context, store Store, mangaID string) (Manga, bool) {
+	if !store.mangaExists(ctx, mangaID) {
+		return Manga{}, false
+	}
+	m := store.GetSummary(ctx, mangaID)
+	m.Chapters = store.GetChapters(ctx, mangaID)
+	return m, true
+}
+
+// Like FindManga, but by abbreviated title or MangaDex ID, for naming
+// a series on the command line.
+func FindSeries(ctx context.Context, store Store, name string) (Manga, bool) {
+	if mangaID, ok := store.serTitleID(ctx, name); ok {
+		name = mangaID
+	}
+	return FindManga(ctx, store, name)
+}
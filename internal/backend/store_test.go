@@ -0,0 +1,314 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	// Every connection in the pool has to see the same in-memory DB
+	r := Opendb(context.Background(), fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	t.Cleanup(func() { r.db.Close() })
+	storeConformance(t, r)
+}
+
+func TestMemoryConformance(t *testing.T) {
+	storeConformance(t, NewMemory())
+}
+
+// Check that a Store behaves as the Store interface says, so that SQLite and Memory agree.
+// Each case works on its own series, so they can share the store.
+func storeConformance(t *testing.T, s Store) {
+	ctx := context.Background()
+	tags := []string{"Action", "Romance", "Slice of Life"}
+	s.insertTags(ctx, tags)
+	for i := range 8 {
+		m := testManga(i, 20)
+		m.Tags = s.tagNamesToTags(ctx, tags[:i%3+1])
+		s.insertManga(ctx, m)
+	}
+	frieren := testManga(100, 2)
+	frieren.SerTitle, frieren.FullTitle = "frieren", "Frieren: Beyond Journey's End"
+	frieren.AltTitles = []string{"Sousou no Frieren"}
+	frieren.Descr = "An elf mage outlives her party"
+	frieren.PubStatus = "Completed"
+	s.insertManga(ctx, frieren)
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"summary", func(t *testing.T) {
+			m := s.GetSummary(ctx, "manga-00000")
+			want := Summary{Chapters: 20, Unread: 15, Downloaded: 10, Latest: 20, LatestVolume: 2, Pages: 200, Bytes: 10 * 20 << 20}
+			if m.Summary != want {
+				t.Errorf("Summary %+v, want %+v", m.Summary, want)
+			}
+			if m.FullTitle != "Series 0" || m.Demographic != "Shounen" || !m.TimeModified.Equal(testManga(0, 0).TimeModified) {
+				t.Errorf("GetSummary returned %+v", m)
+			}
+			if len(m.Chapters) != 0 {
+				t.Errorf("GetSummary returned %d chapters, want none", len(m.Chapters))
+			}
+			if !slices.Equal(m.AltTitles, []string{"Alt series 0"}) {
+				t.Errorf("Alt titles %q", m.AltTitles)
+			}
+		}},
+		{"all", func(t *testing.T) {
+			all := s.GetAll(ctx)
+			if len(all) != 9 {
+				t.Fatalf("GetAll returned %d series, want 9", len(all))
+			}
+			i := slices.IndexFunc(all, func(m Manga) bool { return m.MangaID == "manga-00002" })
+			if i < 0 || all[i].Summary != s.GetSummary(ctx, "manga-00002").Summary {
+				t.Errorf("GetAll and GetSummary disagree on manga-00002")
+			}
+			if i >= 0 && len(all[i].Tags) != 3 {
+				t.Errorf("manga-00002 has tags %v, want 3", all[i].Tags)
+			}
+		}},
+		{"exists", func(t *testing.T) {
+			if !s.mangaExists(ctx, "manga-00001") || s.mangaExists(ctx, "manga-99999") {
+				t.Error("mangaExists is wrong")
+			}
+			if id, ok := s.serTitleID(ctx, "frieren"); !ok || id != frieren.MangaID {
+				t.Errorf("serTitleID(frieren) = %s, %t", id, ok)
+			}
+			if _, ok := s.serTitleID(ctx, "nobody"); ok {
+				t.Error("serTitleID found a missing abbreviated title")
+			}
+			if m, ok := FindManga(ctx, s, "manga-00001"); !ok || m.SerTitle != "series1" || len(m.Chapters) != 20 {
+				t.Errorf("FindManga found %t, %s with %d chapters", ok, m.SerTitle, len(m.Chapters))
+			}
+			if _, ok := FindManga(ctx, s, "manga-99999"); ok {
+				t.Error("FindManga found a series that isn't there")
+			}
+			for _, name := range []string{"frieren", frieren.MangaID} {
+				if m, ok := FindSeries(ctx, s, name); !ok || m.MangaID != frieren.MangaID || len(m.Chapters) != 2 {
+					t.Errorf("FindSeries(%s) found %t, %s with %d chapters", name, ok, m.MangaID, len(m.Chapters))
+				}
+			}
+			if _, ok := FindSeries(ctx, s, "nobody"); ok {
+				t.Error("FindSeries found a series that isn't there")
+			}
+		}},
+		{"tags", func(t *testing.T) {
+			s.insertTags(ctx, []string{"Action", "Horror"})
+			found := s.tagNamesToTags(ctx, []string{"Action", "Horror"})
+			if len(found) != 2 || found[0].TagTitle != "Action" || found[1].TagTitle != "Horror" {
+				t.Errorf("tagNamesToTags returned %v", found)
+			}
+			// Horror has no series, so it isn't listed
+			var got []string
+			for _, tc := range s.GetTags(ctx) {
+				got = append(got, fmt.Sprintf("%s %d", tc.TagTitle, tc.Series))
+			}
+			if want := []string{"Action 8", "Romance 5", "Slice of Life 2"}; !slices.Equal(got, want) {
+				t.Errorf("GetTags returned %q, want %q", got, want)
+			}
+		}},
+		{"chapter order", func(t *testing.T) {
+			m := testManga(200, 0)
+			for _, raw := range []string{"3", "1", "2.5", "2"} {
+				c := Chapter{ChapterHash: m.MangaID + "-" + raw, MangaID: m.MangaID, Language: "en"}
+				setChapterNumber(&c, raw)
+				m.Chapters = append(m.Chapters, c)
+			}
+			s.insertManga(ctx, m)
+			// Duplicates are ignored
+			s.insertChapters(ctx, m.Chapters[:1])
+
+			var got []string
+			for _, c := range s.GetChapters(ctx, m.MangaID) {
+				got = append(got, c.ChapterRaw)
+			}
+			if want := []string{"1", "2", "2.5", "3"}; !slices.Equal(got, want) {
+				t.Errorf("Chapters in order %q, want %q", got, want)
+			}
+			if byID := s.GetByID(ctx, m.MangaID); len(byID.Chapters) != 4 || byID.Chapters[0].ChapterRaw != "1" {
+				t.Errorf("GetByID returned chapters %v", byID.Chapters)
+			}
+		}},
+		{"progress", func(t *testing.T) {
+			c := s.GetChapters(ctx, "manga-00001")[10]
+			c = s.UpdateChapterProgress(ctx, c, 5, false)
+			if got := s.GetChapters(ctx, "manga-00001")[10]; got != c || got.LastPage != 5 || got.IsRead {
+				t.Errorf("After reading to page 5, chapter is %+v", got)
+			}
+			s.UpdateChapterProgress(ctx, c, 20, true)
+			s.UpdateChapterProgress(ctx, c, 3, false)
+			if got := s.GetChapters(ctx, "manga-00001")[10]; got.LastPage != 3 || !got.IsRead {
+				t.Errorf("Going back after finishing, chapter is %+v", got)
+			}
+		}},
+		{"read", func(t *testing.T) {
+			c := s.GetChapters(ctx, "manga-00003")[19]
+			s.UpdateChapterRead(ctx, c)
+			if got := s.GetChapters(ctx, "manga-00003")[19]; !got.IsRead || got.TimeRead.IsZero() {
+				t.Errorf("Chapter read %t at %v, want read now", got.IsRead, got.TimeRead)
+			}
+			if n := s.GetSummary(ctx, "manga-00003").Summary.Unread; n != 14 {
+				t.Errorf("%d unread after reading one, want 14", n)
+			}
+		}},
+		{"downloaded", func(t *testing.T) {
+			c := s.GetChapters(ctx, "manga-00004")[15]
+			c.PageCount, c.ByteCount = 7, 7000
+			c = s.UpdateChapterDownloaded(ctx, c)
+			if got := s.GetChapters(ctx, "manga-00004")[15]; got != c || !got.Downloaded {
+				t.Errorf("Downloaded chapter is %+v, want %+v", got, c)
+			}
+			sum := s.GetSummary(ctx, "manga-00004").Summary
+			if sum.Downloaded != 11 || sum.Pages != 207 || sum.Bytes != 10*20<<20+7000 {
+				t.Errorf("Summary after downloading is %+v", sum)
+			}
+
+			c.Downloaded, c.IsRead, c.LastPage = false, true, 2
+			s.updateChapterState(ctx, c)
+			got := s.GetChapters(ctx, "manga-00004")[15]
+			if got.Downloaded || !got.IsRead || got.LastPage != 2 {
+				t.Errorf("updateChapterState left %+v", got)
+			}
+		}},
+		{"read status", func(t *testing.T) {
+			m := s.GetSummary(ctx, "manga-00005")
+			m = s.UpdateReadStatus(ctx, m, StatusReading)
+			s.setReadStatus(ctx, m.MangaID, StatusDropped)
+			if got := s.GetSummary(ctx, m.MangaID).ReadStatus; got != StatusDropped {
+				t.Errorf("Status %q, want %q", got, StatusDropped)
+			}
+			s.insertStatusChange(ctx, StatusChange{m.MangaID, StatusPlanToRead, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)})
+			history := s.GetStatusHistory(ctx, m.MangaID)
+			if len(history) != 2 {
+				t.Fatalf("Status history %v, want 2 changes", history)
+			}
+			var got []string
+			for _, c := range history {
+				got = append(got, c.ReadStatus)
+			}
+			if !slices.Contains(got, StatusReading) || !slices.Contains(got, StatusPlanToRead) {
+				t.Errorf("Status history %q", got)
+			}
+		}},
+		{"retention and processing", func(t *testing.T) {
+			m := s.GetSummary(ctx, "manga-00006")
+			m = s.UpdateRetention(ctx, m, "days:7")
+			m = s.UpdateProcessing(ctx, m, "gray")
+			got := s.GetSummary(ctx, m.MangaID)
+			if got.Retention != "days:7" || got.Processing != "gray" {
+				t.Errorf("Retention %q and processing %q", got.Retention, got.Processing)
+			}
+		}},
+		{"time modified", func(t *testing.T) {
+			m := s.UpdateTimeModified(ctx, s.GetSummary(ctx, "manga-00007"))
+			if got := s.GetSummary(ctx, m.MangaID).TimeModified; !got.Equal(m.TimeModified) {
+				t.Errorf("TimeModified %v, want %v", got, m.TimeModified)
+			}
+		}},
+		{"review", func(t *testing.T) {
+			s.insertReview(ctx, Review{MangaID: frieren.MangaID, Rating: 95, Rev: "Quietly devastating"})
+			rev := s.GetReview(ctx, frieren.MangaID)
+			if rev.Rating != 95 || s.GetSummary(ctx, frieren.MangaID).Review != rev {
+				t.Errorf("Review %+v", rev)
+			}
+		}},
+		{"alt titles", func(t *testing.T) {
+			s.insertAltTitles(ctx, "manga-00000", []string{"Alt series 0", "Another"})
+			if got := s.GetSummary(ctx, "manga-00000").AltTitles; len(got) != 2 || !slices.Contains(got, "Another") {
+				t.Errorf("Alt titles %q", got)
+			}
+		}},
+		{"collections", func(t *testing.T) {
+			c := s.AddToCollection(ctx, "Favourites", "manga-00001")
+			if again := s.AddToCollection(ctx, "Favourites", frieren.MangaID); again != c {
+				t.Errorf("Adding to the same collection gave %v, want %v", again, c)
+			}
+			if !slices.Contains(s.GetCollections(ctx), c) {
+				t.Errorf("Collections %v don't include %v", s.GetCollections(ctx), c)
+			}
+			if got := s.GetCollectionManga(ctx, c); len(got) != 2 {
+				t.Errorf("Collection has %d series, want 2", len(got))
+			}
+			s.RemoveFromCollection(ctx, c, "manga-00001")
+			got := s.GetCollectionManga(ctx, c)
+			if len(got) != 1 || got[0].MangaID != frieren.MangaID {
+				t.Errorf("After removing, collection has %v", got)
+			}
+			if !slices.Contains(s.GetCollections(ctx), c) {
+				t.Error("Collection removed along with a series")
+			}
+		}},
+		{"filters", func(t *testing.T) {
+			f, err := ParseFilter(`tag:"Slice of Life" -title:frieren`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range s.FilterManga(ctx, f) {
+				got = append(got, m.MangaID)
+			}
+			slices.Sort(got)
+			if want := []string{"manga-00002", "manga-00005"}; !slices.Equal(got, want) {
+				t.Errorf("Filter matched %q, want %q", got, want)
+			}
+
+			got = got[:0]
+			for _, m := range s.FilterManga(ctx, TagFilter("slice of life")) {
+				got = append(got, m.MangaID)
+			}
+			slices.Sort(got)
+			if want := []string{"manga-00002", "manga-00005"}; !slices.Equal(got, want) {
+				t.Errorf("TagFilter matched %q, want %q", got, want)
+			}
+
+			s.SaveFilter(ctx, "slice", f)
+			if saved := s.GetSavedFilters(ctx); !slices.Contains(saved, SavedFilter{"slice", f.Expr}) {
+				t.Errorf("Saved filters %v", saved)
+			}
+		}},
+		{"search", func(t *testing.T) {
+			for _, q := range []string{"sousou", "Frieren", "elf mage"} {
+				found := s.Search(ctx, q)
+				if len(found) != 1 || found[0].MangaID != frieren.MangaID {
+					t.Errorf("Search for %q found %v", q, found)
+				}
+			}
+			if found := s.Search(ctx, "   "); len(found) != 0 {
+				t.Errorf("Empty search found %d series", len(found))
+			}
+		}},
+		{"history", func(t *testing.T) {
+			c := s.GetChapters(ctx, frieren.MangaID)[0]
+			start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			s.insertReadEvent(ctx, ReadEvent{ChapterHash: c.ChapterHash, Start: start, End: start.Add(time.Minute), PagesViewed: 4})
+			history := s.GetSeriesHistory(ctx, frieren.MangaID)
+			if len(history) != 1 {
+				t.Fatalf("Series history %v, want 1 session", history)
+			}
+			e := history[0]
+			if !e.Start.Equal(start) || e.PagesViewed != 4 || e.MangaID != frieren.MangaID || e.FullTitle != frieren.FullTitle {
+				t.Errorf("Session %+v", e)
+			}
+			if all := s.GetHistory(ctx, 100); !slices.ContainsFunc(all, func(o ReadEvent) bool { return o.EventID == e.EventID }) {
+				t.Error("Session missing from the history")
+			}
+		}},
+		{"transaction", func(t *testing.T) {
+			s.transaction(ctx, func(tx Store) {
+				tx.insertManga(ctx, testManga(300, 3))
+				if !tx.mangaExists(ctx, "manga-00300") {
+					t.Error("Series not visible within its transaction")
+				}
+			})
+			if got := s.GetChapters(ctx, "manga-00300"); len(got) != 3 {
+				t.Errorf("After the transaction, series has %d chapters, want 3", len(got))
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, tt.run)
+	}
+}
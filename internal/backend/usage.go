@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// Count the pages in a chapter's folder, and the size of everything in it,
+// including any original pages kept by processing.
+// A missing folder has no pages.
+func measureChapter(dir string) (int, int64) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0
+	} else if err != nil {
+		log.Fatalf("%s: Failed to read %s", err, dir)
+	}
+
+	pages := 0
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			pages++
+		}
+	}
+	return pages, dirSize(dir)
+}
+
+// Record the page and byte counts of downloaded chapters that were
+// downloaded before they were kept. This runs once per database, so a
+// chapter left unmeasured because its folder is missing is for
+// CheckLibrary to report. Returns the number of chapters measured.
+func (r *SQLite) MeasureChapters(ctx context.Context, layout Layout) int {
+	v := r.userVersion(ctx)
+	if v&migratedMeasure != 0 {
+		return 0
+	}
+
+	rows, err := r.conn().QueryContext(ctx, "SELECT ChapterHash, ChapterPath FROM Chapter WHERE Downloaded = 1 AND PageCount = 0")
+	if err != nil {
+		fatalf(ctx, err, "Failed to query db for unmeasured chapters")
+	}
+	defer rows.Close()
+
+	all := make([]Chapter, 0)
+	for rows.Next() {
+		var c Chapter
+		if err := rows.Scan(&c.ChapterHash, &c.ChapterPath); err != nil {
+			fatalf(ctx, err, "Failed to parse chapter path")
+		}
+		all = append(all, c)
+	}
+	rows.Close()
+
+	tx, err := r.begin(ctx)
+	if err != nil {
+		fatalf(ctx, err, "Failed to begin transaction")
+	}
+	stmt, err := tx.PrepareContext(ctx, "UPDATE Chapter SET PageCount = ?, ByteCount = ? WHERE ChapterHash = ?")
+	if err != nil {
+		fatalf(ctx, err, "Failed to prepare transaction")
+	}
+	defer stmt.Close()
+
+	n := 0
+	for _, c := range all {
+		pages, size := measureChapter(layout.Abs(c))
+		if pages == 0 {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx, pages, size, c.ChapterHash); err != nil {
+			fatalf(ctx, err, "Failed to execute transaction on %v", c)
+		}
+		n++
+	}
+	setMigrated(ctx, tx, v, migratedMeasure)
+
+	if err := tx.Commit(); err != nil {
+		fatalf(ctx, err, "Failed to commit transaction")
+	}
+	return n
+}
+
+// The disk usage of the downloaded chapters in one volume of a series
+type VolumeUsage struct {
+	Volume   int // 0 for chapters without a volume
+	Chapters int
+	Pages    int
+	Bytes    int64
+}
+
+// Implement list.DefaultItem
+func (v VolumeUsage) FilterValue() string { return v.Title() }
+
+// Implement list.Item
+func (v VolumeUsage) Title() string {
+	if v.Volume == 0 {
+		return "No volume"
+	}
+	return fmt.Sprintf("Volume %d", v.Volume)
+}
+func (v VolumeUsage) Description() string {
+	return fmt.Sprintf("%s | %d pages | %d chapters", FormatBytes(v.Bytes), v.Pages, v.Chapters)
+}
+
+// Total the downloaded chapters of a series by volume, largest first.
+func SeriesUsage(chapters []Chapter) []VolumeUsage {
+	byVolume := make(map[int]*VolumeUsage)
+	for _, c := range chapters {
+		if !c.Downloaded {
+			continue
+		}
+		v, ok := byVolume[c.VolumeNum]
+		if !ok {
+			v = &VolumeUsage{Volume: c.VolumeNum}
+			byVolume[c.VolumeNum] = v
+		}
+		v.Chapters++
+		v.Pages += c.PageCount
+		v.Bytes += c.ByteCount
+	}
+
+	usage := make([]VolumeUsage, 0, len(byVolume))
+	for _, v := range byVolume {
+		usage = append(usage, *v)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		return usage[i].Volume < usage[j].Volume
+	})
+	return usage
+}
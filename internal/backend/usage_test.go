@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMeasureChapters(t *testing.T) {
+	ctx := context.Background()
+	layout, err := NewLayout(t.TempDir(), DefaultChapterTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chapter 1 is on disk, 2 is missing, and 3 was measured when it was downloaded
+	r := testDB(t)
+	m := testManga(1, 6)
+	m.Chapters[0].PageCount, m.Chapters[0].ByteCount = 0, 0
+	m.Chapters[1].PageCount, m.Chapters[1].ByteCount = 0, 0
+	r.insertManga(ctx, m)
+	dir := layout.Abs(m.Chapters[0])
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1.jpg", "2.jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("page"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := r.MeasureChapters(ctx, layout); n != 1 {
+		t.Errorf("Measured %d chapters, want 1", n)
+	}
+	chapters := r.GetChapters(ctx, m.MangaID)
+	if c := chapters[0]; c.PageCount != 2 || c.ByteCount != 8 {
+		t.Errorf("First chapter measured %d pages, %d bytes, want 2 and 8", c.PageCount, c.ByteCount)
+	}
+	if c := chapters[1]; c.PageCount != 0 || !c.Downloaded {
+		t.Errorf("Missing chapter left with %d pages, downloaded %t, want 0 and true", c.PageCount, c.Downloaded)
+	}
+	if c := chapters[2]; c.PageCount != 20 {
+		t.Errorf("Measured chapter changed to %d pages", c.PageCount)
+	}
+
+	// Only the first run measures anything
+	if err := os.MkdirAll(layout.Abs(m.Chapters[1]), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(layout.Abs(m.Chapters[1]), "1.jpg"), []byte("page"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.MeasureChapters(ctx, layout); n != 0 {
+		t.Errorf("Second run measured %d chapters, want 0", n)
+	}
+}
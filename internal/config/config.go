@@ -0,0 +1,291 @@
+// Package config loads the settings of the program.
+//
+// Settings start from their defaults, then come from a JSON file, then from
+// environment variables, and finally from command-line flags, each overriding the last.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every setting of the program.
+type Config struct {
+	DBPath           string   `json:"dbPath"`
+	LogPath          string   `json:"logPath"`
+	LibraryRoot      string   `json:"libraryRoot"`
+	ChapterPath      string   `json:"chapterPath"`  // The chapter path template, empty for the default
+	ExportDir        string   `json:"exportDir"`    // Where exported books are written
+	ExportFormat     string   `json:"exportFormat"` // The format the TUI starts out exporting books in
+	Reader           []string `json:"reader"`       // An imv-compatible command and its arguments
+	Languages        []string `json:"languages"`    // Translation languages to fetch chapters in
+	DownloadInterval Duration `json:"downloadInterval"`
+	Retention        string   `json:"retention"`  // The default cleanup policy for read chapters
+	Processing       string   `json:"processing"` // How downloaded pages are processed by default
+	ListWidth        int      `json:"listWidth"`
+	ListHeight       int      `json:"listHeight"`
+	HistoryLength    int      `json:"historyLength"` // How many reading sessions the history view shows
+	ServeAddr        string   `json:"serveAddr"`     // The address the servers listen on
+}
+
+// A time.Duration written like "350ms" in the config file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// The settings used when nothing overrides them
+func Default() Config {
+	root, exports := "media/manga", "media/manga-export"
+	if home, err := os.UserHomeDir(); err == nil {
+		root, exports = filepath.Join(home, root), filepath.Join(home, exports)
+	}
+
+	return Config{
+		DBPath:           "manga.sqlite3",
+		LogPath:          "debug.log",
+		LibraryRoot:      root,
+		ExportDir:        exports,
+		ExportFormat:     "cbz",
+		Reader:           []string{"imv", "-f", "-d", "-r"},
+		Languages:        []string{"en"},
+		DownloadInterval: Duration(350 * time.Millisecond),
+		Retention:        "all",
+		Processing:       "none",
+		ListWidth:        80,
+		ListHeight:       25,
+		HistoryLength:    200,
+		ServeAddr:        "127.0.0.1:8780",
+	}
+}
+
+// A setting that can be overridden by an environment variable and a flag
+type override struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var overrides = []override{
+	{"GOMANGATOOL_DB", "db", "path of the library database",
+		func(c *Config, v string) error { c.DBPath = v; return nil }},
+	{"GOMANGATOOL_LOG", "log", "path of the debug log",
+		func(c *Config, v string) error { c.LogPath = v; return nil }},
+	{"GOMANGATOOL_ROOT", "root", "folder to download chapters under",
+		func(c *Config, v string) error { c.LibraryRoot = v; return nil }},
+	{"GOMANGATOOL_CHAPTER_PATH", "chapter-path", "template for the folder of each chapter, inside the root",
+		func(c *Config, v string) error { c.ChapterPath = v; return nil }},
+	{"GOMANGATOOL_EXPORT_DIR", "export-dir", "folder to write exported books to",
+		func(c *Config, v string) error { c.ExportDir = v; return nil }},
+	{"GOMANGATOOL_EXPORT_FORMAT", "export-format", "format the TUI exports books in first: cbz, epub or pdf",
+		func(c *Config, v string) error { c.ExportFormat = v; return nil }},
+	{"GOMANGATOOL_READER", "reader", "imv-compatible reader command, split on spaces",
+		func(c *Config, v string) error { c.Reader = strings.Fields(v); return nil }},
+	{"GOMANGATOOL_LANGUAGES", "languages", "comma-separated translation languages to fetch",
+		func(c *Config, v string) error { c.Languages = splitList(v); return nil }},
+	{"GOMANGATOOL_DOWNLOAD_INTERVAL", "download-interval", "minimum time between page downloads, like 350ms",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			c.DownloadInterval = Duration(d)
+			return err
+		}},
+	{"GOMANGATOOL_RETENTION", "retention", "when to delete the pages of read chapters: all, days:N or last:N",
+		func(c *Config, v string) error { c.Retention = v; return nil }},
+	{"GOMANGATOOL_PROCESSING", "processing", "how to process downloaded pages: none, or a list of jpeg, png, height:N, gray, quality:N and keep",
+		func(c *Config, v string) error { c.Processing = v; return nil }},
+	{"GOMANGATOOL_LIST_WIDTH", "list-width", "width of the lists in the TUI",
+		func(c *Config, v string) (err error) { c.ListWidth, err = strconv.Atoi(v); return }},
+	{"GOMANGATOOL_LIST_HEIGHT", "list-height", "height of the lists in the TUI",
+		func(c *Config, v string) (err error) { c.ListHeight, err = strconv.Atoi(v); return }},
+	{"GOMANGATOOL_HISTORY_LENGTH", "history-length", "number of reading sessions the history shows",
+		func(c *Config, v string) (err error) { c.HistoryLength, err = strconv.Atoi(v); return }},
+	{"GOMANGATOOL_SERVE_ADDR", "serve-addr", "host:port the OPDS and web servers listen on",
+		func(c *Config, v string) error { c.ServeAddr = v; return nil }},
+}
+
+// Split a comma-separated list, dropping empty items
+func splitList(v string) []string {
+	items := make([]string, 0)
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// Load the settings, parsing the flags at the start of args.
+// Returns the settings and whatever follows the flags.
+//
+// The file is the one given by -config or GOMANGATOOL_CONFIG, or else
+// gomangatool/config.json in the first XDG config directory that has one.
+// It's fine for there to be no file at all.
+func Load(args []string) (Config, []string, error) {
+	fs := flag.NewFlagSet("gomangatool", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("GOMANGATOOL_CONFIG"), "path of the config file (env GOMANGATOOL_CONFIG)")
+
+	// Flags are applied last, so hold onto them until the file and environment are read
+	type flagValue struct {
+		o override
+		v string
+	}
+	flags := make([]flagValue, 0)
+	for _, o := range overrides {
+		fs.Func(o.flag, o.usage+" (env "+o.env+")", func(v string) error {
+			flags = append(flags, flagValue{o, v})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	c := Default()
+	path, required := *file, *file != ""
+	if !required {
+		path = findFile()
+	}
+	if path != "" {
+		if err := c.readFile(path, required); err != nil {
+			return c, nil, err
+		}
+	}
+
+	for _, o := range overrides {
+		if v, ok := os.LookupEnv(o.env); ok {
+			if err := o.set(&c, v); err != nil {
+				return c, nil, fmt.Errorf("%s: %w", o.env, err)
+			}
+		}
+	}
+	for _, f := range flags {
+		if err := f.o.set(&c, f.v); err != nil {
+			return c, nil, fmt.Errorf("-%s: %w", f.o.flag, err)
+		}
+	}
+
+	return c, fs.Args(), c.Validate()
+}
+
+// Find the config file in the XDG config directories.
+// Returns an empty string if there isn't one.
+func findFile() string {
+	dirs := make([]string, 0)
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	system := os.Getenv("XDG_CONFIG_DIRS")
+	if system == "" {
+		system = "/etc/xdg"
+	}
+	dirs = append(dirs, filepath.SplitList(system)...)
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "gomangatool", "config.json")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Read settings from a JSON file over the current ones.
+// Unless required, a missing file is ignored.
+func (c *Config) readFile(path string, required bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Language codes as MangaDex writes them, like en or pt-br
+var languageRe = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// Check the settings make sense, expanding a library root or export folder that starts with ~/.
+// Every problem found is returned together.
+// The chapter path, export format, retention and processing are checked by
+// the packages that use them, when main builds their options.
+func (c *Config) Validate() error {
+	errs := make([]error, 0)
+
+	if c.DBPath == "" {
+		errs = append(errs, errors.New("dbPath can't be empty"))
+	}
+	if c.LogPath == "" {
+		errs = append(errs, errors.New("logPath can't be empty"))
+	}
+	c.LibraryRoot, c.ExportDir = expandHome(c.LibraryRoot), expandHome(c.ExportDir)
+	if !filepath.IsAbs(c.LibraryRoot) {
+		errs = append(errs, fmt.Errorf("libraryRoot %q must be an absolute path", c.LibraryRoot))
+	}
+	if c.ExportDir == "" {
+		errs = append(errs, errors.New("exportDir can't be empty"))
+	}
+	if len(c.Reader) == 0 {
+		errs = append(errs, errors.New("reader can't be empty"))
+	}
+	if len(c.Languages) == 0 {
+		errs = append(errs, errors.New("languages can't be empty"))
+	}
+	for _, l := range c.Languages {
+		if !languageRe.MatchString(l) {
+			errs = append(errs, fmt.Errorf("%q isn't a language code", l))
+		}
+	}
+	if c.DownloadInterval <= 0 {
+		errs = append(errs, errors.New("downloadInterval must be positive"))
+	}
+	if c.ListWidth <= 0 || c.ListHeight <= 0 {
+		errs = append(errs, errors.New("listWidth and listHeight must be positive"))
+	}
+	if c.HistoryLength <= 0 {
+		errs = append(errs, errors.New("historyLength must be positive"))
+	}
+	if _, _, err := net.SplitHostPort(c.ServeAddr); err != nil {
+		errs = append(errs, fmt.Errorf("serveAddr: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Replace a leading ~/ with the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
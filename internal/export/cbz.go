@@ -0,0 +1,96 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+)
+
+// A zip of the page images with a ComicInfo.xml, which most comic readers understand.
+var CBZ = Format{"cbz", "application/vnd.comicbook+zip", writeCBZ}
+
+// The metadata of a CBZ, following the ComicInfo 2.0 schema.
+// The elements have to stay in the order the schema gives them.
+type comicInfo struct {
+	XMLName         xml.Name `xml:"ComicInfo"`
+	XSI             string   `xml:"xmlns:xsi,attr"`
+	XSD             string   `xml:"xmlns:xsd,attr"`
+	Title           string   `xml:"Title,omitempty"`
+	Series          string   `xml:"Series"`
+	Number          string   `xml:"Number,omitempty"`
+	Volume          int      `xml:"Volume,omitempty"`
+	Summary         string   `xml:"Summary,omitempty"`
+	Writer          string   `xml:"Writer,omitempty"`
+	Genre           string   `xml:"Genre,omitempty"`
+	Web             string   `xml:"Web,omitempty"`
+	PageCount       int      `xml:"PageCount"`
+	LanguageISO     string   `xml:"LanguageISO,omitempty"`
+	Manga           string   `xml:"Manga"`
+	ScanInformation string   `xml:"ScanInformation,omitempty"`
+}
+
+func newComicInfo(b Book, pages []Page) comicInfo {
+	tags := make([]string, 0)
+	for _, t := range b.Manga.Tags {
+		tags = append(tags, t.TagTitle)
+	}
+
+	return comicInfo{
+		XSI:             "http://www.w3.org/2001/XMLSchema-instance",
+		XSD:             "http://www.w3.org/2001/XMLSchema",
+		Title:           b.Title(),
+		Series:          b.Manga.FullTitle,
+		Number:          b.Number(),
+		Volume:          b.Volume,
+		Summary:         b.Manga.Descr,
+		Writer:          b.Manga.Author,
+		Genre:           strings.Join(tags, ", "),
+		Web:             "https://mangadex.org/title/" + b.Manga.MangaID,
+		PageCount:       len(pages),
+		LanguageISO:     b.Language(),
+		Manga:           "YesAndRightToLeft",
+		ScanInformation: b.Groups(),
+	}
+}
+
+func writeCBZ(w io.Writer, b Book, pages []Page) error {
+	z := zip.NewWriter(w)
+
+	info, err := z.Create("ComicInfo.xml")
+	if err != nil {
+		return err
+	}
+	io.WriteString(info, xml.Header)
+	enc := xml.NewEncoder(info)
+	enc.Indent("", "  ")
+	if err := enc.Encode(newComicInfo(b, pages)); err != nil {
+		return err
+	}
+
+	for _, p := range pages {
+		// The images are already compressed, so don't bother again
+		dst, err := z.CreateHeader(&zip.FileHeader{Name: p.Name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if err := copyFile(dst, p.Path); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+// Copy the contents of a file to w
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+func TestCBZ(t *testing.T) {
+	b, pages := testBook(t)
+	var buf bytes.Buffer
+	if err := writeCBZ(&buf, b, pages); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range z.File {
+		names = append(names, f.Name)
+	}
+	want := []string{"ComicInfo.xml", "0001.png", "0002.png", "0003.png"}
+	if len(names) != len(want) {
+		t.Fatalf("Files %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("File %d is %s, want %s", i, names[i], want[i])
+		}
+	}
+	for _, f := range z.File[1:] {
+		if f.Method != zip.Store {
+			t.Errorf("%s is compressed", f.Name)
+		}
+	}
+
+	r, err := z.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(xml.Header)) {
+		t.Errorf("ComicInfo.xml doesn't start with an XML header")
+	}
+	// The namespace declarations don't unmarshal back into their fields
+	if !bytes.Contains(data, []byte(`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"`)) {
+		t.Error("ComicInfo.xml doesn't declare the xsi namespace")
+	}
+	var info comicInfo
+	if err := xml.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	wantInfo := comicInfo{
+		XMLName:         xml.Name{Local: "ComicInfo"},
+		Title:           "Volume 1",
+		Series:          "Spy & Family",
+		Number:          "1",
+		Volume:          1,
+		Summary:         "A spy, an assassin & a telepath <3",
+		Writer:          "Endou Tatsuya",
+		Genre:           "Action, Comedy",
+		Web:             "https://mangadex.org/title/manga-1",
+		PageCount:       3,
+		LanguageISO:     "en",
+		Manga:           "YesAndRightToLeft",
+		ScanInformation: "Group",
+	}
+	if info != wantInfo {
+		t.Errorf("ComicInfo is\n%+v\nwant\n%+v", info, wantInfo)
+	}
+}
+
+// The ComicInfo schema is a sequence, so readers may reject elements out of order
+func TestComicInfoOrder(t *testing.T) {
+	b, pages := testBook(t)
+	data, err := xml.Marshal(newComicInfo(b, pages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := []string{"Title", "Series", "Number", "Volume", "Summary", "Writer", "Genre", "Web",
+		"PageCount", "LanguageISO", "Manga", "ScanInformation"}
+	last := -1
+	for _, el := range order {
+		i := bytes.Index(data, []byte("<"+el+">"))
+		if i < 0 {
+			t.Errorf("No %s element", el)
+			continue
+		}
+		if i < last {
+			t.Errorf("%s is out of order", el)
+		}
+		last = i
+	}
+}
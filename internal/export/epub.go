@@ -0,0 +1,266 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A fixed-layout EPUB 3 with one page per image, read right to left,
+// which e-ink readers handle far better than loose images.
+var EPUB = Format{"epub", "application/epub+zip", writeEPUB}
+
+// The size given to pages whose image size can't be read, like webp
+var fallbackSize = image.Point{X: 1000, Y: 1500}
+
+// A page of an EPUB: its image, and the XHTML document that shows it
+type epubPage struct {
+	Page
+	ID        string
+	MediaType string
+	Width     int
+	Height    int
+	Cover     bool
+}
+
+// A table of contents entry, pointing at the first page of a chapter
+type epubChapter struct {
+	Title string
+	Page  string
+}
+
+type epubData struct {
+	Book
+	ID       string
+	Language string
+	Modified string
+	Tags     []string
+	Pages    []epubPage
+	TOC      []epubChapter
+}
+
+// A file of an EPUB made from a template
+type epubFile struct {
+	name string
+	tmpl *template.Template
+	data any
+}
+
+func writeEPUB(w io.Writer, b Book, pages []Page) error {
+	d := epubData{
+		Book:     b,
+		ID:       fmt.Sprintf("urn:mangadex:%s:%d:%s", b.Manga.MangaID, b.Kind, b.Number()),
+		Language: b.Language(),
+		Modified: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Tags:     make([]string, 0),
+		Pages:    make([]epubPage, 0),
+		TOC:      make([]epubChapter, 0),
+	}
+	if d.Language == "" {
+		d.Language = "und"
+	}
+	for _, t := range b.Manga.Tags {
+		d.Tags = append(d.Tags, t.TagTitle)
+	}
+
+	for i, p := range pages {
+		ep := epubPage{Page: p, ID: fmt.Sprintf("p%04d", i+1), Cover: i == 0}
+		ep.MediaType = mediaType(p.Name)
+		size, err := imageSize(p.Path)
+		if err != nil {
+			size = fallbackSize
+		}
+		ep.Width, ep.Height = size.X, size.Y
+		d.Pages = append(d.Pages, ep)
+
+		if i == 0 || pages[i-1].Chapter != p.Chapter {
+			d.TOC = append(d.TOC, epubChapter{b.Chapters[p.Chapter].Title(), ep.ID + ".xhtml"})
+		}
+	}
+
+	z := zip.NewWriter(w)
+	// The mimetype has to come first and be stored uncompressed
+	mt, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	io.WriteString(mt, "application/epub+zip")
+
+	files := []epubFile{
+		{"META-INF/container.xml", containerTmpl, d},
+		{"OEBPS/content.opf", opfTmpl, d},
+		{"OEBPS/nav.xhtml", navTmpl, d},
+		{"OEBPS/style.css", styleTmpl, d},
+	}
+	for _, p := range d.Pages {
+		files = append(files, epubFile{"OEBPS/" + p.ID + ".xhtml", pageTmpl, p})
+	}
+	for _, f := range files {
+		dst, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if err := f.tmpl.Execute(dst, f.data); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range d.Pages {
+		dst, err := z.CreateHeader(&zip.FileHeader{Name: "OEBPS/images/" + p.Name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if err := copyFile(dst, p.Path); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+// The media type of an image, from its file name
+func mediaType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// Read the size of an image without decoding all of it
+func imageSize(path string) (image.Point, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	return image.Point{X: cfg.Width, Y: cfg.Height}, err
+}
+
+// Escape text for XML
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func newTemplate(text string) *template.Template {
+	return template.Must(template.New("").Funcs(template.FuncMap{"x": xmlEscape}).Parse(text))
+}
+
+var containerTmpl = newTemplate(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`)
+
+var opfTmpl = newTemplate(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="{{x .Language}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{x .ID}}</dc:identifier>
+    <dc:title>{{x .Manga.FullTitle}}: {{x .Title}}</dc:title>
+    <dc:language>{{x .Language}}</dc:language>
+{{- if .Manga.Author}}
+    <dc:creator>{{x .Manga.Author}}</dc:creator>
+{{- end}}
+{{- if .Manga.Descr}}
+    <dc:description>{{x .Manga.Descr}}</dc:description>
+{{- end}}
+{{- range .Tags}}
+    <dc:subject>{{x .}}</dc:subject>
+{{- end}}
+    <dc:source>https://mangadex.org/title/{{x .Manga.MangaID}}</dc:source>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="belongs-to-collection" id="series">{{x .Manga.FullTitle}}</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+    <meta refines="#series" property="group-position">{{x .Number}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">portrait</meta>
+    <meta property="rendition:spread">landscape</meta>
+    <meta name="cover" content="img-{{(index .Pages 0).ID}}"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+{{- range .Pages}}
+    <item id="{{.ID}}" href="{{.ID}}.xhtml" media-type="application/xhtml+xml"/>
+    <item id="img-{{.ID}}" href="images/{{.Name}}" media-type="{{.MediaType}}"{{if .Cover}} properties="cover-image"{{end}}/>
+{{- end}}
+  </manifest>
+  <spine page-progression-direction="rtl">
+{{- range .Pages}}
+    <itemref idref="{{.ID}}"/>
+{{- end}}
+  </spine>
+</package>
+`)
+
+var navTmpl = newTemplate(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{x .Language}}" xml:lang="{{x .Language}}">
+<head>
+  <title>{{x .Manga.FullTitle}}: {{x .Title}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>Contents</h1>
+    <ol>
+{{- range .TOC}}
+      <li><a href="{{.Page}}">{{x .Title}}</a></li>
+{{- end}}
+    </ol>
+  </nav>
+  <nav epub:type="landmarks" hidden="">
+    <ol>
+      <li><a epub:type="cover" href="{{(index .Pages 0).ID}}.xhtml">Cover</a></li>
+    </ol>
+  </nav>
+</body>
+</html>
+`)
+
+var styleTmpl = newTemplate(`html, body {
+  margin: 0;
+  padding: 0;
+}
+img {
+  display: block;
+  width: 100%;
+  height: 100%;
+  object-fit: contain;
+}
+`)
+
+var pageTmpl = newTemplate(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>{{.ID}}</title>
+  <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <img src="images/{{.Name}}" alt=""/>
+</body>
+</html>
+`)
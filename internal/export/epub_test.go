@@ -0,0 +1,122 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// The parts of content.opf the tests check
+type opfPackage struct {
+	Metadata struct {
+		Title    string   `xml:"title"`
+		Language string   `xml:"language"`
+		Creator  string   `xml:"creator"`
+		Subjects []string `xml:"subject"`
+	} `xml:"metadata"`
+	Items []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Direction string `xml:"page-progression-direction,attr"`
+		Refs      []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+func TestEPUB(t *testing.T) {
+	b, pages := testBook(t)
+	var buf bytes.Buffer
+	if err := writeEPUB(&buf, b, pages); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+
+		// Every document has to be well-formed XML
+		if strings.HasSuffix(f.Name, ".xml") || strings.HasSuffix(f.Name, ".opf") || strings.HasSuffix(f.Name, ".xhtml") {
+			d := xml.NewDecoder(bytes.NewReader(data))
+			d.Strict, d.Entity = true, xml.HTMLEntity
+			for {
+				if _, err := d.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Errorf("%s isn't well-formed: %s", f.Name, err)
+					break
+				}
+			}
+		}
+	}
+
+	if first := z.File[0]; first.Name != "mimetype" || first.Method != zip.Store || files["mimetype"] != "application/epub+zip" {
+		t.Errorf("The first file is %s, method %d, containing %q", first.Name, first.Method, files[first.Name])
+	}
+
+	var opf opfPackage
+	if err := xml.Unmarshal([]byte(files["OEBPS/content.opf"]), &opf); err != nil {
+		t.Fatal(err)
+	}
+	md := opf.Metadata
+	if md.Title != "Spy & Family: Volume 1" || md.Language != "en" || md.Creator != "Endou Tatsuya" {
+		t.Errorf("Metadata %+v", md)
+	}
+	if strings.Join(md.Subjects, ",") != "Action,Comedy" {
+		t.Errorf("Subjects %q", md.Subjects)
+	}
+
+	// Every item of the manifest is in the book, and the cover is the first page
+	for _, item := range opf.Items {
+		if _, ok := files["OEBPS/"+item.Href]; !ok {
+			t.Errorf("Manifest item %s isn't in the book", item.Href)
+		}
+		if (item.Properties == "cover-image") != (item.Href == "images/0001.png") {
+			t.Errorf("Manifest item %s has properties %q", item.Href, item.Properties)
+		}
+	}
+	if len(opf.Items) != 2+2*len(pages) {
+		t.Errorf("Manifest has %d items, want %d", len(opf.Items), 2+2*len(pages))
+	}
+
+	if opf.Spine.Direction != "rtl" {
+		t.Errorf("Spine goes %q, want rtl", opf.Spine.Direction)
+	}
+	var spine []string
+	for _, ref := range opf.Spine.Refs {
+		spine = append(spine, ref.IDRef)
+	}
+	if got := strings.Join(spine, ","); got != "p0001,p0002,p0003" {
+		t.Errorf("Spine is %s", got)
+	}
+
+	// A table of contents entry for each chapter, at its first page
+	nav := files["OEBPS/nav.xhtml"]
+	for _, entry := range []string{`<a href="p0001.xhtml">1: Operation Strix</a>`, `<a href="p0003.xhtml">2: Secure a Wife</a>`} {
+		if !strings.Contains(nav, entry) {
+			t.Errorf("Contents missing %s", entry)
+		}
+	}
+	if !strings.Contains(files["OEBPS/p0002.xhtml"], `content="width=41, height=60"`) {
+		t.Errorf("Second page doesn't have its image's size:\n%s", files["OEBPS/p0002.xhtml"])
+	}
+}
@@ -0,0 +1,253 @@
+// Package export packs downloaded chapters into files that other readers
+// and e-reader apps can open.
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/twells46/gomangatool/internal/backend"
+)
+
+// What a Book holds
+type BookKind int
+
+const (
+	KindChapter BookKind = iota // A single chapter
+	KindVolume                  // Every chapter of a volume
+	KindRange                   // Every chapter numbered in a range
+)
+
+// A single chapter, a whole volume, or a range of chapters of a series
+// to export as one file.
+type Book struct {
+	Manga    backend.Manga
+	Chapters []backend.Chapter // In reading order
+	Volume   int               // The volume of the chapters, 0 if unknown or they differ
+	Kind     BookKind
+	First    float64 // The range of chapter numbers, for a KindRange book
+	Last     float64
+}
+
+// A book of a single chapter.
+func ChapterBook(m backend.Manga, c backend.Chapter) Book {
+	return Book{Manga: m, Chapters: []backend.Chapter{c}, Volume: c.VolumeNum, Kind: KindChapter}
+}
+
+// A book of every chapter of a volume, from the chapters of m.
+func VolumeBook(m backend.Manga, vol int) (Book, error) {
+	b := Book{Manga: m, Volume: vol, Kind: KindVolume}
+	b.Chapters = pickVersions(m.Chapters, func(c backend.Chapter) bool { return c.VolumeNum == vol })
+
+	if len(b.Chapters) == 0 {
+		return b, fmt.Errorf("%s has no chapters in volume %d", m.FullTitle, vol)
+	}
+	return b, nil
+}
+
+// A book of every numbered chapter from first to last inclusive, from the chapters of m.
+func RangeBook(m backend.Manga, first, last float64) (Book, error) {
+	b := Book{Manga: m, Kind: KindRange, First: first, Last: last}
+	b.Chapters = pickVersions(m.Chapters, func(c backend.Chapter) bool {
+		return c.Number().Kind == backend.KindNumbered && first <= c.ChapterNum && c.ChapterNum <= last
+	})
+
+	if len(b.Chapters) == 0 {
+		return b, fmt.Errorf("%s has no chapters from %g to %g", m.FullTitle, first, last)
+	}
+	b.Volume = b.Chapters[0].VolumeNum
+	for _, c := range b.Chapters {
+		if c.VolumeNum != b.Volume {
+			b.Volume = 0
+		}
+	}
+	return b, nil
+}
+
+// Pick the chapters to put in a book.
+// Where a chapter has more than one version, like translations by different
+// groups, only one is used: the first downloaded, or else the first.
+func pickVersions(chapters []backend.Chapter, keep func(backend.Chapter) bool) []backend.Chapter {
+	picked := make([]backend.Chapter, 0)
+	for _, c := range chapters {
+		if !keep(c) {
+			continue
+		}
+		i := slices.IndexFunc(picked, func(o backend.Chapter) bool { return o.SortKey == c.SortKey })
+		if i < 0 {
+			picked = append(picked, c)
+		} else if c.Downloaded && !picked[i].Downloaded {
+			picked[i] = c
+		}
+	}
+	return picked
+}
+
+// The title of the book within its series
+func (b Book) Title() string {
+	switch {
+	case b.Kind == KindVolume && b.Volume == 0:
+		return "No volume"
+	case b.Kind == KindVolume:
+		return fmt.Sprintf("Volume %d", b.Volume)
+	case b.Kind == KindRange:
+		return "Chapters " + b.Number()
+	default:
+		return b.Chapters[0].ChapterName
+	}
+}
+
+// The number of the book within its series: the volume for a whole volume,
+// otherwise the chapter number or range.
+func (b Book) Number() string {
+	switch b.Kind {
+	case KindVolume:
+		return strconv.Itoa(b.Volume)
+	case KindRange:
+		return fmt.Sprintf("%g-%g", b.First, b.Last)
+	default:
+		return b.Chapters[0].Number().String()
+	}
+}
+
+// The name of the file the book is saved as, without the extension
+func (b Book) Name() string {
+	var name string
+	if b.Kind == KindVolume {
+		name = fmt.Sprintf("%s - Vol. %02d", b.Manga.FullTitle, b.Volume)
+	} else {
+		name = fmt.Sprintf("%s - Ch. %s", b.Manga.FullTitle, b.Number())
+	}
+	return strings.NewReplacer("/", "_", "\\", "_", "\x00", "").Replace(name)
+}
+
+// The language of the book, if its chapters agree on one
+func (b Book) Language() string {
+	lang := b.Chapters[0].Language
+	for _, c := range b.Chapters[1:] {
+		if c.Language != lang {
+			return ""
+		}
+	}
+	return lang
+}
+
+// The scanlation groups of the chapters, comma-separated
+func (b Book) Groups() string {
+	groups := make([]string, 0)
+	for _, c := range b.Chapters {
+		if c.GroupName != "" && !slices.Contains(groups, c.GroupName) {
+			groups = append(groups, c.GroupName)
+		}
+	}
+	return strings.Join(groups, ", ")
+}
+
+// A page image of a Book
+type Page struct {
+	Path    string // Where the image is on disk
+	Name    string // The name of the image in the exported file, which sorts in reading order
+	Chapter int    // The index of the page's chapter in the Book
+}
+
+// List the pages of every chapter of the book in reading order.
+// Fails if a chapter isn't downloaded.
+func (b Book) Pages(layout backend.Layout) ([]Page, error) {
+	pages := make([]Page, 0)
+	for i, c := range b.Chapters {
+		entries, err := os.ReadDir(layout.Abs(c))
+		if !c.Downloaded || errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("chapter %s isn't downloaded", c.Number())
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Page names are zero-padded, so sorting by name is reading order
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			pages = append(pages, Page{
+				Path:    filepath.Join(layout.Abs(c), e.Name()),
+				Name:    fmt.Sprintf("%04d%s", len(pages)+1, strings.ToLower(filepath.Ext(e.Name()))),
+				Chapter: i,
+			})
+		}
+	}
+
+	if len(pages) == 0 {
+		return nil, errors.New("there are no pages to export")
+	}
+	return pages, nil
+}
+
+// A file format books can be exported in
+type Format struct {
+	Name      string // Also the file extension
+	MediaType string
+	write     func(w io.Writer, b Book, pages []Page) error
+}
+
+// Write the book in this format, given its pages from Prepare
+func (f Format) Write(w io.Writer, b Book, pages []Page) error {
+	return f.write(w, b, pages)
+}
+
+// Every Format, by Name
+var Formats = map[string]Format{
+	CBZ.Name:  CBZ,
+	EPUB.Name: EPUB,
+	PDF.Name:  PDF,
+}
+
+// Download any chapters of the book that aren't yet, and list its pages.
+// Returns the book with its chapters updated.
+func Prepare(ctx context.Context, store backend.Store, opts backend.Options, b Book) (Book, []Page, error) {
+	b.Chapters = backend.DownloadChapters(ctx, store, opts, slices.Clone(b.Chapters)...)
+	pages, err := b.Pages(opts.Layout)
+	return b, pages, err
+}
+
+// Download any chapters of the book that aren't yet, then write it to dir.
+// Returns the path of the file written.
+func Save(ctx context.Context, store backend.Store, opts backend.Options, f Format, b Book, dir string) (string, error) {
+	b, pages, err := Prepare(ctx, store, opts, b)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first, so a failed export doesn't leave half a book behind
+	tmp, err := os.CreateTemp(dir, ".export-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := f.Write(tmp, b, pages); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, b.Name()+"."+f.Name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
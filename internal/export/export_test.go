@@ -0,0 +1,87 @@
+package export
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/twells46/gomangatool/internal/backend"
+)
+
+// A book of two downloaded chapters, with two and one small PNG pages
+func testBook(t *testing.T) (Book, []Page) {
+	t.Helper()
+	layout, err := backend.NewLayout(t.TempDir(), backend.DefaultChapterTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := backend.Manga{
+		MangaID:   "manga-1",
+		SerTitle:  "spy",
+		FullTitle: "Spy & Family",
+		Descr:     "A spy, an assassin & a telepath <3",
+		Author:    "Endou Tatsuya",
+		Tags:      []backend.Tag{{TagID: 1, TagTitle: "Action"}, {TagID: 2, TagTitle: "Comedy"}},
+	}
+	b := Book{Manga: m, Volume: 1, Kind: KindVolume}
+	for i, name := range []string{"Operation Strix", "Secure a Wife"} {
+		c := backend.Chapter{
+			ChapterHash: fmt.Sprintf("hash-%d", i+1),
+			ChapterNum:  float64(i + 1),
+			ChapterRaw:  []string{"1", "2"}[i],
+			ChapterName: name,
+			VolumeNum:   1,
+			MangaID:     m.MangaID,
+			Downloaded:  true,
+			Language:    "en",
+			GroupName:   "Group",
+		}
+		c.ChapterPath = layout.ChapterPath(m, c)
+		dir := layout.Abs(c)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for p := range 2 - i {
+			writePNG(t, filepath.Join(dir, []string{"1.png", "2.png"}[p]), 40+p, 60)
+		}
+		b.Chapters = append(b.Chapters, c)
+	}
+
+	pages, err := b.Pages(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, pages
+}
+
+func writePNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	img.Set(1, 1, color.White)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPages(t *testing.T) {
+	_, pages := testBook(t)
+	want := []Page{{Name: "0001.png", Chapter: 0}, {Name: "0002.png", Chapter: 0}, {Name: "0003.png", Chapter: 1}}
+	if len(pages) != len(want) {
+		t.Fatalf("Got %d pages, want %d", len(pages), len(want))
+	}
+	for i, p := range pages {
+		if p.Name != want[i].Name || p.Chapter != want[i].Chapter {
+			t.Errorf("Page %d is %+v, want %+v", i, p, want[i])
+		}
+	}
+}
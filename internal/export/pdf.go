@@ -0,0 +1,204 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A PDF with one image per page, each page the size of its image,
+// and a bookmark for each chapter.
+var PDF = Format{"pdf", "application/pdf", writePDF}
+
+// Writes the objects of a PDF, keeping track of where each one starts
+// for the cross-reference table.
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int64
+	offsets map[int]int64
+}
+
+func (p *pdfWriter) printf(format string, a ...any) {
+	n, _ := fmt.Fprintf(p.w, format, a...)
+	p.n += int64(n)
+}
+
+// Write an object with the given number and dictionary
+func (p *pdfWriter) object(id int, dict string) {
+	p.offsets[id] = p.n
+	p.printf("%d 0 obj\n%s\nendobj\n", id, dict)
+}
+
+// Write a stream object, with extra entries for its dictionary
+func (p *pdfWriter) stream(id int, dict string, data []byte) {
+	p.offsets[id] = p.n
+	p.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", id, dict, len(data))
+	n, _ := p.w.Write(data)
+	p.n += int64(n)
+	p.printf("\nendstream\nendobj\n")
+}
+
+// A page image, ready to be written as an XObject
+type pdfImage struct {
+	width, height int
+	dict          string // The entries describing the image data
+	data          []byte
+}
+
+func writePDF(w io.Writer, b Book, pages []Page) error {
+	// The objects are numbered up front, so they can refer to each other:
+	// the catalog, the page tree, the info and the outline root come first,
+	// then three for each page, then an outline item for each chapter.
+	const catalog, tree, info, outlines = 1, 2, 3, 4
+	pageID := func(i int) int { return 5 + 3*i }
+	firstItem := pageID(len(pages))
+
+	// Each chapter's bookmark goes to its first page
+	starts := make([]int, 0)
+	for i, p := range pages {
+		if i == 0 || pages[i-1].Chapter != p.Chapter {
+			starts = append(starts, i)
+		}
+	}
+
+	p := &pdfWriter{w: bufio.NewWriter(w), offsets: make(map[int]int64)}
+	p.printf("%%PDF-1.7\n%%\xe2\xe3\xcf\xd3\n")
+
+	lang := ""
+	if b.Language() != "" {
+		lang = " /Lang " + pdfText(b.Language())
+	}
+	p.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /Outlines %d 0 R /PageMode /UseOutlines "+
+		"/ViewerPreferences << /Direction /R2L /DisplayDocTitle true >>%s >>",
+		tree, outlines, lang))
+
+	kids := make([]string, 0, len(pages))
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID(i)))
+	}
+	p.object(tree, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+
+	tags := make([]string, 0)
+	for _, t := range b.Manga.Tags {
+		tags = append(tags, t.TagTitle)
+	}
+	p.object(info, fmt.Sprintf("<< /Title %s /Author %s /Subject %s /Keywords %s /Creator (gomangatool) /CreationDate (D:%s) >>",
+		pdfText(b.Manga.FullTitle+": "+b.Title()),
+		pdfText(b.Manga.Author),
+		pdfText(b.Manga.Descr),
+		pdfText(strings.Join(tags, ", ")),
+		time.Now().UTC().Format("20060102150405Z")))
+
+	p.object(outlines, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+		firstItem, firstItem+len(starts)-1, len(starts)))
+
+	for i, pg := range pages {
+		img, err := loadPDFImage(pg.Path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pg.Path, err)
+		}
+
+		id := pageID(i)
+		p.object(id, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Contents %d 0 R "+
+			"/Resources << /XObject << /Im %d 0 R >> >> >>",
+			tree, img.width, img.height, id+1, id+2))
+		p.stream(id+1, "", []byte(fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im Do Q", img.width, img.height)))
+		p.stream(id+2, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /BitsPerComponent 8 %s",
+			img.width, img.height, img.dict), img.data)
+	}
+
+	for i, start := range starts {
+		id := firstItem + i
+		links := ""
+		if i > 0 {
+			links += fmt.Sprintf(" /Prev %d 0 R", id-1)
+		}
+		if i < len(starts)-1 {
+			links += fmt.Sprintf(" /Next %d 0 R", id+1)
+		}
+		p.object(id, fmt.Sprintf("<< /Title %s /Parent %d 0 R%s /Dest [%d 0 R /Fit] >>",
+			pdfText(b.Chapters[pages[start].Chapter].Title()), outlines, links, pageID(start)))
+	}
+
+	// The cross-reference table needs an entry for every object, in order
+	count := firstItem + len(starts)
+	xref := p.n
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", count)
+	for id := 1; id < count; id++ {
+		p.printf("%010d 00000 n \n", p.offsets[id])
+	}
+	p.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", count, catalog, info, xref)
+
+	return p.w.Flush()
+}
+
+// Load a page image for a PDF.
+// A JPEG can be included as it is, while anything else the image package
+// can decode is stored as compressed RGB, dropping transparency.
+func loadPDFImage(path string) (pdfImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pdfImage{}, err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, fmt.Errorf("%s images can't be put in a PDF: %w", filepath.Ext(path), err)
+	}
+	if format == "jpeg" {
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			return pdfImage{cfg.Width, cfg.Height, "/ColorSpace /DeviceGray /Filter /DCTDecode", data}, nil
+		case color.YCbCrModel:
+			return pdfImage{cfg.Width, cfg.Height, "/ColorSpace /DeviceRGB /Filter /DCTDecode", data}, nil
+		}
+		// CMYK JPEGs are often stored inverted, so convert them like anything else
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, err
+	}
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(rgba, bounds, src, bounds.Min, draw.Over)
+
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	row := make([]byte, 0, 3*bounds.Dx())
+	for y := 0; y < bounds.Dy(); y++ {
+		row = row[:0]
+		for x := 0; x < bounds.Dx(); x++ {
+			i := rgba.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			row = append(row, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		}
+		z.Write(row)
+	}
+	if err := z.Close(); err != nil {
+		return pdfImage{}, err
+	}
+
+	return pdfImage{bounds.Dx(), bounds.Dy(), "/ColorSpace /DeviceRGB /Filter /FlateDecode", buf.Bytes()}, nil
+}
+
+// Encode a PDF text string as UTF-16, so that any title can be written
+func pdfText(s string) string {
+	var sb strings.Builder
+	sb.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&sb, "%04X", u)
+	}
+	sb.WriteString(">")
+	return sb.String()
+}
@@ -0,0 +1,75 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestPDFText(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", "<FEFF>"},
+		{"A", "<FEFF0041>"},
+		{"(a)\\", "<FEFF002800610029005C>"},
+		{"é", "<FEFF00E9>"},
+		{"漫画", "<FEFF6F2B753B>"},
+		{"😀", "<FEFFD83DDE00>"},
+	}
+	for _, tt := range tests {
+		if got := pdfText(tt.s); got != tt.want {
+			t.Errorf("pdfText(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+// Readers find objects through the cross-reference table, so its offsets have to be exact
+func TestPDFXref(t *testing.T) {
+	b, pages := testBook(t)
+	var buf bytes.Buffer
+	if err := writePDF(&buf, b, pages); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.Bytes()
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("No startxref at the end")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+
+	table := regexp.MustCompile(`^xref\n0 (\d+)\n0000000000 65535 f \n((?:\d{10} 00000 n \n)*)trailer`).FindSubmatch(pdf[xref:])
+	if table == nil {
+		t.Fatal("Malformed xref table")
+	}
+	count, _ := strconv.Atoi(string(table[1]))
+	// The catalog, page tree, info and outline root, three objects a page and a bookmark a chapter
+	if want := 4 + 3*len(pages) + len(b.Chapters) + 1; count != want {
+		t.Errorf("xref has %d entries, want %d", count, want)
+	}
+	entries := bytes.Split(bytes.TrimSuffix(table[2], []byte("\n")), []byte("\n"))
+	if len(entries) != count-1 {
+		t.Fatalf("xref lists %d objects, but says %d", len(entries), count-1)
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[:10]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(pdf[offset:], []byte(want)) {
+			t.Errorf("Object %d is listed at %d, which has %q", i+1, offset, pdf[offset:min(offset+len(want), len(pdf))])
+		}
+	}
+
+	if !bytes.Contains(pdf, []byte("/Size "+strconv.Itoa(count))) {
+		t.Error("Trailer /Size doesn't match the xref table")
+	}
+	// One bookmark for each chapter
+	if got := bytes.Count(pdf, []byte("/Dest [")); got != len(b.Chapters) {
+		t.Errorf("%d bookmarks, want %d", got, len(b.Chapters))
+	}
+}
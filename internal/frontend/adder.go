@@ -1,6 +1,7 @@
 package frontend
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
 )
 
 const (
@@ -25,12 +27,13 @@ type Adder struct {
 	abbrevTitle      string
 	meta             backend.MangaMeta
 	stage            int
-	fetched          bool // For stage 1: have the title options been fetched?
-	textInputUpdated bool // For stage 2: has textInput been cleared and updated?
+	fetched          bool  // For stage 1: have the title options been fetched?
+	textInputUpdated bool  // For stage 2: has textInput been cleared and updated?
+	err              error // Why the last input was rejected
 }
 
 // Return an adder with initialized textinput and list
-func newAdder() Adder {
+func newAdder(cfg config.Config) Adder {
 	ti := textinput.New()
 	ti.Placeholder = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
 	ti.Focus()
@@ -39,7 +42,7 @@ func newAdder() Adder {
 
 	d := list.NewDefaultDelegate()
 	d.ShowDescription = false
-	l := list.New([]list.Item{}, d, 80, 20)
+	l := list.New([]list.Item{}, d, cfg.ListWidth, max(cfg.ListHeight-5, 1)) // Leave room for the input
 	l.Title = "Choose a title:"
 	return Adder{
 		textInput: ti,
@@ -50,7 +53,7 @@ func newAdder() Adder {
 // Clear the adder and return to the library
 func adderExit(m model) model {
 	m.view = library
-	m.adder = newAdder()
+	m.adder = newAdder(m.cfg)
 	return m
 }
 
@@ -92,7 +95,11 @@ func AdderUpdateIDInput(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.Type {
 		case tea.KeyEnter:
-			m.adder.mangaID = m.adder.textInput.Value()
+			id := strings.TrimSpace(m.adder.textInput.Value())
+			if m.adder.err = backend.CheckNewID(m.ctx, m.store, id); m.adder.err != nil {
+				return m, nil
+			}
+			m.adder.mangaID = id
 			m.adder.stage = chooser // Move to title choices list
 			return m, nil
 		}
@@ -124,7 +131,7 @@ func AdderUpdateChooser(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 		}
 	}
 	if !m.adder.fetched {
-		cmds = append(cmds, getTitles(m.adder))
+		cmds = append(cmds, getTitles(m.ctx, m.adder))
 	}
 
 	var cmd tea.Cmd
@@ -149,14 +156,20 @@ func AdderUpdateAbbrevInput(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 			return m, nil
 
 		case tea.KeyEnter:
-			m.adder.abbrevTitle = m.adder.textInput.Value()
-			cmds = append(cmds, adderNewManga(&m.adder, m.store))
+			abbrev := strings.TrimSpace(m.adder.textInput.Value())
+			if m.adder.err = backend.CheckNewAbbrev(m.ctx, m.store, abbrev); m.adder.err != nil {
+				return m, nil
+			}
+			m.adder.abbrevTitle = abbrev
+			cmds = append(cmds, adderNewManga(m.ctx, &m.adder, m.store))
 		}
 	}
 
 	if !m.adder.textInputUpdated {
+		// Suggest an abbreviation from the chosen title
 		m.adder.textInput.Reset()
 		m.adder.textInput.Placeholder = "abbrev_title"
+		m.adder.textInput.SetValue(backend.SlugTitle(m.adder.fullTitle))
 		m.adder.textInput.Focus()
 		m.adder.textInputUpdated = true
 	}
@@ -183,7 +196,7 @@ func AdderView(m model) string {
 
 // View function for ID input
 func AdderViewIDInput(m model) string {
-	return fmt.Sprintf("Input the ID: %s", m.adder.textInput.View())
+	return fmt.Sprintf("Input the ID: %s%s", m.adder.textInput.View(), renderAdderErr(m.adder.err))
 }
 
 // View function for title chooser
@@ -199,19 +212,27 @@ func AdderViewAbbrevInput(m model) string {
 	var view strings.Builder
 	view.WriteString(fmt.Sprintf("\nYour chosen title is:\n'%s'\n", m.adder.fullTitle))
 	if m.adder.textInputUpdated {
-		view.WriteString(fmt.Sprintf("Input the abbreviated title: %s\n\n", m.adder.textInput.View()))
+		view.WriteString(fmt.Sprintf("Input the abbreviated title: %s%s\n\n", m.adder.textInput.View(), renderAdderErr(m.adder.err)))
 	}
 	view.WriteString("To go back and choose a different title, press ctrl-leftarrow")
 	return view.String()
 }
 
+// Show why the last input was rejected, if it was
+func renderAdderErr(err error) string {
+	if err == nil {
+		return ""
+	}
+	return "\n" + titleStyle.Render(err.Error())
+}
+
 // Get the title options and store the rest of the metadata so that we
 // don't have to query the API multiple times.
 // For now it needs to take and return the whole Adder
 // since it does so many things.
-func getTitles(m Adder) tea.Cmd {
+func getTitles(ctx context.Context, m Adder) tea.Cmd {
 	return func() tea.Msg {
-		meta := backend.PullMangaMeta(m.mangaID)
+		meta := backend.PullMangaMeta(ctx, m.mangaID)
 		titleOptions := []list.Item{tOpt(meta.Data.Attributes.Title.En)}
 		for _, v := range meta.Data.Attributes.AltTitles {
 			if len(v.En) > 0 {
@@ -230,8 +251,8 @@ func getTitles(m Adder) tea.Cmd {
 	}
 }
 
-func adderNewManga(adder *Adder, store *backend.SQLite) tea.Cmd {
+func adderNewManga(ctx context.Context, adder *Adder, store backend.Store) tea.Cmd {
 	return func() tea.Msg {
-		return backend.NewManga(adder.meta, adder.fullTitle, adder.abbrevTitle, store)
+		return backend.NewManga(ctx, adder.meta, adder.fullTitle, adder.abbrevTitle, store)
 	}
 }
@@ -1,8 +1,11 @@
 package frontend
 
 import (
+	"context"
+
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
 )
 
 const (
@@ -10,6 +13,8 @@ const (
 	series
 	adder
 	review
+	history
+	usage
 )
 
 // The overall tea.Model, which contains the various sub-models
@@ -19,21 +24,29 @@ type model struct {
 	adder    Adder
 	library  Library
 	series   Series
+	history  History
+	usage    Usage
 	err      error // NOTE: Currently unused
-	store    *backend.SQLite
-	quitting bool // NOTE: Currently unused
+	store    backend.Store
+	opts     backend.Options
+	cfg      config.Config
+	ctx      context.Context // Cancelled when the program exits, stopping any downloads
+	quitting bool            // NOTE: Currently unused
 }
 
 // Initialize a new model
-func InitModel() model {
-	store := backend.Opendb("manga.sqlite3")
-
+func InitModel(ctx context.Context, store backend.Store, opts backend.Options, cfg config.Config) model {
 	return model{
 		view:    library,
-		adder:   newAdder(),
-		library: initLibrary(store),
-		series:  blankSeries(),
+		adder:   newAdder(cfg),
+		library: initLibrary(ctx, store, cfg),
+		series:  blankSeries(cfg),
+		history: blankHistory(cfg),
+		usage:   blankUsage(cfg),
 		store:   store,
+		opts:    opts,
+		cfg:     cfg,
+		ctx:     ctx,
 	}
 }
 
@@ -59,6 +72,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return AdderUpdate(msg, m)
 	case series:
 		return SeriesUpdate(msg, m)
+	case history:
+		return HistoryUpdate(msg, m)
+	case usage:
+		return UsageUpdate(msg, m)
 	}
 
 	return m, tea.Quit
@@ -73,6 +90,10 @@ func (m model) View() string {
 		return AdderView(m)
 	case series:
 		return SeriesView(m)
+	case history:
+		return HistoryView(m)
+	case usage:
+		return UsageView(m)
 	}
 
 	return "\n\nView got confused 🤮😭😨👿💔🔥💯💯💯\n\n"
@@ -0,0 +1,68 @@
+package frontend
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
+)
+
+// The components of the reading history view
+type History struct {
+	list list.Model
+	from int // The view to return to on exit
+}
+
+func blankHistory(cfg config.Config) History {
+	d := list.NewDefaultDelegate()
+	l := list.New([]list.Item{}, d, cfg.ListWidth, cfg.ListHeight)
+
+	return History{
+		list: l,
+	}
+}
+
+// Switch to the history view showing the given events.
+func historyOpen(m model, title string, events []backend.ReadEvent) model {
+	items := make([]list.Item, 0)
+	for _, e := range events {
+		items = append(items, list.Item(e))
+	}
+
+	m.history.list.SetItems(items)
+	m.history.list.Title = title
+	m.history.from = m.view
+	m.view = history
+	return m
+}
+
+// Exit the history view and return to wherever it was opened from
+func historyExit(m model) model {
+	m.view = m.history.from
+	m.history.list.SetItems([]list.Item{})
+	m.history.list.ResetFilter()
+	return m
+}
+
+// Overall History update function
+func HistoryUpdate(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.history.list.FilterState() == list.Filtering {
+			break
+		}
+		switch msg.String() {
+		case "q", "esc":
+			return historyExit(m), nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.history.list, cmd = m.history.list.Update(msg)
+	return m, cmd
+}
+
+// Overall History view function
+func HistoryView(m model) string {
+	return m.history.list.View()
+}
@@ -1,37 +1,227 @@
 package frontend
 
 import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
+)
+
+// What the library's text prompt is being used for
+const (
+	noPrompt int = iota
+	collectionPrompt
+	searchPrompt
+	filterPrompt
+	saveFilterPrompt
 )
 
 // The components of the main, library view
 type Library struct {
-	list list.Model
+	list        list.Model
+	collections []backend.Collection
+	shelf       backend.Collection // The collection being shown. The zero value is the whole library.
+	prompt      textinput.Model
+	prompting   int
+	heading     string         // The title of the list, before the totals
+	search      string         // The current search, if any
+	filter      backend.Filter // The current filter, if its Expr isn't empty
+	saved       []backend.SavedFilter
+	err         error // A problem with the last filter entered
 }
 
 // Initialize a new Library with the stored series
-func initLibrary(store *backend.SQLite) Library {
+func initLibrary(ctx context.Context, store backend.Store, cfg config.Config) Library {
+	d := list.NewDefaultDelegate()
+	list := list.New([]list.Item{}, d, cfg.ListWidth, cfg.ListHeight)
+
+	ti := textinput.New()
+	ti.CharLimit = 64
+	ti.Width = 64
+
+	l := Library{
+		list:   list,
+		prompt: ti,
+	}
+	return libraryReload(ctx, l, store)
+}
+
+// Reload the series shown in the library for the current collection.
+func libraryReload(ctx context.Context, l Library, store backend.Store) Library {
+	l.collections = store.GetCollections(ctx)
+
+	var all []backend.Manga
+	if l.shelf == (backend.Collection{}) {
+		all = store.GetAll(ctx)
+		l.heading = "Library"
+	} else {
+		all = store.GetCollectionManga(ctx, l.shelf)
+		l.heading = fmt.Sprintf("Library: %s", l.shelf.Name)
+	}
+
+	if l.filter.Expr != "" {
+		all = libraryNarrow(all, store.FilterManga(ctx, l.filter))
+		l.heading += fmt.Sprintf(" filter '%s'", l.filter.Expr)
+	}
+	if l.search != "" {
+		all = libraryNarrow(all, store.Search(ctx, l.search))
+		l.heading += fmt.Sprintf(" search '%s'", l.search)
+	}
+
 	items := make([]list.Item, 0)
-	for _, v := range store.GetAll() {
+	for _, v := range all {
 		items = append(items, list.Item(v))
 	}
-	d := list.NewDefaultDelegate()
+	l.list.ResetFilter()
+	l.list.SetItems(items)
+
+	return librarySetTitle(l)
+}
+
+// Show the total disk usage of the listed series in the title
+func librarySetTitle(l Library) Library {
+	all := make([]backend.Manga, 0)
+	for _, item := range l.list.Items() {
+		all = append(all, item.(backend.Manga))
+	}
+	bytes, pages := usageTotals(all)
+	l.list.Title = fmt.Sprintf("%s (%s, %d pages):", l.heading, backend.FormatBytes(bytes), pages)
+	return l
+}
+
+// Narrow the shown series down to those in the results of a search or filter,
+// keeping the results' order so the most relevant come first.
+func libraryNarrow(shown []backend.Manga, results []backend.Manga) []backend.Manga {
+	ids := make(map[string]bool)
+	for _, v := range shown {
+		ids[v.MangaID] = true
+	}
+	return slices.DeleteFunc(results, func(v backend.Manga) bool { return !ids[v.MangaID] })
+}
+
+// Replace a Manga in the library list, if it's shown.
+func librarySetManga(m model, manga backend.Manga) model {
+	for i, item := range m.library.list.Items() {
+		if item.(backend.Manga).MangaID == manga.MangaID {
+			m.library.list.SetItem(i, manga)
+			break
+		}
+	}
+	m.library = librarySetTitle(m.library)
+	return m
+}
 
-	list := list.New(items, d, 80, 25)
-	list.Title = "Library:"
+// Show the next (or previous, if step is negative) collection,
+// cycling through the whole library as well.
+func librarySwitchShelf(m model, step int) model {
+	// The whole library comes before the first collection
+	shelves := append([]backend.Collection{{}}, m.library.collections...)
+	i := max(slices.Index(shelves, m.library.shelf), 0)
+	m.library.shelf = shelves[(i+step+len(shelves))%len(shelves)]
+	m.library = libraryReload(m.ctx, m.library, m.store)
+	return m
+}
+
+// Open the text prompt for the given purpose
+func libraryPrompt(m model, kind int, placeholder string) (model, tea.Cmd) {
+	m.library.prompting = kind
+	m.library.prompt.Reset()
+	m.library.prompt.Placeholder = placeholder
+	return m, m.library.prompt.Focus()
+}
+
+// Update function for when the text prompt is open
+func LibraryUpdatePrompt(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyEsc:
+			m.library.prompting = noPrompt
+			m.library.prompt.Blur()
+			return m, nil
+		case tea.KeyEnter:
+			val := strings.TrimSpace(m.library.prompt.Value())
+			kind := m.library.prompting
+			m.library.prompting = noPrompt
+			m.library.prompt.Blur()
+			if val == "" {
+				return m, nil
+			}
 
-	return Library{list}
+			switch kind {
+			case filterPrompt:
+				// @name loads a saved filter
+				if name, ok := strings.CutPrefix(val, "@"); ok {
+					i := slices.IndexFunc(m.library.saved, func(f backend.SavedFilter) bool { return f.FilterName == name })
+					if i < 0 {
+						m.library.err = fmt.Errorf("no saved filter named %s", name)
+						return m, nil
+					}
+					val = m.library.saved[i].Expr
+				}
+				f, err := backend.ParseFilter(val)
+				if err != nil {
+					m.library.err = err
+					return m, nil
+				}
+				m.library.err = nil
+				m.library.filter = f
+				m.library = libraryReload(m.ctx, m.library, m.store)
+			case saveFilterPrompt:
+				m.store.SaveFilter(m.ctx, val, m.library.filter)
+			case searchPrompt:
+				m.library.search = val
+				m.library = libraryReload(m.ctx, m.library, m.store)
+			case collectionPrompt:
+				if manga, ok := m.library.list.SelectedItem().(backend.Manga); ok {
+					m.store.AddToCollection(m.ctx, val, manga.MangaID)
+					m.library = libraryReload(m.ctx, m.library, m.store)
+				}
+			}
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.library.prompt, cmd = m.library.prompt.Update(msg)
+	return m, cmd
 }
 
 // Overall Library update function
 func LibraryUpdate(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
+	if m.library.prompting != noPrompt {
+		return LibraryUpdatePrompt(msg, m)
+	}
+
 	switch msg := msg.(type) {
+	case ChapDlMsg:
+		m, _ = updateChapter(m, backend.Chapter(msg))
+	case ChapReadMsg:
+		m, _ = updateChapter(m, backend.Chapter(msg))
 	case tea.KeyMsg:
+		// Let the list have the keys while the filter is being typed
+		if m.library.list.FilterState() == list.Filtering {
+			break
+		}
 		switch msg.String() {
+		case "c":
+			// Continue reading the selected series where we left off
+			if manga, ok := m.library.list.SelectedItem().(backend.Manga); ok {
+				manga.Chapters = m.store.GetChapters(m.ctx, manga.MangaID)
+				if c, ok := manga.NextUnread(); ok {
+					return m, readChap(m.ctx, c, m.store, m.opts)
+				}
+			}
+			return m, nil
 		case "enter":
-			m.series.manga = m.library.list.SelectedItem().(backend.Manga)
+			// The library only has the summaries, so load the chapters as well
+			m.series.manga = m.store.GetByID(m.ctx, m.library.list.SelectedItem().(backend.Manga).MangaID)
 			m.view = series
 			// By calling newSeries here, the list will be loaded and rendered properly
 			// instantly
@@ -39,13 +229,53 @@ func LibraryUpdate(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 		case "a":
 			m.view = adder
 			return m, nil
+		case "u":
+			return usageOpen(m), nil
+		case "H":
+			return historyOpen(m, "Reading history:", m.store.GetHistory(m.ctx, m.cfg.HistoryLength)), nil
+		case "s":
+			return libraryPrompt(m, searchPrompt, "title, description or review")
+		case "f":
+			m.library.saved = m.store.GetSavedFilters(m.ctx)
+			return libraryPrompt(m, filterPrompt, "tag:Romance -tag:Horror demo:seinen status:completed unread>0 rating>=80")
+		case "F":
+			// Save the filter currently applied
+			if m.library.filter.Expr == "" {
+				return m, nil
+			}
+			return libraryPrompt(m, saveFilterPrompt, "name")
+		case "esc":
+			// Clear the search and filter, unless the list's own filter is what's being cleared
+			if (m.library.search != "" || m.library.filter.Expr != "") && m.library.list.FilterState() == list.Unfiltered {
+				m.library.search = ""
+				m.library.filter = backend.Filter{}
+				m.library = libraryReload(m.ctx, m.library, m.store)
+				return m, nil
+			}
+		case "tab":
+			return librarySwitchShelf(m, 1), nil
+		case "shift+tab":
+			return librarySwitchShelf(m, -1), nil
+		case "+":
+			var names []string
+			for _, c := range m.library.collections {
+				names = append(names, c.Name)
+			}
+			return libraryPrompt(m, collectionPrompt, strings.Join(names, ", "))
+		case "-":
+			// Only makes sense when looking at a collection
+			if manga, ok := m.library.list.SelectedItem().(backend.Manga); ok && m.library.shelf != (backend.Collection{}) {
+				m.store.RemoveFromCollection(m.ctx, m.library.shelf, manga.MangaID)
+				m.library = libraryReload(m.ctx, m.library, m.store)
+			}
+			return m, nil
 		case "r":
-			new := backend.RefreshFeed(m.library.list.SelectedItem().(backend.Manga), m.store)
-			m.library.list.SetItem(m.library.list.Index(), new)
+			new := backend.RefreshFeed(m.ctx, m.library.list.SelectedItem().(backend.Manga), m.store, m.opts)
+			m = librarySetManga(m, m.store.GetSummary(m.ctx, new.MangaID))
 		case "R":
-			for i, manga := range m.library.list.Items() {
-				new := backend.RefreshFeed(manga.(backend.Manga), m.store)
-				m.library.list.SetItem(i, new)
+			for _, manga := range m.library.list.Items() {
+				new := backend.RefreshFeed(m.ctx, manga.(backend.Manga), m.store, m.opts)
+				m = librarySetManga(m, m.store.GetSummary(m.ctx, new.MangaID))
 			}
 		}
 	}
@@ -57,5 +287,23 @@ func LibraryUpdate(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 
 // Overall Library view function
 func LibraryView(m model) string {
+	switch m.library.prompting {
+	case collectionPrompt:
+		return fmt.Sprintf("%s\nAdd to collection: %s", m.library.list.View(), m.library.prompt.View())
+	case searchPrompt:
+		return fmt.Sprintf("%s\nSearch: %s", m.library.list.View(), m.library.prompt.View())
+	case filterPrompt:
+		var names []string
+		for _, f := range m.library.saved {
+			names = append(names, "@"+f.FilterName)
+		}
+		return fmt.Sprintf("%s\nFilter: %s\n%s", m.library.list.View(), m.library.prompt.View(),
+			titleStyle.Render("Saved: "+strings.Join(names, " ")))
+	case saveFilterPrompt:
+		return fmt.Sprintf("%s\nSave filter '%s' as: %s", m.library.list.View(), m.library.filter.Expr, m.library.prompt.View())
+	}
+	if m.library.err != nil {
+		return fmt.Sprintf("%s\n%s", m.library.list.View(), titleStyle.Render(m.library.err.Error()))
+	}
 	return m.library.list.View()
 }
@@ -1,19 +1,39 @@
 package frontend
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os/exec"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
+	"github.com/twells46/gomangatool/internal/export"
 )
 
-type ChapDlMsg int
-type ChapReadMsg int
+type ChapDlMsg backend.Chapter
+type ChapReadMsg backend.Chapter
+
+// The result of exporting a book, with the chapters of the series
+// reloaded in case any were downloaded for it.
+type ExportMsg struct {
+	mangaID  string
+	path     string
+	err      error
+	chapters []backend.Chapter
+}
+
+// The Aggregate of a series, or why it couldn't be fetched, tagged with its
+// MangaID in case a different series has been opened by the time it arrives.
+type AggregateMsg struct {
+	mangaID string
+	agg     backend.Aggregate
+	err     error
+}
 
 var (
 	titleStyle = lipgloss.NewStyle().
@@ -31,40 +51,60 @@ var (
 
 // The components to view an individual series
 type Series struct {
-	manga  backend.Manga
-	list   list.Model
-	copied bool
+	manga    backend.Manga
+	list     list.Model
+	copied   bool
+	statuses []backend.StatusChange // The ReadStatus history of manga, most recent first
+	agg      backend.Aggregate      // What MangaDex lists for manga, nil until refreshed
+	message  string                 // The outcome of the last export or refresh, if any
+	format   string                 // The export.Format books are exported in
+	mark     *backend.Chapter       // The first chapter of a range to export, if one is being picked
 }
 
-func blankSeries() Series {
+func blankSeries(cfg config.Config) Series {
 	d := list.NewDefaultDelegate()
 	//d.ShowDescription = false
 	//d := NewSeriesDelegate()
-	l := list.New([]list.Item{}, d, 80, 25)
+	l := list.New([]list.Item{}, d, cfg.ListWidth, cfg.ListHeight)
 
 	return Series{
-		list: l,
+		list:   l,
+		format: cfg.ExportFormat,
 	}
 }
 
-// Returns the model with a correctly set list.
+// Returns the model with a correctly set list,
+// with a placeholder before each chapter that follows missing ones.
 func seriesRefreshList(m model) model {
+	gaps := backend.FindGaps(m.series.manga.Chapters, m.series.agg)
 	items := make([]list.Item, 0)
 	for _, chapter := range m.series.manga.Chapters {
+		// Chapters are in SortKey order, so gaps go by the key of their last chapter
+		for len(gaps) > 0 && gaps[0].Last.Key() < chapter.SortKey {
+			items = append(items, list.Item(gaps[0]))
+			gaps = gaps[1:]
+		}
 		items = append(items, list.Item(chapter))
 	}
+	for _, g := range gaps {
+		items = append(items, list.Item(g))
+	}
 
 	m.series.list.SetItems(items)
 	m.series.list.Title = m.series.manga.FullTitle
 	m.series.copied = true
+	m.series.statuses = m.store.GetStatusHistory(m.ctx, m.series.manga.MangaID)
 
 	return m
 }
 
 // Exit the series view and return to the Library
 func seriesExit(m model) model {
-	m.library.list.SetItem(m.library.list.Index(), m.series.manga)
+	m = librarySetManga(m, m.store.GetSummary(m.ctx, m.series.manga.MangaID))
 	m.series.copied = false
+	m.series.agg = nil
+	m.series.message = ""
+	m.series.mark = nil
 	m.view = library
 	m.series.list.SetItems([]list.Item{})
 	return m
@@ -75,34 +115,126 @@ func SeriesUpdate(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 	cmds := make([]tea.Cmd, 0)
 	switch msg := msg.(type) {
 	case ChapDlMsg:
-		m.series.manga.Chapters[msg].Downloaded = true
-		tmp := m.series.list.Items()[msg].(backend.Chapter)
-		tmp.Downloaded = true
-		cmds = append(cmds, m.series.list.SetItem(int(msg), tmp))
+		var cmd tea.Cmd
+		m, cmd = updateChapter(m, backend.Chapter(msg))
+		cmds = append(cmds, cmd)
 		m.series.list.StopSpinner()
 	case ChapReadMsg:
-		m.series.manga.Chapters[msg].IsRead = true
-		tmp := m.series.list.Items()[msg].(backend.Chapter)
-		tmp.IsRead = true
-		cmds = append(cmds, m.series.list.SetItem(int(msg), tmp))
+		var cmd tea.Cmd
+		m, cmd = updateChapter(m, backend.Chapter(msg))
+		cmds = append(cmds, cmd)
 	case backend.Manga:
+		// Refreshing may have added chapters, so the totals need updating
+		msg.Summary = m.store.GetSummary(m.ctx, msg.MangaID).Summary
 		m.series.manga = msg
 		m.series.list.StopSpinner()
 		return seriesRefreshList(m), nil
+	case AggregateMsg:
+		if msg.mangaID != m.series.manga.MangaID {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.series.message = "Couldn't check for missing chapters: " + msg.err.Error()
+			return m, nil
+		}
+		m.series.agg = msg.agg
+		return seriesRefreshList(m), nil
+	case ExportMsg:
+		if msg.mangaID != m.series.manga.MangaID {
+			return m, nil
+		}
+		m.series.list.StopSpinner()
+		if msg.err != nil {
+			m.series.message = "Export failed: " + msg.err.Error()
+			return m, nil
+		}
+		m.series.message = "Exported to " + msg.path
+		m.series.manga.Chapters = msg.chapters
+		m.series.manga.Summary = m.store.GetSummary(m.ctx, m.series.manga.MangaID).Summary
+		return seriesRefreshList(m), nil
 
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "q", "esc":
 			return seriesExit(m), nil
+		case "s":
+			// Cycle through the reading statuses
+			i := slices.Index(backend.ReadStatuses, m.series.manga.ReadStatus)
+			next := backend.ReadStatuses[(i+1)%len(backend.ReadStatuses)]
+			return seriesSetStatus(m, next), nil
+		case "S":
+			if status, ok := m.series.manga.SuggestedStatus(); ok {
+				return seriesSetStatus(m, status), nil
+			}
+			return m, nil
+		case "c":
+			// Cycle through the cleanup policies, starting from the default
+			policies := append([]string{""}, backend.RetentionPresets...)
+			next := policies[(slices.Index(policies, m.series.manga.Retention)+1)%len(policies)]
+			m.series.manga = m.store.UpdateRetention(m.ctx, m.series.manga, next)
+			return m, nil
+		case "p":
+			// Cycle through the page processing presets, starting from the default
+			presets := append([]string{""}, backend.ProcessingPresets...)
+			next := presets[(slices.Index(presets, m.series.manga.Processing)+1)%len(presets)]
+			m.series.manga = m.store.UpdateProcessing(m.ctx, m.series.manga, next)
+			return m, nil
+		case "H":
+			title := "Reading history: " + m.series.manga.FullTitle
+			return historyOpen(m, title, m.store.GetSeriesHistory(m.ctx, m.series.manga.MangaID)), nil
 		case "r":
+			m.series.message = ""
 			cmds = append(cmds, m.series.list.StartSpinner())
-			cmds = append(cmds, refresh(m.series.manga, m.store))
+			cmds = append(cmds, refresh(m.ctx, m.series.manga, m.store, m.opts))
+			cmds = append(cmds, getAggregate(m.ctx, m.series.manga.MangaID))
 		case "d":
-			cmds = append(cmds, m.series.list.StartSpinner())
-			cmds = append(cmds, dlChap(m.series.list.SelectedItem().(backend.Chapter), m.series.list.Index(), m.store))
+			// Gaps in the list have nothing to download
+			if c, ok := m.series.list.SelectedItem().(backend.Chapter); ok {
+				cmds = append(cmds, m.series.list.StartSpinner())
+				cmds = append(cmds, dlChap(m.ctx, c, m.store, m.opts))
+			}
 			return m, tea.Batch(cmds...) // prevent 'd' from being handled by the list
+		case "f":
+			// Cycle through the export formats
+			names := slices.Sorted(maps.Keys(export.Formats))
+			m.series.format = names[(slices.Index(names, m.series.format)+1)%len(names)]
+			return m, nil
+		case "v":
+			// Start picking a range to export, or stop
+			c, ok := m.series.list.SelectedItem().(backend.Chapter)
+			if m.series.mark != nil || !ok {
+				m.series.mark = nil
+			} else {
+				m.series.mark = &c
+			}
+			return m, nil
+		case "x", "X":
+			// x exports the selected chapter, or the range from the marked one to it,
+			// and X the whole volume of the selected chapter
+			c, ok := m.series.list.SelectedItem().(backend.Chapter)
+			if !ok {
+				return m, nil
+			}
+			b := export.ChapterBook(m.series.manga, c)
+			if msg.String() == "X" {
+				b, _ = export.VolumeBook(m.series.manga, c.VolumeNum) // c is in the volume, so it can't fail
+			} else if m.series.mark != nil {
+				var err error
+				first, last := min(m.series.mark.ChapterNum, c.ChapterNum), max(m.series.mark.ChapterNum, c.ChapterNum)
+				if b, err = export.RangeBook(m.series.manga, first, last); err != nil {
+					m.series.message = "Export failed: " + err.Error()
+					return m, nil
+				}
+			}
+			m.series.mark = nil
+			m.series.message = "Exporting " + b.Name()
+			cmds = append(cmds, m.series.list.StartSpinner())
+			cmds = append(cmds, exportBook(m.ctx, b, m.store, m.opts, export.Formats[m.series.format], m.cfg.ExportDir))
+			return m, tea.Batch(cmds...)
 		case "enter":
-			cmds = append(cmds, readChap(m.series.list.SelectedItem().(backend.Chapter), m.series.list.Index(), m.store))
+			if c, ok := m.series.list.SelectedItem().(backend.Chapter); ok {
+				cmds = append(cmds, readChap(m.ctx, c, m.store, m.opts))
+			}
 		}
 	}
 
@@ -112,40 +244,110 @@ func SeriesUpdate(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
-func refresh(manga backend.Manga, store *backend.SQLite) tea.Cmd {
+// Set the ReadStatus of the open series
+func seriesSetStatus(m model, status string) model {
+	m.series.manga = m.store.UpdateReadStatus(m.ctx, m.series.manga, status)
+	m.series.statuses = m.store.GetStatusHistory(m.ctx, m.series.manga.MangaID)
+	return m
+}
+
+func refresh(ctx context.Context, manga backend.Manga, store backend.Store, opts backend.Options) tea.Cmd {
 	return func() tea.Msg {
-		return backend.RefreshFeed(manga, store)
+		return backend.RefreshFeed(ctx, manga, store, opts)
 	}
 }
 
-func dlChap(chapter backend.Chapter, idx int, store *backend.SQLite) tea.Cmd {
+func getAggregate(ctx context.Context, mangaID string) tea.Cmd {
 	return func() tea.Msg {
-		backend.DownloadChapters(store, chapter)
-		return ChapDlMsg(idx)
+		agg, err := backend.GetAggregate(ctx, mangaID)
+		return AggregateMsg{mangaID, agg, err}
 	}
 }
 
-func readChap(c backend.Chapter, idx int, store *backend.SQLite) tea.Cmd {
+func dlChap(ctx context.Context, chapter backend.Chapter, store backend.Store, opts backend.Options) tea.Cmd {
 	return func() tea.Msg {
-		readCmd := exec.Command("imv", "-f", "-d", "-r", c.ChapterPath)
-		if err := readCmd.Run(); err != nil {
-			log.Println(err)
+		return ChapDlMsg(backend.DownloadChapters(ctx, store, opts, chapter)[0])
+	}
+}
+
+func readChap(ctx context.Context, c backend.Chapter, store backend.Store, opts backend.Options) tea.Cmd {
+	return func() tea.Msg {
+		return ChapReadMsg(backend.ReadChapter(ctx, c, store, opts))
+	}
+}
+
+func exportBook(ctx context.Context, b export.Book, store backend.Store, opts backend.Options, f export.Format, dir string) tea.Cmd {
+	return func() tea.Msg {
+		path, err := export.Save(ctx, store, opts, f, b, dir)
+		return ExportMsg{b.Manga.MangaID, path, err, store.GetChapters(ctx, b.Manga.MangaID)}
+	}
+}
+
+// Replace a chapter everywhere it is displayed:
+// in its Manga in the library, and in the series view if it's open.
+func updateChapter(m model, c backend.Chapter) (model, tea.Cmd) {
+	summary := m.store.GetSummary(m.ctx, c.MangaID)
+	m = librarySetManga(m, summary)
+
+	if m.series.manga.MangaID != c.MangaID {
+		return m, nil
+	}
+	m.series.manga.Summary = summary.Summary
+	if j := slices.IndexFunc(m.series.manga.Chapters, func(o backend.Chapter) bool { return o.ChapterHash == c.ChapterHash }); j >= 0 {
+		m.series.manga.Chapters[j] = c
+	}
+	for i, item := range m.series.list.Items() {
+		if o, ok := item.(backend.Chapter); ok && o.ChapterHash == c.ChapterHash {
+			return m, m.series.list.SetItem(i, c)
 		}
-		store.UpdateChapterRead(c)
-		return ChapReadMsg(idx)
 	}
+	return m, nil
 }
 
 // Overall Series view function
 func SeriesView(m model) string {
-	info := fmt.Sprintf("%s\n%s%s",
+	info := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s%s",
 		wrapStyle.Render(renderTags(m.series.manga.Tags)),
+		wrapStyle.Render(renderStatus(m.series.manga, m.series.statuses)),
+		wrapStyle.Render(boldStyle.Render("Progress:\n")+m.series.manga.Progress().String()),
+		wrapStyle.Render(renderRetention(m.series.manga, m.opts.Retention)),
+		wrapStyle.Render(renderProcessing(m.series.manga, m.opts.Processing)),
 		boldStyle.Render("Description:\n"),
 		wrapStyle.Render(m.series.manga.Descr))
+	info += "\n" + wrapStyle.Render(renderExport(m.series))
 
 	return lipgloss.JoinHorizontal(lipgloss.Left, m.series.list.View(), info)
 }
 
+func renderRetention(manga backend.Manga, def backend.Retention) string {
+	policy := manga.RetentionPolicy(def).String()
+	if manga.Retention == "" {
+		policy += titleStyle.Render("(default)")
+	}
+	return boldStyle.Render("Cleanup:\n") + policy
+}
+
+func renderProcessing(manga backend.Manga, def backend.Processing) string {
+	processing := manga.ProcessingPolicy(def).String()
+	if manga.Processing == "" {
+		processing += titleStyle.Render("(default)")
+	}
+	return boldStyle.Render("Page processing:\n") + processing
+}
+
+func renderExport(s Series) string {
+	var sb strings.Builder
+	sb.WriteString(boldStyle.Render("Export:\n"))
+	sb.WriteString(s.format)
+	if s.mark != nil {
+		sb.WriteString(titleStyle.Render(fmt.Sprintf("Range from %s (press x on the last chapter)", s.mark.Number())))
+	}
+	if s.message != "" {
+		sb.WriteString("\n" + titleStyle.Render(s.message))
+	}
+	return sb.String()
+}
+
 func renderTags(tags []backend.Tag) string {
 	tagStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.AdaptiveColor{Light: "#EE6FF8", Dark: "#EE6FF8"}).
@@ -158,3 +360,28 @@ func renderTags(tags []backend.Tag) string {
 
 	return fmt.Sprintf("%s%s", boldStyle.Render("Tags:\n"), sb.String())
 }
+
+// How many past status changes to show in the series view
+const statusHistoryLen = 3
+
+func renderStatus(manga backend.Manga, statuses []backend.StatusChange) string {
+	var sb strings.Builder
+	sb.WriteString(boldStyle.Render("Status:\n"))
+	if manga.ReadStatus == "" {
+		sb.WriteString("Not set")
+	} else {
+		sb.WriteString(manga.ReadStatus)
+	}
+	if status, ok := manga.SuggestedStatus(); ok {
+		sb.WriteString(titleStyle.Render(fmt.Sprintf("Suggested: %s (press S to accept)", status)))
+	}
+
+	for i, c := range statuses {
+		if i >= statusHistoryLen {
+			break
+		}
+		sb.WriteString("\n" + titleStyle.Render(fmt.Sprintf("%s: %s", c.TimeChanged.Local().Format("2006-01-02"), c.ReadStatus)))
+	}
+
+	return sb.String()
+}
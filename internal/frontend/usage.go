@@ -0,0 +1,127 @@
+package frontend
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
+)
+
+// The components of the disk usage view, which lists the series by size
+// and can drill down into the volumes of one
+type Usage struct {
+	list     list.Model
+	selected int  // Where the series list was when a series was opened
+	volumes  bool // Showing the volumes of a series rather than the series
+}
+
+// A series in the disk usage view
+type usageItem struct {
+	backend.Manga
+}
+
+func (u usageItem) Description() string {
+	return fmt.Sprintf("%s | %d pages | %d chapters downloaded",
+		backend.FormatBytes(u.Summary.Bytes), u.Summary.Pages, u.Summary.Downloaded)
+}
+
+func blankUsage(cfg config.Config) Usage {
+	d := list.NewDefaultDelegate()
+	l := list.New([]list.Item{}, d, cfg.ListWidth, cfg.ListHeight)
+
+	return Usage{
+		list: l,
+	}
+}
+
+// The total size and pages of a list of series
+func usageTotals(all []backend.Manga) (int64, int) {
+	var bytes int64
+	pages := 0
+	for _, v := range all {
+		bytes += v.Summary.Bytes
+		pages += v.Summary.Pages
+	}
+	return bytes, pages
+}
+
+// Switch to the disk usage view, showing every series largest first.
+func usageOpen(m model) model {
+	all := m.store.GetAll(m.ctx)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Summary.Bytes > all[j].Summary.Bytes })
+
+	items := make([]list.Item, 0)
+	for _, v := range all {
+		items = append(items, list.Item(usageItem{v}))
+	}
+
+	bytes, pages := usageTotals(all)
+	m.usage.list.Title = fmt.Sprintf("Disk usage: %s, %d pages", backend.FormatBytes(bytes), pages)
+	m.usage.list.ResetFilter()
+	m.usage.list.SetItems(items)
+	m.usage.list.Select(m.usage.selected)
+	m.usage.volumes = false
+	m.view = usage
+	return m
+}
+
+// Show the volumes of a series, largest first.
+func usageOpenSeries(m model, manga backend.Manga) model {
+	items := make([]list.Item, 0)
+	for _, v := range backend.SeriesUsage(m.store.GetChapters(m.ctx, manga.MangaID)) {
+		items = append(items, list.Item(v))
+	}
+
+	m.usage.selected = m.usage.list.Index()
+	m.usage.list.Title = fmt.Sprintf("Disk usage: %s, %s", manga.SerTitle, backend.FormatBytes(manga.Summary.Bytes))
+	m.usage.list.ResetFilter()
+	m.usage.list.SetItems(items)
+	m.usage.volumes = true
+	return m
+}
+
+// Exit the disk usage view back to the library
+func usageExit(m model) model {
+	m.view = library
+	m.usage.selected = 0
+	m.usage.list.SetItems([]list.Item{})
+	m.usage.list.ResetFilter()
+	return m
+}
+
+// Overall Usage update function
+func UsageUpdate(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.usage.list.FilterState() == list.Filtering {
+			break
+		}
+		switch msg.String() {
+		case "enter":
+			if u, ok := m.usage.list.SelectedItem().(usageItem); ok && !m.usage.volumes {
+				return usageOpenSeries(m, u.Manga), nil
+			}
+			return m, nil
+		case "q", "esc":
+			if m.usage.list.FilterState() != list.Unfiltered {
+				break
+			}
+			if m.usage.volumes {
+				return usageOpen(m), nil
+			}
+			return usageExit(m), nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.usage.list, cmd = m.usage.list.Update(msg)
+	return m, cmd
+}
+
+// Overall Usage view function
+func UsageView(m model) string {
+	return m.usage.list.View()
+}
@@ -0,0 +1,137 @@
+package opds
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"time"
+)
+
+// The media types of OPDS catalog feeds
+const (
+	navigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	acquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	searchType      = "application/opensearchdescription+xml"
+)
+
+// The link relations OPDS and OPDS-PSE add to Atom
+const (
+	relAcquisition = "http://opds-spec.org/acquisition"
+	relImage       = "http://opds-spec.org/image"
+	relThumbnail   = "http://opds-spec.org/image/thumbnail"
+	relStream      = "http://vaemendis.net/opds-pse/stream"
+)
+
+// An Atom feed, which is what an OPDS catalog is made of
+type feed struct {
+	XMLName xml.Name `xml:"feed"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Dcterms string   `xml:"xmlns:dcterms,attr"`
+	PSE     string   `xml:"xmlns:pse,attr"`
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Author  *author  `xml:"author,omitempty"`
+	Links   []link   `xml:"link"`
+	Entries []entry  `xml:"entry"`
+}
+
+type author struct {
+	Name string `xml:"name"`
+}
+
+type link struct {
+	Rel      string `xml:"rel,attr,omitempty"`
+	Href     string `xml:"href,attr"`
+	Type     string `xml:"type,attr,omitempty"`
+	Title    string `xml:"title,attr,omitempty"`
+	PSECount int    `xml:"pse:count,attr,omitempty"` // The pages of a chapter streamed with OPDS-PSE
+}
+
+type category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type content struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type entry struct {
+	Title      string     `xml:"title"`
+	ID         string     `xml:"id"`
+	Updated    string     `xml:"updated"`
+	Author     *author    `xml:"author,omitempty"`
+	Language   string     `xml:"dcterms:language,omitempty"`
+	Issued     string     `xml:"dcterms:issued,omitempty"`
+	Categories []category `xml:"category"`
+	Content    *content   `xml:"content,omitempty"`
+	Links      []link     `xml:"link"`
+}
+
+// Start a feed with the links every feed of the catalog has
+func newFeed(id, title, self, kind string) feed {
+	return feed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		Dcterms: "http://purl.org/dc/terms/",
+		PSE:     "http://vaemendis.net/opds-pse/ns",
+		ID:      "urn:gomangatool:" + id,
+		Title:   title,
+		Updated: atomTime(time.Now()),
+		Author:  &author{"gomangatool"},
+		Links: []link{
+			{Rel: "self", Href: self, Type: kind},
+			{Rel: "start", Href: "/opds", Type: navigationType},
+			{Rel: "search", Href: "/opds/opensearch.xml", Type: searchType},
+		},
+		Entries: make([]entry, 0),
+	}
+}
+
+// An entry of a navigation feed, leading to another feed
+func navEntry(id, title, text, href, kind string) entry {
+	e := entry{
+		Title:   title,
+		ID:      "urn:gomangatool:" + id,
+		Updated: atomTime(time.Now()),
+		Links:   []link{{Rel: "subsection", Href: href, Type: kind}},
+	}
+	if text != "" {
+		e.Content = &content{"text", text}
+	}
+	return e
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Write a feed as the response
+func writeFeed(w http.ResponseWriter, f feed) {
+	kind := f.Links[0].Type
+	w.Header().Set("Content-Type", kind+";charset=utf-8")
+	writeXML(w, f)
+}
+
+// Write XML as the response, after the content type is set
+func writeXML(w http.ResponseWriter, v any) {
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("%s: Failed to write the response", err)
+	}
+}
+
+// The OpenSearch description, which tells readers how to search the catalog
+type openSearch struct {
+	XMLName     xml.Name `xml:"OpenSearchDescription"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	ShortName   string   `xml:"ShortName"`
+	Description string   `xml:"Description"`
+	URL         struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	} `xml:"Url"`
+}
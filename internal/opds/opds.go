@@ -0,0 +1,278 @@
+// Package opds serves the library as an OPDS 1.2 catalog, so that reader
+// apps on phones and tablets can browse it and download chapters.
+// Chapters are packed into books as they are requested, and their pages
+// can also be streamed one at a time with OPDS-PSE.
+package opds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/export"
+)
+
+// Serves the catalog from a Store.
+// Only downloaded chapters are offered, so that serving never waits on MangaDex.
+type Server struct {
+	ctx   context.Context // Cancelled when the server stops
+	store backend.Store
+	opts  backend.Options
+}
+
+// Make a Server that uses the Store until ctx is cancelled.
+// Requests use ctx rather than their own context, since the Store exits
+// on a cancelled context, and an app going away shouldn't stop the server.
+func NewServer(ctx context.Context, store backend.Store, opts backend.Options) *Server {
+	return &Server{ctx: ctx, store: store, opts: opts}
+}
+
+// The routes of the catalog, all under /opds
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /opds", s.root)
+	mux.HandleFunc("GET /opds/opensearch.xml", s.openSearch)
+	mux.HandleFunc("GET /opds/search", s.search)
+	mux.HandleFunc("GET /opds/series", s.allSeries)
+	mux.HandleFunc("GET /opds/recent", s.recent)
+	mux.HandleFunc("GET /opds/collections", s.collections)
+	mux.HandleFunc("GET /opds/collections/{id}", s.collection)
+	mux.HandleFunc("GET /opds/tags", s.tags)
+	mux.HandleFunc("GET /opds/tags/{id}", s.tag)
+	mux.HandleFunc("GET /opds/series/{id}", s.series)
+	mux.HandleFunc("GET /opds/series/{id}/{hash}/pages/{page}", s.page)
+	mux.HandleFunc("GET /opds/series/{id}/{hash}/{format}", s.book)
+	return mux
+}
+
+// The start of the catalog, leading to the ways of browsing it
+func (s *Server) root(w http.ResponseWriter, r *http.Request) {
+	f := newFeed("root", "Manga library", "/opds", navigationType)
+	f.Entries = append(f.Entries,
+		navEntry("series", "All series", "Every series in the library, by title", "/opds/series", navigationType),
+		navEntry("recent", "Recently updated", "Series with new chapters first", "/opds/recent", navigationType),
+		navEntry("collections", "Collections", "The series in each collection", "/opds/collections", navigationType),
+		navEntry("tags", "Tags", "The series with each tag", "/opds/tags", navigationType))
+	writeFeed(w, f)
+}
+
+func (s *Server) openSearch(w http.ResponseWriter, r *http.Request) {
+	o := openSearch{
+		Xmlns:       "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:   "gomangatool",
+		Description: "Search titles, descriptions and reviews",
+	}
+	o.URL.Type = navigationType
+	o.URL.Template = "/opds/search?q={searchTerms}"
+	w.Header().Set("Content-Type", searchType+";charset=utf-8")
+	writeXML(w, o)
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	var found []backend.Manga
+	if q != "" {
+		found = s.store.Search(s.ctx, q)
+	}
+	s.writeSeries(w, "search", "Search: "+q, r.URL.RequestURI(), found)
+}
+
+func (s *Server) allSeries(w http.ResponseWriter, r *http.Request) {
+	all := s.store.GetAll(s.ctx)
+	slices.SortFunc(all, func(a, b backend.Manga) int { return strings.Compare(a.FullTitle, b.FullTitle) })
+	s.writeSeries(w, "series", "All series", "/opds/series", all)
+}
+
+func (s *Server) recent(w http.ResponseWriter, r *http.Request) {
+	all := s.store.GetAll(s.ctx)
+	slices.SortStableFunc(all, func(a, b backend.Manga) int { return b.TimeModified.Compare(a.TimeModified) })
+	s.writeSeries(w, "recent", "Recently updated", "/opds/recent", all)
+}
+
+func (s *Server) collections(w http.ResponseWriter, r *http.Request) {
+	f := newFeed("collections", "Collections", "/opds/collections", navigationType)
+	for _, c := range s.store.GetCollections(s.ctx) {
+		f.Entries = append(f.Entries, navEntry(fmt.Sprintf("collection:%d", c.CollectionID), c.Name, "",
+			fmt.Sprintf("/opds/collections/%d", c.CollectionID), navigationType))
+	}
+	writeFeed(w, f)
+}
+
+func (s *Server) collection(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	collections := s.store.GetCollections(s.ctx)
+	i := slices.IndexFunc(collections, func(c backend.Collection) bool { return c.CollectionID == id })
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	c := collections[i]
+	s.writeSeries(w, fmt.Sprintf("collection:%d", id), c.Name, r.URL.Path, s.store.GetCollectionManga(s.ctx, c))
+}
+
+func (s *Server) tags(w http.ResponseWriter, r *http.Request) {
+	f := newFeed("tags", "Tags", "/opds/tags", navigationType)
+	for _, t := range s.store.GetTags(s.ctx) {
+		f.Entries = append(f.Entries, navEntry(fmt.Sprintf("tag:%d", t.TagID), t.TagTitle,
+			fmt.Sprintf("%d series", t.Series), fmt.Sprintf("/opds/tags/%d", t.TagID), navigationType))
+	}
+	writeFeed(w, f)
+}
+
+func (s *Server) tag(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	tags := s.store.GetTags(s.ctx)
+	i := slices.IndexFunc(tags, func(t backend.TagCount) bool { return t.TagID == id })
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	t := tags[i]
+	tagged := s.store.FilterManga(s.ctx, backend.TagFilter(t.TagTitle))
+	slices.SortFunc(tagged, func(a, b backend.Manga) int { return strings.Compare(a.FullTitle, b.FullTitle) })
+	s.writeSeries(w, fmt.Sprintf("tag:%d", id), t.TagTitle, r.URL.Path, tagged)
+}
+
+// Write a navigation feed listing series, each leading to its chapters
+func (s *Server) writeSeries(w http.ResponseWriter, id, title, self string, all []backend.Manga) {
+	f := newFeed(id, title, self, navigationType)
+	for _, m := range all {
+		e := navEntry("series:"+m.MangaID, m.FullTitle, m.Descr, "/opds/series/"+m.MangaID, acquisitionType)
+		e.Updated = atomTime(m.TimeModified)
+		if m.Author != "" {
+			e.Author = &author{m.Author}
+		}
+		for _, t := range m.Tags {
+			e.Categories = append(e.Categories, category{t.TagTitle, t.TagTitle})
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	writeFeed(w, f)
+}
+
+// Find a series by its ID, without failing on IDs that aren't in the library
+func (s *Server) findSeries(r *http.Request) (backend.Manga, bool) {
+	return backend.FindManga(s.ctx, s.store, r.PathValue("id"))
+}
+
+// Find a downloaded chapter of a series by its hash
+func (s *Server) findChapter(r *http.Request) (backend.Manga, backend.Chapter, bool) {
+	m, ok := s.findSeries(r)
+	if !ok {
+		return m, backend.Chapter{}, false
+	}
+	i := slices.IndexFunc(m.Chapters, func(c backend.Chapter) bool {
+		return c.ChapterHash == r.PathValue("hash") && c.Downloaded
+	})
+	if i < 0 {
+		return m, backend.Chapter{}, false
+	}
+	return m, m.Chapters[i], true
+}
+
+// The acquisition feed of a series: its downloaded chapters, in every export
+// format, with their pages for streaming
+func (s *Server) series(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.findSeries(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	self := "/opds/series/" + m.MangaID
+	f := newFeed("series:"+m.MangaID, m.FullTitle, self, acquisitionType)
+	f.Links = append(f.Links, link{Rel: "up", Href: "/opds/series", Type: navigationType})
+	// CBZ first, since it's what comic readers want
+	formats := []export.Format{export.CBZ, export.EPUB, export.PDF}
+
+	for _, c := range m.Chapters {
+		if !c.Downloaded {
+			continue
+		}
+		href := fmt.Sprintf("%s/%s", self, c.ChapterHash)
+		// Chapters fetched before publish times were kept go by the series instead
+		if c.TimePublished.IsZero() {
+			c.TimePublished = m.TimeModified
+		}
+		e := entry{
+			Title:    fmt.Sprintf("%s %s", c.Number(), c.ChapterName),
+			ID:       "urn:gomangatool:chapter:" + c.ChapterHash,
+			Updated:  atomTime(c.TimePublished),
+			Language: c.Language,
+			Links: []link{
+				{Rel: relImage, Href: href + "/pages/0", Type: "image/jpeg"},
+				{Rel: relThumbnail, Href: href + "/pages/0", Type: "image/jpeg"},
+			},
+		}
+		if c.VolumeNum > 0 {
+			e.Title = fmt.Sprintf("Vol. %d Ch. %s", c.VolumeNum, e.Title)
+		}
+		if m.Author != "" {
+			e.Author = &author{m.Author}
+		}
+		if c.GroupName != "" {
+			e.Content = &content{"text", "Translated by " + c.GroupName}
+		}
+		for _, format := range formats {
+			e.Links = append(e.Links, link{Rel: relAcquisition, Href: href + "/" + format.Name, Type: format.MediaType,
+				Title: strings.ToUpper(format.Name)})
+		}
+		if c.PageCount > 0 {
+			e.Links = append(e.Links, link{Rel: relStream, Href: href + "/pages/{pageNumber}", Type: "image/jpeg",
+				PSECount: c.PageCount})
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	writeFeed(w, f)
+}
+
+// Pack a chapter into a book and send it
+func (s *Server) book(w http.ResponseWriter, r *http.Request) {
+	format, ok := export.Formats[r.PathValue("format")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	m, c, ok := s.findChapter(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	b, pages, err := export.Prepare(s.ctx, s.store, s.opts, export.ChapterBook(m, c))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.MediaType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": b.Name() + "." + format.Name}))
+	if err := format.Write(w, b, pages); err != nil {
+		// Too late to tell the reader, since the book is partly sent
+		log.Printf("%s: Failed to send %s", err, b.Name())
+	}
+}
+
+// Send a single page of a chapter, counting from 0, for OPDS-PSE
+func (s *Server) page(w http.ResponseWriter, r *http.Request) {
+	m, c, ok := s.findChapter(r)
+	n, err := strconv.Atoi(r.PathValue("page"))
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	pages, err := export.ChapterBook(m, c).Pages(s.opts.Layout)
+	if err != nil || n < 0 || n >= len(pages) {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, pages[n].Path)
+}
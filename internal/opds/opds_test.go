@@ -0,0 +1,162 @@
+package opds
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/twells46/gomangatool/internal/backend"
+)
+
+// Two series tagged Action, one of them with a downloaded chapter of two pages
+const testLibrary = `{
+	"version": 1,
+	"manga": [
+		{
+			"mangaId": "manga-a", "serTitle": "alpha", "fullTitle": "Alpha",
+			"timeModified": "2024-03-01T00:00:00Z", "demographic": "Shounen", "pubStatus": "Ongoing",
+			"tags": ["Action", "Comedy"],
+			"chapters": [
+				{"chapterHash": "hash-a1", "chapterRaw": "1", "chapterName": "Start", "volumeNum": 1,
+					"language": "en", "published": "2024-02-01T00:00:00Z"},
+				{"chapterHash": "hash-a2", "chapterRaw": "2", "chapterName": "Next", "volumeNum": 1,
+					"language": "en", "published": "2024-02-08T00:00:00Z"}
+			]
+		},
+		{
+			"mangaId": "manga-b", "serTitle": "beta", "fullTitle": "Beta",
+			"timeModified": "2024-05-01T00:00:00Z", "demographic": "Seinen", "pubStatus": "Completed",
+			"tags": ["Action"]
+		}
+	]
+}`
+
+func testServer(t *testing.T) (http.Handler, backend.Store) {
+	t.Helper()
+	ctx := context.Background()
+	layout, err := backend.NewLayout(t.TempDir(), backend.DefaultChapterTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Put the first chapter on disk where the import will look for it
+	m := backend.Manga{MangaID: "manga-a", SerTitle: "alpha", FullTitle: "Alpha"}
+	c := backend.Chapter{ChapterHash: "hash-a1", ChapterNum: 1, ChapterRaw: "1", VolumeNum: 1, MangaID: m.MangaID, Language: "en"}
+	c.ChapterPath = layout.ChapterPath(m, c)
+	if err := os.MkdirAll(layout.Abs(c), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1.png", "2.png"} {
+		f, err := os.Create(filepath.Join(layout.Abs(c), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 4, 6))); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	store := backend.NewMemory()
+	if _, err := backend.ImportLibrary(ctx, store, layout, strings.NewReader(testLibrary), false); err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(ctx, store, backend.Options{Layout: layout}).Handler(), store
+}
+
+// Make a request, checking its status, and return the body
+func get(t *testing.T, h http.Handler, path string, want int) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != want {
+		t.Errorf("GET %s: got %d, want %d", path, w.Code, want)
+	}
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestFeeds(t *testing.T) {
+	h, store := testServer(t)
+
+	root := get(t, h, "/opds", http.StatusOK)
+	for _, href := range []string{"/opds/series", "/opds/recent", "/opds/collections", "/opds/tags"} {
+		if !strings.Contains(root, `href="`+href+`"`) {
+			t.Errorf("Root doesn't link to %s", href)
+		}
+	}
+
+	// Beta got new chapters last, so it comes first
+	recent := get(t, h, "/opds/recent", http.StatusOK)
+	if a, b := strings.Index(recent, "<title>Alpha</title>"), strings.Index(recent, "<title>Beta</title>"); a < 0 || b < 0 || b > a {
+		t.Errorf("Recent has Alpha at %d and Beta at %d, want Beta first", a, b)
+	}
+	for _, updated := range []string{"2024-03-01T00:00:00Z", "2024-05-01T00:00:00Z"} {
+		if !strings.Contains(recent, "<updated>"+updated+"</updated>") {
+			t.Errorf("Recent doesn't have a series updated %s", updated)
+		}
+	}
+
+	// Only the downloaded chapter is offered, updated when it was published
+	series := get(t, h, "/opds/series/manga-a", http.StatusOK)
+	if !strings.Contains(series, "urn:gomangatool:chapter:hash-a1") || strings.Contains(series, "hash-a2") {
+		t.Error("Series feed should only have the downloaded chapter")
+	}
+	if !strings.Contains(series, "<updated>2024-02-01T00:00:00Z</updated>") {
+		t.Error("Chapter isn't updated when it was published")
+	}
+	if !strings.Contains(series, `pse:count="2"`) {
+		t.Error("Chapter doesn't stream its 2 pages")
+	}
+	get(t, h, "/opds/series/nothing", http.StatusNotFound)
+
+	tags := get(t, h, "/opds/tags", http.StatusOK)
+	for _, want := range []string{"<title>Action</title>", "2 series", "<title>Comedy</title>", "1 series"} {
+		if !strings.Contains(tags, want) {
+			t.Errorf("Tags feed doesn't have %s", want)
+		}
+	}
+	all := store.GetTags(context.Background())
+	i := slices.IndexFunc(all, func(t backend.TagCount) bool { return t.TagTitle == "Comedy" })
+	if i < 0 {
+		t.Fatalf("No Comedy tag in %v", all)
+	}
+	comedy := get(t, h, "/opds/tags/"+strconv.Itoa(all[i].TagID), http.StatusOK)
+	if !strings.Contains(comedy, "<title>Alpha</title>") || strings.Contains(comedy, "<title>Beta</title>") {
+		t.Error("Comedy should only list Alpha")
+	}
+	get(t, h, "/opds/tags/999", http.StatusNotFound)
+	get(t, h, "/opds/tags/x", http.StatusNotFound)
+}
+
+func TestPages(t *testing.T) {
+	h, _ := testServer(t)
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/opds/series/manga-a/hash-a1/pages/0", http.StatusOK},
+		{"/opds/series/manga-a/hash-a1/pages/1", http.StatusOK},
+		{"/opds/series/manga-a/hash-a1/pages/2", http.StatusNotFound},
+		{"/opds/series/manga-a/hash-a1/pages/-1", http.StatusNotFound},
+		{"/opds/series/manga-a/hash-a1/pages/first", http.StatusNotFound},
+		{"/opds/series/manga-a/hash-a2/pages/0", http.StatusNotFound}, // Not downloaded
+		{"/opds/series/manga-b/hash-a1/pages/0", http.StatusNotFound}, // Another series' chapter
+		{"/opds/series/nothing/hash-a1/pages/0", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		get(t, h, tt.path, tt.want)
+	}
+	if page := get(t, h, "/opds/series/manga-a/hash-a1/pages/0", http.StatusOK); !strings.HasPrefix(page, "\x89PNG") {
+		t.Error("First page isn't the PNG on disk")
+	}
+}
@@ -0,0 +1,69 @@
+// Page turning for the reader. Pages are read right to left, so the left
+// side of the page and the left arrow key go forward.
+// Each page turned to is saved as the chapter's progress, and the whole
+// session is recorded when the reader is left.
+(function () {
+  const reader = document.getElementById("reader");
+  const img = document.getElementById("page");
+  const counter = document.getElementById("counter");
+  const base = reader.dataset.base;
+  const pagesURL = reader.dataset.pagesUrl;
+  const pages = Number(reader.dataset.pages);
+  const from = Number(reader.dataset.page);
+  const start = Date.now();
+  let page = from;
+
+  function post(path, data) {
+    return fetch(base + path, { method: "POST", body: new URLSearchParams(data) });
+  }
+
+  function show(n) {
+    if (n < 1) {
+      return;
+    }
+    if (n > pages) {
+      // Past the last page is the next chapter, or back to the series
+      location.href = reader.dataset.next;
+      return;
+    }
+    page = n;
+    img.src = pagesURL + page;
+    img.alt = "Page " + page;
+    counter.textContent = page + " / " + pages;
+    history.replaceState(null, "", "?page=" + page);
+    window.scrollTo(0, 0);
+    post("/progress", { page: page });
+    if (page < pages) {
+      new Image().src = pagesURL + (page + 1);
+    }
+  }
+
+  img.addEventListener("click", function (e) {
+    const rect = img.getBoundingClientRect();
+    show(e.clientX < rect.left + rect.width / 2 ? page + 1 : page - 1);
+  });
+
+  document.addEventListener("keydown", function (e) {
+    switch (e.key) {
+      case "ArrowLeft":
+      case " ":
+        e.preventDefault();
+        show(page + 1);
+        break;
+      case "ArrowRight":
+      case "Backspace":
+        e.preventDefault();
+        show(page - 1);
+        break;
+    }
+  });
+
+  window.addEventListener("pagehide", function () {
+    navigator.sendBeacon(base + "/session", new URLSearchParams({ start: start, from: from, page: page }));
+  });
+
+  post("/progress", { page: page });
+  if (page < pages) {
+    new Image().src = pagesURL + (page + 1);
+  }
+})();
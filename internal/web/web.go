@@ -0,0 +1,300 @@
+// Package web serves a small web UI for browsing the library and reading
+// downloaded chapters page by page in a browser.
+// Reading progress is recorded in the Store the same way as the TUI's
+// reader, so it doesn't matter where a chapter is read.
+package web
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twells46/gomangatool/internal/backend"
+)
+
+//go:embed templates static
+var files embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}).ParseFS(files, "templates/*.html"))
+
+// Serves the web UI from a Store.
+type Server struct {
+	ctx   context.Context // Cancelled when the server stops
+	store backend.Store
+	opts  backend.Options
+	dl    sync.Mutex // Held while downloading, so downloads keep to the rate limit
+}
+
+// Make a Server that uses the Store until ctx is cancelled.
+// Requests use ctx rather than their own context, since the Store exits
+// on a cancelled context, and a browser going away shouldn't stop the server
+// or leave a download half done.
+func NewServer(ctx context.Context, store backend.Store, opts backend.Options) *Server {
+	return &Server{ctx: ctx, store: store, opts: opts}
+}
+
+// The routes of the web UI
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /static/", http.FileServerFS(files))
+	mux.HandleFunc("GET /{$}", s.library)
+	mux.HandleFunc("GET /series/{id}", s.series)
+	mux.HandleFunc("POST /series/{id}/{hash}/download", s.download)
+	mux.HandleFunc("POST /series/{id}/{hash}/read", s.markRead)
+	mux.HandleFunc("GET /read/{id}/{hash}", s.reader)
+	mux.HandleFunc("POST /read/{id}/{hash}/progress", s.progress)
+	mux.HandleFunc("POST /read/{id}/{hash}/session", s.session)
+	mux.HandleFunc("GET /pages/{id}/{hash}/{page}", s.page)
+	return sameOrigin(mux)
+}
+
+// Refuse POST requests sent from other sites, which could otherwise
+// download chapters or change reading progress from any page the user visits.
+// Browsers say where a request came from in Sec-Fetch-Site, or failing that, Origin;
+// requests with neither don't come from a browser, so they can't be forged this way.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !fromSameOrigin(r) {
+			http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func fromSameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+type libraryData struct {
+	Query       string
+	Collections []backend.Collection
+	Collection  int // The collection being shown, 0 for the whole library
+	Series      []backend.Manga
+}
+
+// The series in the library or a collection, or those matching a search
+func (s *Server) library(w http.ResponseWriter, r *http.Request) {
+	d := libraryData{
+		Query:       strings.TrimSpace(r.FormValue("q")),
+		Collections: s.store.GetCollections(s.ctx),
+	}
+	d.Collection, _ = strconv.Atoi(r.FormValue("collection"))
+
+	i := slices.IndexFunc(d.Collections, func(c backend.Collection) bool { return c.CollectionID == d.Collection })
+	switch {
+	case d.Query != "":
+		d.Series = s.store.Search(s.ctx, d.Query)
+	case i >= 0:
+		d.Series = s.store.GetCollectionManga(s.ctx, d.Collections[i])
+	default:
+		d.Collection = 0
+		d.Series = s.store.GetAll(s.ctx)
+		slices.SortFunc(d.Series, func(a, b backend.Manga) int { return strings.Compare(a.FullTitle, b.FullTitle) })
+	}
+	render(w, "library.html", d)
+}
+
+type seriesData struct {
+	Manga    backend.Manga
+	Next     backend.Chapter // Where to continue reading
+	HasNext  bool
+	Chapters []backend.Chapter
+}
+
+// A series and its chapters
+func (s *Server) series(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.findSeries(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	d := seriesData{Manga: m, Chapters: m.Chapters}
+	d.Next, d.HasNext = m.NextUnread()
+	render(w, "series.html", d)
+}
+
+// Download a chapter, then start reading it
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	s.dl.Lock()
+	defer s.dl.Unlock()
+	// Looked up once the lock is held, in case a request it waited on downloaded it
+	_, c, ok := s.findChapter(r, false)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := backend.DownloadChapter(s.ctx, s.store, s.opts, c); err != nil {
+		log.Printf("%s: Failed to download chapter %s", err, c.ChapterHash)
+		http.Error(w, "Failed to download the chapter: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, readerURL(c), http.StatusSeeOther)
+}
+
+// Mark a chapter read without reading it
+func (s *Server) markRead(w http.ResponseWriter, r *http.Request) {
+	_, c, ok := s.findChapter(r, false)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.store.UpdateChapterRead(s.ctx, c)
+	http.Redirect(w, r, "/series/"+c.MangaID, http.StatusSeeOther)
+}
+
+type readerData struct {
+	Manga   backend.Manga
+	Chapter backend.Chapter
+	Page    int // The page to start on, counting from 1
+	Pages   int
+	Next    string // Where to go after the last page
+}
+
+// Read a downloaded chapter, starting on the page given, or where it was left
+func (s *Server) reader(w http.ResponseWriter, r *http.Request) {
+	m, c, ok := s.findChapter(r, true)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	d := readerData{Manga: m, Chapter: c, Pages: backend.PageCount(c, s.opts.Layout), Next: "/series/" + m.MangaID}
+	if d.Pages == 0 {
+		http.Error(w, "The chapter has no pages on disk", http.StatusNotFound)
+		return
+	}
+	d.Page, _ = strconv.Atoi(r.FormValue("page"))
+	if d.Page == 0 {
+		d.Page = c.LastPage
+	}
+	d.Page = min(max(d.Page, 1), d.Pages)
+	if next, ok := nextChapter(m.Chapters, c); ok && next.Downloaded {
+		d.Next = readerURL(next)
+	}
+	render(w, "reader.html", d)
+}
+
+// Record the page a reader turned to
+func (s *Server) progress(w http.ResponseWriter, r *http.Request) {
+	_, c, ok := s.findChapter(r, true)
+	page, err := strconv.Atoi(r.FormValue("page"))
+	if !ok || err != nil || page < 1 {
+		http.Error(w, "Bad page", http.StatusBadRequest)
+		return
+	}
+	s.store.UpdateChapterProgress(s.ctx, c, page, backend.IsFinalPage(c, s.opts.Layout, page))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Record a reading session, sent when the reader is closed:
+// when it started, in milliseconds since the epoch, and the pages it went from and to
+func (s *Server) session(w http.ResponseWriter, r *http.Request) {
+	_, c, ok := s.findChapter(r, true)
+	start, err1 := strconv.ParseInt(r.FormValue("start"), 10, 64)
+	from, err2 := strconv.Atoi(r.FormValue("from"))
+	page, err3 := strconv.Atoi(r.FormValue("page"))
+	if !ok || err1 != nil || err2 != nil || err3 != nil || page < 1 {
+		http.Error(w, "Bad session", http.StatusBadRequest)
+		return
+	}
+
+	e := backend.ReadEvent{
+		ChapterHash: c.ChapterHash,
+		Start:       time.UnixMilli(start),
+		End:         time.Now(),
+		PagesViewed: max(page-from, 0) + 1,
+	}
+	// The browser's clock may be ahead
+	if e.Start.After(e.End) {
+		e.Start = e.End
+	}
+	backend.RecordReading(s.ctx, s.store, s.opts, c, e, page)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Send a page image, counting from 1
+func (s *Server) page(w http.ResponseWriter, r *http.Request) {
+	_, c, ok := s.findChapter(r, true)
+	n, err := strconv.Atoi(r.PathValue("page"))
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	pages := backend.PagePaths(c, s.opts.Layout)
+	if n < 1 || n > len(pages) {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, pages[n-1])
+}
+
+// Find a series by its ID, without failing on IDs that aren't in the library
+func (s *Server) findSeries(r *http.Request) (backend.Manga, bool) {
+	return backend.FindManga(s.ctx, s.store, r.PathValue("id"))
+}
+
+// Find a chapter of a series by its hash, only if it's downloaded if downloaded is set
+func (s *Server) findChapter(r *http.Request, downloaded bool) (backend.Manga, backend.Chapter, bool) {
+	m, ok := s.findSeries(r)
+	if !ok {
+		return m, backend.Chapter{}, false
+	}
+	i := slices.IndexFunc(m.Chapters, func(c backend.Chapter) bool {
+		return c.ChapterHash == r.PathValue("hash") && (c.Downloaded || !downloaded)
+	})
+	if i < 0 {
+		return m, backend.Chapter{}, false
+	}
+	return m, m.Chapters[i], true
+}
+
+// The chapter after c in reading order, skipping other versions of c.
+// Of the versions of the next chapter, a downloaded one is preferred.
+func nextChapter(chapters []backend.Chapter, c backend.Chapter) (backend.Chapter, bool) {
+	i := slices.IndexFunc(chapters, func(o backend.Chapter) bool { return o.ChapterHash == c.ChapterHash })
+	for j := i + 1; j < len(chapters); j++ {
+		if chapters[j].SortKey == c.SortKey {
+			continue
+		}
+		next := chapters[j]
+		for _, o := range chapters[j:] {
+			if o.SortKey == next.SortKey && o.Downloaded {
+				return o, true
+			}
+		}
+		return next, true
+	}
+	return backend.Chapter{}, false
+}
+
+func readerURL(c backend.Chapter) string {
+	return fmt.Sprintf("/read/%s/%s", c.MangaID, c.ChapterHash)
+}
+
+// Render a template as the response
+func render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("%s: Failed to render %s", err, name)
+	}
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/twells46/gomangatool/internal/backend"
+)
+
+func TestSameOrigin(t *testing.T) {
+	h := sameOrigin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		name, method string
+		site, origin string
+		want         int
+	}{
+		{"same origin", http.MethodPost, "same-origin", "http://127.0.0.1:8780", http.StatusNoContent},
+		{"typed in", http.MethodPost, "none", "", http.StatusNoContent},
+		{"other site", http.MethodPost, "cross-site", "http://evil.example", http.StatusForbidden},
+		{"same site, other origin", http.MethodPost, "same-site", "http://other.127.0.0.1:8780", http.StatusForbidden},
+		{"origin only", http.MethodPost, "", "http://127.0.0.1:8780", http.StatusNoContent},
+		{"other origin only", http.MethodPost, "", "http://evil.example", http.StatusForbidden},
+		{"bad origin", http.MethodPost, "", "://", http.StatusForbidden},
+		{"not a browser", http.MethodPost, "", "", http.StatusNoContent},
+		{"cross-site GET", http.MethodGet, "cross-site", "http://evil.example", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://127.0.0.1:8780/read/a/b/progress", nil)
+		if tt.site != "" {
+			r.Header.Set("Sec-Fetch-Site", tt.site)
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+// Unknown series and chapters are client errors, not failures of the server
+func TestUnknownSeries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	layout, err := backend.NewLayout(t.TempDir(), backend.DefaultChapterTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewServer(ctx, backend.NewMemory(), backend.Options{Layout: layout}).Handler()
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/series/nothing", http.StatusNotFound},
+		{http.MethodGet, "/read/nothing/chapter", http.StatusNotFound},
+		{http.MethodGet, "/pages/nothing/chapter/1", http.StatusNotFound},
+		{http.MethodPost, "/series/nothing/chapter/download", http.StatusNotFound},
+		{http.MethodPost, "/series/nothing/chapter/read", http.StatusNotFound},
+		{http.MethodPost, "/read/nothing/chapter/progress?page=1", http.StatusBadRequest},
+		{http.MethodPost, "/read/nothing/chapter/session?start=0&from=1&page=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -1,18 +1,106 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/twells46/gomangatool/internal/backend"
+	"github.com/twells46/gomangatool/internal/config"
+	"github.com/twells46/gomangatool/internal/export"
 	"github.com/twells46/gomangatool/internal/frontend"
 )
 
 func main() {
-	f, _ := tea.LogToFile("debug.log", "debug")
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		usage()
+		return
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+	opts := backendOptions(cfg)
+
+	// Anything left on the command line is a subcommand, otherwise start the TUI
+	if len(args) > 0 {
+		runCommand(ctx, cfg, opts, args[0], args[1:])
+		return
+	}
+
+	f, _ := tea.LogToFile(cfg.LogPath, "debug")
 	defer f.Close()
 
-	p := tea.NewProgram(frontend.InitModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	store := openStore(ctx, cfg, opts)
+	// Clean up read chapters before the library is loaded, so it shows what's left
+	if rep := backend.Cleanup(ctx, store, opts, opts.Retention, time.Now(), false); len(rep.Items) > 0 {
+		log.Print(rep)
+	}
+
+	p := tea.NewProgram(frontend.InitModel(ctx, store, opts, cfg), tea.WithAltScreen(), tea.WithContext(ctx))
+	// Being interrupted is a normal way to quit
+	if _, err := p.Run(); err != nil && !errors.Is(err, tea.ErrProgramKilled) {
 		log.Fatalln(err)
 	}
 }
+
+// Build the backend Options from the config, checking the settings that only
+// the backend and export packages understand. Every problem found is reported together.
+func backendOptions(cfg config.Config) backend.Options {
+	errs := make([]error, 0)
+
+	tmpl := cfg.ChapterPath
+	if tmpl == "" {
+		tmpl = backend.DefaultChapterTemplate
+	}
+	layout, err := backend.NewLayout(cfg.LibraryRoot, tmpl)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	retention, err := backend.ParseRetention(cfg.Retention)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	processing, err := backend.ParseProcessing(cfg.Processing)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, ok := export.Formats[cfg.ExportFormat]; !ok {
+		errs = append(errs, fmt.Errorf("exportFormat %q isn't a format that can be exported", cfg.ExportFormat))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		log.Fatalln(err)
+	}
+
+	return backend.Options{
+		Layout:           layout,
+		Languages:        cfg.Languages,
+		DownloadInterval: time.Duration(cfg.DownloadInterval),
+		Reader:           cfg.Reader,
+		Retention:        retention,
+		Processing:       processing,
+	}
+}
+
+// Open the DB, moving any chapter paths stored before they were relative under the library root,
+// and the first time, measuring any chapters downloaded before their sizes were kept.
+func openStore(ctx context.Context, cfg config.Config, opts backend.Options) *backend.SQLite {
+	store := backend.Opendb(ctx, cfg.DBPath)
+	store.MigratePaths(ctx, opts.Layout.Root)
+	if n := store.MeasureChapters(ctx, opts.Layout); n > 0 {
+		log.Printf("Measured the disk usage of %d chapters", n)
+	}
+	return store
+}